@@ -0,0 +1,68 @@
+package a
+
+import (
+	"github.com/peletor/treiber/deque"
+	"github.com/peletor/treiber/queue"
+	"github.com/peletor/treiber/stack"
+)
+
+type jobs struct {
+	pending queue.Queue
+}
+
+func consume(q queue.Queue) {}
+
+func copies(q *queue.Queue, all []queue.Queue, j *jobs) queue.Queue {
+	var zero queue.Queue // fresh, not a copy
+	fresh := queue.Queue{}
+	zero.Push(1)
+	fresh.Push(1)
+
+	c := *q         // want `copies a queue.Queue; pass a pointer instead`
+	d := all[0]     // want `copies a queue.Queue; pass a pointer instead`
+	e := j.pending  // want `copies a queue.Queue; pass a pointer instead`
+	consume(zero)   // want `copies a queue.Queue; pass a pointer instead`
+	_ = jobs{fresh} // want `copies a queue.Queue; pass a pointer instead`
+	ch := make(chan queue.Queue, 1)
+	ch <- c                    // want `copies a queue.Queue; pass a pointer instead`
+	for _, each := range all { // want `range copies queue.Queue values; range over pointers or indexes`
+		each.Push(1)
+	}
+	for i := range all {
+		all[i].Push(1)
+	}
+	d.Push(1)
+	e.Push(1)
+	return zero // want `copies a queue.Queue; pass a pointer instead`
+}
+
+func constructors() {
+	q := *queue.NewQueue() // want `copies a queue.Queue; pass a pointer instead`
+	q.Push(1)
+	s := *stack.NewStack() // want `copies a stack.Stack; pass a pointer instead`
+	s.Push(1)
+
+	ok := queue.NewQueue()
+	ok.Push(1)
+}
+
+func unused() {
+	var q queue.Queue
+	consume(q) // want `copies a queue.Queue; pass a pointer instead`
+}
+
+func pops(q *queue.Queue, s *stack.Stack, d *deque.Deque) int {
+	a, _ := q.Pop()        // want `ok of queue.Queue.Pop is ignored; the zero value is returned when it is empty`
+	b, _ := s.Top()        // want `ok of stack.Stack.Top is ignored; the zero value is returned when it is empty`
+	var c, _ = d.PopBack() // want `ok of deque.Deque.PopBack is ignored; the zero value is returned when it is empty`
+	e, _ := q.Peek()       // want `ok of queue.Queue.Peek is ignored; the zero value is returned when it is empty`
+	f, _ := d.PeekFront()  // want `ok of deque.Deque.PeekFront is ignored; the zero value is returned when it is empty`
+
+	// fine: ok is checked, or the pop is only meant to discard an item
+	if v, ok := d.PopFront(); ok {
+		c += v
+	}
+	_, _ = q.Pop()
+	q.Pop()
+	return a + b + c + e + f
+}
@@ -0,0 +1,13 @@
+package deque
+
+type Deque struct{}
+
+func NewDeque() *Deque { return &Deque{} }
+
+func (d *Deque) PushBack(value int) {}
+
+func (d *Deque) PopBack() (value int, ok bool) { return 0, false }
+
+func (d *Deque) PopFront() (value int, ok bool) { return 0, false }
+
+func (d *Deque) PeekFront() (value int, ok bool) { return 0, false }
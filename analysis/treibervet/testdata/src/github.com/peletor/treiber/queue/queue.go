@@ -0,0 +1,11 @@
+package queue
+
+type Queue struct{}
+
+func NewQueue() *Queue { return &Queue{} }
+
+func (q *Queue) Push(value int) {}
+
+func (q *Queue) Pop() (value int, ok bool) { return 0, false }
+
+func (q *Queue) Peek() (value int, ok bool) { return 0, false }
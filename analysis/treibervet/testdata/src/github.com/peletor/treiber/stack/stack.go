@@ -0,0 +1,11 @@
+package stack
+
+type Stack struct{}
+
+func NewStack() *Stack { return &Stack{} }
+
+func (s *Stack) Push(value int) {}
+
+func (s *Stack) Pop() (value int, ok bool) { return 0, false }
+
+func (s *Stack) Top() (value int, ok bool) { return 0, false }
@@ -0,0 +1,151 @@
+// Package treibervet defines an analyzer that reports misuse of the
+// containers of this module:
+//
+//   - copying a Stack, Queue or Deque: the copy shares its items with the
+//     original, and pushes to one are lost to the other;
+//   - using the value of a pop or peek, Pop, Top, Peek, PopBack, PopFront,
+//     PeekBack or PeekFront, while discarding ok, since an empty container
+//     returns the zero value.
+//
+// A container is only unsafe to copy after its first use, but whether a
+// value was used cannot be told statically, so like copylocks the analyzer
+// reports every copy of an existing container, used or not. Composite
+// literals, zero values and call results are fresh values and are never
+// reported.
+package treibervet
+
+import (
+	"go/ast"
+	"go/types"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+)
+
+var Analyzer = &analysis.Analyzer{
+	Name:     "treibervet",
+	Doc:      "report copies of the lock-free containers and ignored ok results of their pops",
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+const module = "github.com/peletor/treiber/"
+
+// containers maps the package path of every container to its type.
+var containers = map[string]string{
+	module + "stack": "Stack",
+	module + "queue": "Queue",
+	module + "deque": "Deque",
+}
+
+var pops = map[string]bool{
+	"Pop": true, "Top": true, "Peek": true,
+	"PopBack": true, "PopFront": true, "PeekBack": true, "PeekFront": true,
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	insp := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+
+	nodes := []ast.Node{
+		(*ast.AssignStmt)(nil),
+		(*ast.ValueSpec)(nil),
+		(*ast.CallExpr)(nil),
+		(*ast.ReturnStmt)(nil),
+		(*ast.CompositeLit)(nil),
+		(*ast.RangeStmt)(nil),
+		(*ast.SendStmt)(nil),
+	}
+	insp.Preorder(nodes, func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			checkCopies(pass, n.Rhs...)
+			if len(n.Lhs) == 2 && len(n.Rhs) == 1 {
+				checkIgnoredOk(pass, n.Lhs, n.Rhs[0])
+			}
+		case *ast.ValueSpec:
+			checkCopies(pass, n.Values...)
+			if len(n.Names) == 2 && len(n.Values) == 1 {
+				checkIgnoredOk(pass, []ast.Expr{n.Names[0], n.Names[1]}, n.Values[0])
+			}
+		case *ast.CallExpr:
+			if tv, ok := pass.TypesInfo.Types[n.Fun]; ok && tv.IsType() {
+				return // a conversion
+			}
+			checkCopies(pass, n.Args...)
+		case *ast.ReturnStmt:
+			checkCopies(pass, n.Results...)
+		case *ast.CompositeLit:
+			for _, elt := range n.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					elt = kv.Value
+				}
+				checkCopies(pass, elt)
+			}
+		case *ast.RangeStmt:
+			if n.Value != nil {
+				if name, ok := container(pass.TypesInfo.TypeOf(n.Value)); ok {
+					pass.Reportf(n.Value.Pos(), "range copies %s values; range over pointers or indexes", name)
+				}
+			}
+		case *ast.SendStmt:
+			checkCopies(pass, n.Value)
+		}
+	})
+	return nil, nil
+}
+
+// container reports whether t is one of the container types, and its name.
+func container(t types.Type) (string, bool) {
+	named, ok := types.Unalias(t).(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return "", false
+	}
+	typ, ok := containers[named.Obj().Pkg().Path()]
+	if !ok || typ != named.Obj().Name() {
+		return "", false
+	}
+	return named.Obj().Pkg().Name() + "." + typ, true
+}
+
+// checkCopies reports the expressions that copy an existing container.
+// Composite literals and call results are fresh values, not copies.
+func checkCopies(pass *analysis.Pass, exprs ...ast.Expr) {
+	for _, expr := range exprs {
+		name, ok := container(pass.TypesInfo.TypeOf(expr))
+		if !ok {
+			continue
+		}
+		switch ast.Unparen(expr).(type) {
+		case *ast.StarExpr, *ast.Ident, *ast.SelectorExpr, *ast.IndexExpr:
+			pass.Reportf(expr.Pos(), "copies a %s; pass a pointer instead", name)
+		}
+	}
+}
+
+// checkIgnoredOk reports value, _ := c.Pop() and the like.
+func checkIgnoredOk(pass *analysis.Pass, lhs []ast.Expr, rhs ast.Expr) {
+	if !isBlank(lhs[1]) || isBlank(lhs[0]) {
+		return
+	}
+	call, ok := ast.Unparen(rhs).(*ast.CallExpr)
+	if !ok {
+		return
+	}
+	sel, ok := ast.Unparen(call.Fun).(*ast.SelectorExpr)
+	if !ok || !pops[sel.Sel.Name] {
+		return
+	}
+	recv := pass.TypesInfo.TypeOf(sel.X)
+	if ptr, ok := recv.(*types.Pointer); ok {
+		recv = ptr.Elem()
+	}
+	if name, ok := container(recv); ok {
+		pass.Reportf(lhs[1].Pos(), "ok of %s.%s is ignored; the zero value is returned when it is empty", name, sel.Sel.Name)
+	}
+}
+
+func isBlank(expr ast.Expr) bool {
+	id, ok := expr.(*ast.Ident)
+	return ok && id.Name == "_"
+}
@@ -0,0 +1,97 @@
+// Package backoff contains strategies for waiting after a failed CAS.
+package backoff
+
+import (
+	"math/rand/v2"
+	"runtime"
+)
+
+// Backoff is called by a container every time one of its CAS loops has to
+// retry. attempt counts the retries of the current operation starting at 0.
+// A Backoff is shared by all goroutines using the container, so it must be
+// safe for concurrent use.
+type Backoff interface {
+	Wait(attempt int)
+}
+
+// None retries immediately. It is what containers do without a Backoff.
+type None struct{}
+
+func (None) Wait(int) {}
+
+// Yield gives the processor to another goroutine before every retry.
+type Yield struct{}
+
+func (Yield) Wait(int) {
+	runtime.Gosched()
+}
+
+const (
+	defaultMinSpins = 4
+	defaultMaxSpins = 1024
+)
+
+// Exponential spins a random number of iterations in [1, Min<<attempt],
+// capped at Max. Zero fields fall back to 4 and 1024 iterations.
+type Exponential struct {
+	Min int
+	Max int
+}
+
+func (e Exponential) Wait(attempt int) {
+	spin(rand.IntN(e.limit(attempt)) + 1)
+}
+
+// limit is the most iterations Wait spins after attempt retries.
+func (e Exponential) limit(attempt int) int {
+	minSpins, maxSpins := e.Min, e.Max
+	if minSpins <= 0 {
+		minSpins = defaultMinSpins
+	}
+	if maxSpins <= 0 {
+		maxSpins = defaultMaxSpins
+	}
+
+	if attempt < 31 && minSpins<<attempt < maxSpins {
+		return minSpins << attempt
+	}
+	return maxSpins
+}
+
+// Proportional spins Factor*(attempt+1) iterations, capped at Max.
+// Zero fields fall back to 4 and 1024 iterations.
+type Proportional struct {
+	Factor int
+	Max    int
+}
+
+func (p Proportional) Wait(attempt int) {
+	spin(p.spins(attempt))
+}
+
+func (p Proportional) spins(attempt int) int {
+	factor, maxSpins := p.Factor, p.Max
+	if factor <= 0 {
+		factor = defaultMinSpins
+	}
+	if maxSpins <= 0 {
+		maxSpins = defaultMaxSpins
+	}
+
+	if attempt < maxSpins/factor {
+		return factor * (attempt + 1)
+	}
+	return maxSpins
+}
+
+// spin is a variable, so tests can count the iterations.
+var spin = func(n int) {
+	for i := 0; i < n; i++ {
+		pause()
+	}
+}
+
+// pause is kept out of line, so the spin loop is not optimised away.
+//
+//go:noinline
+func pause() {}
@@ -0,0 +1,102 @@
+package backoff
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBackoff(t *testing.T) {
+	strategies := map[string]Backoff{
+		"None":                 None{},
+		"Yield":                Yield{},
+		"Exponential":          Exponential{},
+		"Exponential custom":   Exponential{Min: 1, Max: 8},
+		"Proportional":         Proportional{},
+		"Proportional custom":  Proportional{Factor: 2, Max: 8},
+		"Exponential inverted": Exponential{Min: 64, Max: 8},
+	}
+
+	for name, strategy := range strategies {
+		t.Run(name, func(t *testing.T) {
+			assert.NotPanics(t, func() {
+				for attempt := 0; attempt < 100; attempt++ {
+					strategy.Wait(attempt)
+				}
+			})
+		})
+	}
+}
+
+// spins records the iterations of every Wait instead of spinning.
+func spins(t *testing.T) *[]int {
+	var counts []int
+	saved := spin
+	spin = func(n int) { counts = append(counts, n) }
+	t.Cleanup(func() { spin = saved })
+	return &counts
+}
+
+func TestExponential(t *testing.T) {
+	strategies := map[string]struct {
+		backoff  Exponential
+		min, max int
+	}{
+		"Default":  {Exponential{}, 4, 1024},
+		"Custom":   {Exponential{Min: 1, Max: 8}, 1, 8},
+		"Inverted": {Exponential{Min: 64, Max: 8}, 8, 8},
+	}
+
+	for name, s := range strategies {
+		t.Run(name, func(t *testing.T) {
+			counts := spins(t)
+			previous, longest := 0, 0
+			for attempt := 0; attempt < 100; attempt++ {
+				limit := s.backoff.limit(attempt)
+				assert.GreaterOrEqual(t, limit, previous, "the limit must not shrink with attempt")
+				assert.LessOrEqual(t, limit, s.max)
+				if attempt < 31 && s.min<<attempt <= s.max {
+					assert.Equal(t, s.min<<attempt, limit)
+				}
+				previous = limit
+
+				for i := 0; i < 20; i++ {
+					s.backoff.Wait(attempt)
+					n := (*counts)[len(*counts)-1]
+					assert.GreaterOrEqual(t, n, 1)
+					assert.LessOrEqual(t, n, limit)
+					longest = max(longest, n)
+				}
+			}
+			assert.Equal(t, s.max, previous, "the limit is capped at Max")
+			if s.max > s.min {
+				assert.Greater(t, longest, s.min, "later attempts must spin longer than the first")
+			}
+		})
+	}
+}
+
+func TestProportional(t *testing.T) {
+	counts := spins(t)
+	p := Proportional{Factor: 3, Max: 10}
+	for attempt := 0; attempt < 6; attempt++ {
+		p.Wait(attempt)
+	}
+	assert.Equal(t, []int{3, 6, 9, 10, 10, 10}, *counts)
+
+	*counts = nil
+	Proportional{}.Wait(0)
+	Proportional{}.Wait(1000)
+	assert.Equal(t, []int{4, 1024}, *counts)
+}
+
+func TestImmediate(t *testing.T) {
+	for name, strategy := range map[string]Backoff{"None": None{}, "Yield": Yield{}} {
+		t.Run(name, func(t *testing.T) {
+			counts := spins(t)
+			for attempt := 0; attempt < 100; attempt++ {
+				strategy.Wait(attempt)
+			}
+			assert.Empty(t, *counts, "must return without spinning")
+		})
+	}
+}
@@ -0,0 +1,223 @@
+// Package client talks to a treiberd server. A Client owns one connection
+// and sends one request at a time; handles of its named containers share
+// it:
+//
+//	c, err := client.Dial("unix", "/run/treiberd.sock")
+//	jobs := c.Queue("jobs")
+//	err = jobs.Push(42)
+//	value, ok, err := jobs.PopWait(time.Second)
+//
+// A PopWait holds the connection until it returns, so goroutines that wait
+// concurrently should use a Client each.
+package client
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/peletor/treiber/internal/wire"
+)
+
+// Error is an error reported by the server, such as a name that is bound to
+// another kind of container.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "treiberd: " + e.Message
+}
+
+type Client struct {
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	// err ends the client after a failed exchange, as the stream may have
+	// lost its framing.
+	err error
+}
+
+// Dial connects to the server at address, see net.Dial.
+func Dial(network, address string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient uses conn, which the Client closes with Close.
+func NewClient(conn net.Conn) *Client {
+	return &Client{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) Queue(name string) *Queue {
+	return &Queue{handle{c: c, kind: wire.KindQueue, name: name}}
+}
+
+func (c *Client) Stack(name string) *Stack {
+	return &Stack{handle{c: c, kind: wire.KindStack, name: name}}
+}
+
+func (c *Client) Deque(name string) *Deque {
+	return &Deque{handle{c: c, kind: wire.KindDeque, name: name}}
+}
+
+func (c *Client) do(req wire.Request) (wire.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return wire.Response{}, c.err
+	}
+
+	resp, err := c.exchange(req)
+	if err != nil {
+		c.err = err
+		c.conn.Close()
+		return wire.Response{}, err
+	}
+	if resp.Status == wire.StatusError {
+		return wire.Response{}, &Error{Message: resp.Message}
+	}
+	return resp, nil
+}
+
+func (c *Client) exchange(req wire.Request) (wire.Response, error) {
+	if err := wire.WriteRequest(c.w, req); err != nil {
+		return wire.Response{}, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return wire.Response{}, err
+	}
+	return wire.ReadResponse(c.r)
+}
+
+// handle is a named container of one kind.
+type handle struct {
+	c    *Client
+	kind wire.Kind
+	name string
+}
+
+func (h handle) push(end wire.End, value int) error {
+	_, err := h.c.do(wire.Request{Op: wire.OpPush, Kind: h.kind, End: end, Name: h.name, Arg: int64(value)})
+	return err
+}
+
+// pop runs a pop, wait or peek; ok is false if the container was empty.
+func (h handle) pop(op wire.Op, end wire.End, arg int64) (value int, ok bool, err error) {
+	resp, err := h.c.do(wire.Request{Op: op, Kind: h.kind, End: end, Name: h.name, Arg: arg})
+	if err != nil || resp.Status == wire.StatusEmpty {
+		return 0, false, err
+	}
+	return int(resp.Value), true, nil
+}
+
+func (h handle) len() (int, error) {
+	resp, err := h.c.do(wire.Request{Op: wire.OpLen, Kind: h.kind, Name: h.name})
+	return int(resp.Value), err
+}
+
+// Queue is a queue.Queue on the server.
+type Queue struct {
+	h handle
+}
+
+func (q *Queue) Push(value int) error {
+	return q.h.push(wire.Back, value)
+}
+
+func (q *Queue) Pop() (value int, ok bool, err error) {
+	return q.h.pop(wire.OpPop, wire.Back, 0)
+}
+
+// PopWait pops, waiting up to timeout for a value if the queue is empty.
+func (q *Queue) PopWait(timeout time.Duration) (value int, ok bool, err error) {
+	return q.h.pop(wire.OpPopWait, wire.Back, int64(timeout))
+}
+
+func (q *Queue) Peek() (value int, ok bool, err error) {
+	return q.h.pop(wire.OpPeek, wire.Back, 0)
+}
+
+func (q *Queue) Len() (int, error) {
+	return q.h.len()
+}
+
+// Stack is a stack.Stack on the server.
+type Stack struct {
+	h handle
+}
+
+func (s *Stack) Push(value int) error {
+	return s.h.push(wire.Back, value)
+}
+
+func (s *Stack) Pop() (value int, ok bool, err error) {
+	return s.h.pop(wire.OpPop, wire.Back, 0)
+}
+
+// PopWait pops, waiting up to timeout for a value if the stack is empty.
+func (s *Stack) PopWait(timeout time.Duration) (value int, ok bool, err error) {
+	return s.h.pop(wire.OpPopWait, wire.Back, int64(timeout))
+}
+
+// Peek returns the top of the stack, as Stack.Top.
+func (s *Stack) Peek() (value int, ok bool, err error) {
+	return s.h.pop(wire.OpPeek, wire.Back, 0)
+}
+
+func (s *Stack) Len() (int, error) {
+	return s.h.len()
+}
+
+// Deque is a deque.Deque on the server.
+type Deque struct {
+	h handle
+}
+
+func (d *Deque) PushBack(value int) error {
+	return d.h.push(wire.Back, value)
+}
+
+func (d *Deque) PushFront(value int) error {
+	return d.h.push(wire.Front, value)
+}
+
+func (d *Deque) PopBack() (value int, ok bool, err error) {
+	return d.h.pop(wire.OpPop, wire.Back, 0)
+}
+
+func (d *Deque) PopFront() (value int, ok bool, err error) {
+	return d.h.pop(wire.OpPop, wire.Front, 0)
+}
+
+// PopWaitBack pops the back, waiting up to timeout for a value if the
+// deque is empty.
+func (d *Deque) PopWaitBack(timeout time.Duration) (value int, ok bool, err error) {
+	return d.h.pop(wire.OpPopWait, wire.Back, int64(timeout))
+}
+
+// PopWaitFront is PopWaitBack for the front.
+func (d *Deque) PopWaitFront(timeout time.Duration) (value int, ok bool, err error) {
+	return d.h.pop(wire.OpPopWait, wire.Front, int64(timeout))
+}
+
+func (d *Deque) PeekBack() (value int, ok bool, err error) {
+	return d.h.pop(wire.OpPeek, wire.Back, 0)
+}
+
+func (d *Deque) PeekFront() (value int, ok bool, err error) {
+	return d.h.pop(wire.OpPeek, wire.Front, 0)
+}
+
+func (d *Deque) Len() (int, error) {
+	return d.h.len()
+}
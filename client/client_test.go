@@ -0,0 +1,179 @@
+package client
+
+import (
+	"github.com/peletor/treiber/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serve starts a server on a loopback TCP port and a Unix socket.
+func serve(t *testing.T) map[string]string {
+	t.Helper()
+	srv := server.NewServer()
+	t.Cleanup(func() { srv.Close() })
+
+	addrs := map[string]string{}
+	for network, address := range map[string]string{
+		"tcp":  "127.0.0.1:0",
+		"unix": filepath.Join(t.TempDir(), "treiberd.sock"),
+	} {
+		l, err := net.Listen(network, address)
+		require.NoError(t, err)
+		go srv.Serve(l)
+		addrs[network] = l.Addr().String()
+	}
+	return addrs
+}
+
+func TestClient(t *testing.T) {
+	for network, address := range serve(t) {
+		t.Run(network, func(t *testing.T) {
+			c, err := Dial(network, address)
+			require.NoError(t, err)
+			defer c.Close()
+
+			t.Run("Queue", func(t *testing.T) {
+				que := c.Queue("jobs")
+				require.NoError(t, que.Push(1))
+				require.NoError(t, que.Push(2))
+				n, err := que.Len()
+				assert.NoError(t, err)
+				assert.Equal(t, 2, n)
+
+				value, ok, err := que.Peek()
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, 1, value)
+				for _, want := range []int{1, 2} {
+					value, ok, err := que.Pop()
+					assert.NoError(t, err)
+					assert.True(t, ok)
+					assert.Equal(t, want, value)
+				}
+				_, ok, err = que.Pop()
+				assert.NoError(t, err)
+				assert.False(t, ok)
+			})
+
+			t.Run("Stack", func(t *testing.T) {
+				stk := c.Stack("undo")
+				require.NoError(t, stk.Push(1))
+				require.NoError(t, stk.Push(2))
+				value, ok, err := stk.Peek()
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, 2, value)
+				value, ok, err = stk.Pop()
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, 2, value)
+				value, ok, err = stk.PopWait(time.Second)
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, 1, value)
+			})
+
+			t.Run("Deque", func(t *testing.T) {
+				deq := c.Deque("work")
+				require.NoError(t, deq.PushBack(2))
+				require.NoError(t, deq.PushFront(1))
+				require.NoError(t, deq.PushBack(3))
+				front, _, err := deq.PeekFront()
+				assert.NoError(t, err)
+				back, _, err := deq.PeekBack()
+				assert.NoError(t, err)
+				assert.Equal(t, []int{1, 3}, []int{front, back})
+
+				value, ok, err := deq.PopFront()
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, 1, value)
+				value, ok, err = deq.PopBack()
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, 3, value)
+				value, ok, err = deq.PopWaitFront(time.Second)
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, 2, value)
+				_, ok, err = deq.PopWaitBack(10 * time.Millisecond)
+				assert.NoError(t, err)
+				assert.False(t, ok)
+			})
+
+			t.Run("Wrong kind", func(t *testing.T) {
+				err := c.Stack("jobs").Push(1)
+				var serverErr *Error
+				require.ErrorAs(t, err, &serverErr)
+				assert.Contains(t, serverErr.Message, "is a queue")
+
+				// the connection survives errors of the server
+				_, err = c.Queue("jobs").Len()
+				assert.NoError(t, err)
+			})
+		})
+	}
+}
+
+// the Unix socket and TCP clients share the containers of the server
+func TestClientsShareContainers(t *testing.T) {
+	const (
+		producers = 4
+		count     = 200
+	)
+	addrs := serve(t)
+
+	consumer, err := Dial("unix", addrs["unix"])
+	require.NoError(t, err)
+	defer consumer.Close()
+
+	wg := sync.WaitGroup{}
+	wg.Add(producers)
+	for p := 0; p < producers; p++ {
+		go func(p int) {
+			defer wg.Done()
+			c, err := Dial("tcp", addrs["tcp"])
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer c.Close()
+			for i := 0; i < count; i++ {
+				assert.NoError(t, c.Queue("shared").Push(p*count+i))
+			}
+		}(p)
+	}
+
+	seen := map[int]bool{}
+	que := consumer.Queue("shared")
+	for len(seen) < producers*count {
+		value, ok, err := que.PopWait(5 * time.Second)
+		require.NoError(t, err)
+		require.True(t, ok, "timed out after %d values", len(seen))
+		assert.False(t, seen[value], "value %d popped twice", value)
+		seen[value] = true
+	}
+	wg.Wait()
+}
+
+func TestClientBroken(t *testing.T) {
+	srv := server.NewServer()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l)
+
+	c, err := Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	require.NoError(t, c.Queue("q").Push(1))
+
+	require.NoError(t, srv.Close())
+	_, _, err = c.Queue("q").Pop()
+	assert.Error(t, err)
+	_, err = c.Queue("q").Len()
+	assert.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/peletor/treiber/deque"
+	"github.com/peletor/treiber/queue"
+	"github.com/peletor/treiber/stack"
+)
+
+// container is what a benchmark run pushes to and pops from.
+// pop must not block: an empty container returns ok == false.
+type container struct {
+	push func(value int)
+	pop  func() (value int, ok bool)
+}
+
+// containers builds a fresh instance of every implementation.
+// capacity only matters for the buffered channel.
+var containers = map[string]func(capacity int) container{
+	"stack": func(int) container {
+		st := stack.NewStack()
+		return container{push: st.Push, pop: st.Pop}
+	},
+	"queue": func(int) container {
+		que := queue.NewQueue()
+		return container{push: que.Push, pop: que.Pop}
+	},
+	"sharded": func(int) container {
+		que := queue.NewShardedQueue(runtime.GOMAXPROCS(0))
+		return container{push: que.Push, pop: que.Pop}
+	},
+	"deque": func(int) container {
+		deq := deque.NewDeque()
+		return container{push: deq.PushBack, pop: deq.PopFront}
+	},
+	"mutex": func(int) container {
+		sl := &mutexSlice{}
+		return container{push: sl.push, pop: sl.pop}
+	},
+	"channel": func(capacity int) container {
+		ch := make(chan int, capacity)
+		return container{
+			push: func(value int) { ch <- value },
+			pop: func() (int, bool) {
+				select {
+				case value := <-ch:
+					return value, true
+				default:
+					return 0, false
+				}
+			},
+		}
+	},
+}
+
+func containerNames() []string {
+	names := make([]string, 0, len(containers))
+	for name := range containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mutexSlice is the FIFO a lock-free queue is usually compared against.
+type mutexSlice struct {
+	mu    sync.Mutex
+	items []int
+	head  int
+}
+
+func (s *mutexSlice) push(value int) {
+	s.mu.Lock()
+	s.items = append(s.items, value)
+	s.mu.Unlock()
+}
+
+func (s *mutexSlice) pop() (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.head == len(s.items) {
+		return 0, false
+	}
+	value := s.items[s.head]
+	s.head++
+
+	// drop the consumed prefix once it is the larger half
+	if s.head > len(s.items)/2 {
+		s.items = append(s.items[:0], s.items[s.head:]...)
+		s.head = 0
+	}
+	return value, true
+}
@@ -0,0 +1,125 @@
+// Command treiberbench compares the containers of this module with a
+// mutex-guarded slice and a buffered channel under producer/consumer mixes.
+//
+//	treiberbench -impl queue,mutex -mix 1:1,4:4,8:1 -items 1000000 -format json
+//
+// Every mix pushes -items items and pops all of them. The report shows
+// operations per second, p50/p99/p999 latency of single operations and
+// allocations per operation, as a table or as JSON for regression tracking.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type report struct {
+	Label      string    `json:"label,omitempty"`
+	GoVersion  string    `json:"go_version"`
+	GOMAXPROCS int       `json:"gomaxprocs"`
+	Time       time.Time `json:"time"`
+	Results    []result  `json:"results"`
+}
+
+func main() {
+	if err := mainErr(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "treiberbench:", err)
+		os.Exit(2)
+	}
+}
+
+func mainErr(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("treiberbench", flag.ContinueOnError)
+	impls := fs.String("impl", strings.Join(containerNames(), ","), "comma-separated implementations to run")
+	mixes := fs.String("mix", "1:1,4:4,8:8", "comma-separated producers:consumers mixes")
+	items := fs.Int("items", 1_000_000, "items pushed and popped per run")
+	capacity := fs.Int("capacity", 1024, "buffer size of the channel implementation")
+	format := fs.String("format", "table", "output format: table or json")
+	label := fs.String("label", "", "label stored in the JSON report, e.g. a version")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	names, err := parseImpls(*impls)
+	if err != nil {
+		return err
+	}
+	workloads, err := parseMixes(*mixes)
+	if err != nil {
+		return err
+	}
+	if *items <= 0 || *capacity <= 0 {
+		return errors.New("-items and -capacity must be positive")
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	rep := report{
+		Label:      *label,
+		GoVersion:  runtime.Version(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Time:       time.Now().UTC(),
+	}
+	for _, w := range workloads {
+		w.items = *items
+		w.capacity = *capacity
+		for _, name := range names {
+			rep.Results = append(rep.Results, run(name, w))
+		}
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	}
+	return writeTable(out, rep.Results)
+}
+
+func parseImpls(list string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := containers[name]; !ok {
+			return nil, fmt.Errorf("unknown implementation %q, want one of %s", name, strings.Join(containerNames(), ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func parseMixes(list string) ([]workload, error) {
+	var workloads []workload
+	for _, mix := range strings.Split(list, ",") {
+		p, c, ok := strings.Cut(strings.TrimSpace(mix), ":")
+		producers, errP := strconv.Atoi(p)
+		consumers, errC := strconv.Atoi(c)
+		if !ok || errP != nil || errC != nil || producers <= 0 || consumers <= 0 {
+			return nil, fmt.Errorf("bad mix %q, want producers:consumers", mix)
+		}
+		workloads = append(workloads, workload{producers: producers, consumers: consumers})
+	}
+	return workloads, nil
+}
+
+func writeTable(out io.Writer, results []result) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "impl\tmix\tops\tops/sec\tp50\tp99\tp999\tallocs/op\tB/op\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d:%d\t%d\t%.0f\t%v\t%v\t%v\t%.2f\t%.1f\t\n",
+			r.Impl, r.Producers, r.Consumers, r.Ops, r.OpsPerSec,
+			time.Duration(r.P50), time.Duration(r.P99), time.Duration(r.P999),
+			r.AllocsPerOp, r.BytesPerOp)
+	}
+	return tw.Flush()
+}
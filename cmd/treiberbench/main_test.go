@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	const items = 1000
+
+	for _, name := range containerNames() {
+		t.Run(name, func(t *testing.T) {
+			r := run(name, workload{producers: 3, consumers: 2, items: items, capacity: 16})
+			assert.Equal(t, 2*items, r.Ops)
+			assert.Positive(t, r.OpsPerSec)
+			assert.LessOrEqual(t, r.P50, r.P99)
+			assert.LessOrEqual(t, r.P99, r.P999)
+		})
+	}
+}
+
+func TestMutexSlice(t *testing.T) {
+	const count = 100
+
+	sl := &mutexSlice{}
+	for i := 0; i < count; i++ {
+		sl.push(i)
+	}
+	for i := 0; i < count; i++ {
+		value, ok := sl.pop()
+		assert.True(t, ok)
+		assert.Equal(t, i, value)
+	}
+	_, ok := sl.pop()
+	assert.False(t, ok)
+}
+
+func TestMainErr(t *testing.T) {
+	t.Run("Table", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		assert.NoError(t, mainErr([]string{"-impl", "queue,channel", "-mix", "2:1", "-items", "100"}, out))
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		assert.Len(t, lines, 3)
+		assert.Contains(t, lines[0], "ops/sec")
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		assert.NoError(t, mainErr([]string{"-impl", "stack", "-mix", "1:1,2:2", "-items", "100", "-format", "json", "-label", "v1"}, out))
+
+		var rep report
+		assert.NoError(t, json.Unmarshal(out.Bytes(), &rep))
+		assert.Equal(t, "v1", rep.Label)
+		assert.Len(t, rep.Results, 2)
+	})
+
+	t.Run("Bad flags", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		assert.Error(t, mainErr([]string{"-impl", "list"}, out))
+		assert.Error(t, mainErr([]string{"-mix", "1"}, out))
+		assert.Error(t, mainErr([]string{"-mix", "0:1"}, out))
+		assert.Error(t, mainErr([]string{"-format", "xml"}, out))
+	})
+}
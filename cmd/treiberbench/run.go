@@ -0,0 +1,124 @@
+package main
+
+import (
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// workload is one producer/consumer mix.
+type workload struct {
+	producers int
+	consumers int
+	items     int // pushed in total, and popped in total
+	capacity  int
+}
+
+type result struct {
+	Impl        string  `json:"impl"`
+	Producers   int     `json:"producers"`
+	Consumers   int     `json:"consumers"`
+	Ops         int     `json:"ops"`
+	Seconds     float64 `json:"seconds"`
+	OpsPerSec   float64 `json:"ops_per_sec"`
+	P50         int64   `json:"p50_ns"`
+	P99         int64   `json:"p99_ns"`
+	P999        int64   `json:"p999_ns"`
+	AllocsPerOp float64 `json:"allocs_per_op"`
+	BytesPerOp  float64 `json:"bytes_per_op"`
+}
+
+// share splits total into parts that differ by at most one.
+func share(total, parts, i int) int {
+	n := total / parts
+	if i < total%parts {
+		n++
+	}
+	return n
+}
+
+// run pushes w.items items and pops them all again. Latency is recorded for
+// every push and every successful pop; failed pops on an empty container
+// are retried and count towards the latency of the pop that succeeds.
+func run(impl string, w workload) result {
+	c := containers[impl](w.capacity)
+
+	latencies := make([][]int64, w.producers+w.consumers)
+	wg := sync.WaitGroup{}
+	start := make(chan struct{})
+
+	for p := 0; p < w.producers; p++ {
+		n := share(w.items, w.producers, p)
+		latencies[p] = make([]int64, 0, n)
+		wg.Add(1)
+		go func(lat *[]int64, n int) {
+			defer wg.Done()
+			<-start
+			for i := 0; i < n; i++ {
+				begin := time.Now()
+				c.push(i)
+				*lat = append(*lat, int64(time.Since(begin)))
+			}
+		}(&latencies[p], n)
+	}
+
+	for k := 0; k < w.consumers; k++ {
+		n := share(w.items, w.consumers, k)
+		latencies[w.producers+k] = make([]int64, 0, n)
+		wg.Add(1)
+		go func(lat *[]int64, n int) {
+			defer wg.Done()
+			<-start
+			for n > 0 {
+				begin := time.Now()
+				for _, ok := c.pop(); !ok; _, ok = c.pop() {
+					runtime.Gosched()
+				}
+				*lat = append(*lat, int64(time.Since(begin)))
+				n--
+			}
+		}(&latencies[w.producers+k], n)
+	}
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	began := time.Now()
+	close(start)
+	wg.Wait()
+	elapsed := time.Since(began)
+
+	runtime.ReadMemStats(&after)
+
+	all := slices.Concat(latencies...)
+	slices.Sort(all)
+
+	ops := len(all)
+	return result{
+		Impl:        impl,
+		Producers:   w.producers,
+		Consumers:   w.consumers,
+		Ops:         ops,
+		Seconds:     elapsed.Seconds(),
+		OpsPerSec:   float64(ops) / elapsed.Seconds(),
+		P50:         percentile(all, 0.50),
+		P99:         percentile(all, 0.99),
+		P999:        percentile(all, 0.999),
+		AllocsPerOp: float64(after.Mallocs-before.Mallocs) / float64(ops),
+		BytesPerOp:  float64(after.TotalAlloc-before.TotalAlloc) / float64(ops),
+	}
+}
+
+// percentile expects sorted latencies.
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
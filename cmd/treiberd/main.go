@@ -0,0 +1,75 @@
+// Command treiberd hosts named queues, stacks and deques for other
+// processes on the host, over TCP, a Unix socket or both:
+//
+//	treiberd -tcp 127.0.0.1:7070 -unix /run/treiberd.sock
+//
+// Package client talks to it. The containers live in memory and are lost
+// when treiberd exits, on SIGINT or SIGTERM.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/peletor/treiber/server"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "treiberd:", err)
+		os.Exit(2)
+	}
+}
+
+// run serves until ctx is done or a listener fails.
+func run(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("treiberd", flag.ContinueOnError)
+	tcp := fs.String("tcp", "127.0.0.1:7070", "TCP address to listen on, empty for none")
+	unix := fs.String("unix", "", "Unix socket to listen on, empty for none")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *tcp == "" && *unix == "" {
+		return errors.New("no -tcp or -unix address")
+	}
+
+	var listeners []net.Listener
+	for _, addr := range []struct{ network, address string }{{"tcp", *tcp}, {"unix", *unix}} {
+		if addr.address == "" {
+			continue
+		}
+		l, err := net.Listen(addr.network, addr.address)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		fmt.Fprintf(out, "listening on %s %s\n", addr.network, l.Addr())
+		listeners = append(listeners, l)
+	}
+
+	srv := server.NewServer()
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errs <- srv.Serve(l)
+		}(l)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+	return errors.Join(err, srv.Close())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"github.com/peletor/treiber/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	out, w := io.Pipe()
+	socket := filepath.Join(t.TempDir(), "treiberd.sock")
+	done := make(chan error)
+	go func() {
+		done <- run(ctx, []string{"-tcp", "127.0.0.1:0", "-unix", socket}, w)
+	}()
+
+	// the listening lines name the addresses
+	lines := bufio.NewScanner(out)
+	var clients []*client.Client
+	for i := 0; i < 2; i++ {
+		require.True(t, lines.Scan())
+		fields := strings.Fields(lines.Text())
+		require.Len(t, fields, 4)
+		c, err := client.Dial(fields[2], fields[3])
+		require.NoError(t, err)
+		defer c.Close()
+		clients = append(clients, c)
+	}
+
+	require.NoError(t, clients[0].Queue("q").Push(5))
+	value, ok, err := clients[1].Queue("q").Pop()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 5, value)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestRunNoAddress(t *testing.T) {
+	assert.Error(t, run(context.Background(), []string{"-tcp", ""}, io.Discard))
+}
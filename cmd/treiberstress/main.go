@@ -0,0 +1,118 @@
+// Command treiberstress runs randomized mixed workloads against the
+// containers for a chosen duration and checks that every pushed value is
+// popped exactly once. For the queue it also checks that values of one
+// producer come out in the order they were pushed.
+//
+//	treiberstress -impl deque -duration 1m -procs 1,2,4,8 -faults 0.01
+//
+// Every worker draws its operations from a rand source seeded with -seed and
+// its index, so a printed seed reproduces the operation mix of a failing run;
+// the interleaving still depends on the scheduler. On the first lost,
+// duplicated or reordered value the run stops and the recent operations of
+// the worker that noticed it are printed. A worker that completes no
+// operation for -stall is reported as a livelock and ends the sweep.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errFailed = errors.New("stress run failed")
+
+func main() {
+	err := mainErr(os.Args[1:], os.Stdout)
+	if errors.Is(err, errFailed) {
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "treiberstress:", err)
+		os.Exit(2)
+	}
+}
+
+func mainErr(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("treiberstress", flag.ContinueOnError)
+	impls := fs.String("impl", strings.Join(targetNames(), ","), "comma-separated containers to stress")
+	duration := fs.Duration("duration", 10*time.Second, "duration of every run")
+	workers := fs.Int("workers", 8, "goroutines per run, each pushing and popping")
+	seed := fs.Uint64("seed", 0, "seed of the operation mix, 0 picks one from the clock")
+	procs := fs.String("procs", strconv.Itoa(runtime.GOMAXPROCS(0)), "comma-separated GOMAXPROCS values to sweep")
+	faults := fs.Float64("faults", 0, "probability of an injected yield, sleep or busy loop before an operation")
+	history := fs.Int("history", 32, "operations per worker shown for a failure")
+	stall := fs.Duration("stall", 10*time.Second, "report a livelock when a worker completes no operation for this long")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	names := strings.Split(*impls, ",")
+	for _, name := range names {
+		if _, ok := targets[name]; !ok {
+			return fmt.Errorf("unknown container %q, want one of %s", name, strings.Join(targetNames(), ", "))
+		}
+	}
+	sweep, err := parseProcs(*procs)
+	if err != nil {
+		return err
+	}
+	if *workers <= 0 || *workers >= 1<<(63-seqBits) {
+		return fmt.Errorf("-workers must be in [1, %d)", 1<<(63-seqBits))
+	}
+	if *seed == 0 {
+		*seed = uint64(time.Now().UnixNano())
+	}
+
+	cfg := config{workers: *workers, duration: *duration, seed: *seed, faults: *faults, history: *history, stall: *stall}
+	fmt.Fprintf(out, "seed %d\n", cfg.seed)
+
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	failed := false
+	for _, p := range sweep {
+		runtime.GOMAXPROCS(p)
+		for _, name := range names {
+			res := run(targets[name], cfg)
+			status := "ok"
+			if res.failure != nil {
+				status = "FAIL"
+				failed = true
+			}
+			if res.stalled {
+				status = "STALLED"
+			}
+			fmt.Fprintf(out, "%-6s procs=%-3d pushes=%-10d pops=%-10d %s\n", name, p, res.pushes, res.pops, status)
+			if res.failure != nil {
+				fmt.Fprint(out, res.failure)
+				fmt.Fprintf(out, "reproduce with: -impl %s -procs %d -workers %d -seed %d -faults %g\n",
+					name, p, cfg.workers, cfg.seed, cfg.faults)
+			}
+			if res.stalled {
+				// the stuck goroutines keep spinning, later runs would not be meaningful
+				return errFailed
+			}
+		}
+	}
+
+	if failed {
+		return errFailed
+	}
+	return nil
+}
+
+func parseProcs(list string) ([]int, error) {
+	var procs []int
+	for _, field := range strings.Split(list, ",") {
+		p, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || p <= 0 {
+			return nil, fmt.Errorf("bad GOMAXPROCS value %q", field)
+		}
+		procs = append(procs, p)
+	}
+	return procs, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testDuration = 50 * time.Millisecond
+
+func TestRun(t *testing.T) {
+	for _, name := range targetNames() {
+		t.Run(name, func(t *testing.T) {
+			res := run(targets[name], config{workers: 4, duration: testDuration, seed: 1, faults: 0.01, history: 8})
+			assert.Nil(t, res.failure)
+			assert.Positive(t, res.pushes)
+			assert.Equal(t, res.pushes, res.pops)
+		})
+	}
+}
+
+// faulty is a mutex-guarded FIFO that misbehaves on purpose.
+type faulty struct {
+	mu    sync.Mutex
+	items []int
+	pops  int
+	mode  string
+}
+
+func (f *faulty) push(value int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items = append(f.items, value)
+}
+
+func (f *faulty) pop() (int, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.items) == 0 {
+		return 0, false
+	}
+	f.pops++
+
+	switch {
+	case f.mode == "duplicate" && f.pops == 10:
+		return f.items[0], true
+	case f.mode == "lose" && f.pops == 10:
+		f.items = f.items[1:]
+	case f.mode == "reorder" && f.pops == 10 && len(f.items) > 1:
+		value := f.items[len(f.items)-1]
+		f.items = f.items[:len(f.items)-1]
+		return value, true
+	}
+	value := f.items[0]
+	f.items = f.items[1:]
+	return value, true
+}
+
+func TestRunDetectsFaults(t *testing.T) {
+	for mode, message := range map[string]string{
+		"duplicate": "a second time",
+		"lose":      "never popped",
+		"reorder":   "after",
+	} {
+		t.Run(mode, func(t *testing.T) {
+			newTarget := func() target {
+				f := &faulty{mode: mode}
+				return target{
+					pushes: []operation{{name: "Push", push: f.push}},
+					pops:   []operation{{name: "Pop", pop: f.pop}},
+					fifo:   true,
+				}
+			}
+			res := run(newTarget, config{workers: 1, duration: testDuration, seed: 1, history: 4})
+			if assert.NotNil(t, res.failure) {
+				assert.Contains(t, res.failure.message, message)
+				assert.Contains(t, res.failure.String(), "history of worker")
+			}
+		})
+	}
+}
+
+func TestRunDetectsStall(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	newTarget := func() target {
+		f := &faulty{}
+		return target{
+			pushes: []operation{{name: "Push", push: f.push}},
+			pops: []operation{{name: "Pop", pop: func() (int, bool) {
+				if value, ok := f.pop(); ok && value == encode(0, 20) {
+					<-block
+				}
+				return f.pop()
+			}}},
+		}
+	}
+	res := run(newTarget, config{workers: 1, duration: time.Minute, seed: 1, history: 4, stall: testDuration})
+	assert.True(t, res.stalled)
+	if assert.NotNil(t, res.failure) {
+		assert.Contains(t, res.failure.String(), "still running")
+	}
+}
+
+func TestMainErr(t *testing.T) {
+	t.Run("Sweep", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		assert.NoError(t, mainErr([]string{"-impl", "queue,stack", "-duration", "20ms", "-procs", "1,2", "-seed", "7"}, out))
+		assert.Contains(t, out.String(), "seed 7\n")
+		assert.Equal(t, 4, bytes.Count(out.Bytes(), []byte(" ok\n")))
+	})
+
+	t.Run("Bad flags", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		assert.Error(t, mainErr([]string{"-impl", "heap"}, out))
+		assert.Error(t, mainErr([]string{"-procs", "0"}, out))
+		assert.Error(t, mainErr([]string{"-workers", "0"}, out))
+	})
+}
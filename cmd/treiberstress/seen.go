@@ -0,0 +1,43 @@
+package main
+
+import "sync/atomic"
+
+const (
+	chunkBits  = 1 << 16
+	chunkWords = chunkBits / 64
+	maxChunks  = 1 << 12
+)
+
+type chunk [chunkWords]uint64
+
+// seen is a bitmap of the sequence numbers popped for one producer.
+// Chunks are allocated on first use, so idle producers cost little.
+type seen struct {
+	chunks [maxChunks]atomic.Pointer[chunk]
+}
+
+// mark sets the bit of seq and reports whether it was already set.
+func (s *seen) mark(seq int) (duplicate bool) {
+	c := s.chunk(seq / chunkBits)
+	bit := seq % chunkBits
+	mask := uint64(1) << (bit % 64)
+	old := atomic.OrUint64(&c[bit/64], mask)
+	return old&mask != 0
+}
+
+func (s *seen) has(seq int) bool {
+	c := s.chunks[seq/chunkBits].Load()
+	if c == nil {
+		return false
+	}
+	bit := seq % chunkBits
+	return atomic.LoadUint64(&c[bit/64])&(uint64(1)<<(bit%64)) != 0
+}
+
+func (s *seen) chunk(i int) *chunk {
+	if c := s.chunks[i].Load(); c != nil {
+		return c
+	}
+	s.chunks[i].CompareAndSwap(nil, &chunk{})
+	return s.chunks[i].Load()
+}
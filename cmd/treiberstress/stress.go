@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Values carry their producer in the high bits and a per-producer sequence
+// number in the low bits, so every pushed value is unique.
+const seqBits = 40
+
+// maxSeq is the number of values a worker may push before its sequence
+// numbers no longer fit into the seen bitmap.
+const maxSeq = maxChunks * chunkBits
+
+func encode(worker, seq int) int { return worker<<seqBits | seq }
+
+func decode(value int) (worker, seq int) { return value >> seqBits, value & (1<<seqBits - 1) }
+
+type config struct {
+	workers  int
+	duration time.Duration
+	seed     uint64
+	faults   float64       // probability of an injected yield or sleep before an operation
+	history  int           // operations kept per worker for the failure report
+	stall    time.Duration // report a livelock when no operation completes for this long
+}
+
+type event struct {
+	at      time.Duration
+	op      string
+	value   int
+	ok      bool
+	running bool // a pop that has not returned yet
+}
+
+func (e event) String() string {
+	if e.op == "" {
+		return ""
+	}
+	worker, seq := decode(e.value)
+	result := fmt.Sprintf("w%d#%d", worker, seq)
+	switch {
+	case e.running:
+		result = "still running"
+	case !e.ok:
+		result = "empty"
+	}
+	return fmt.Sprintf("%12v %-9s %s", e.at, e.op, result)
+}
+
+// worker is one goroutine of a run. Its rand source depends only on the
+// seed and its index, so a seed reproduces every worker's operation mix.
+type worker struct {
+	id      int
+	rng     *rand.Rand
+	pushed  int
+	pops    int
+	lastSeq map[int]int // per producer, for the fifo check
+
+	// done counts completed operations for the stall watchdog, which also
+	// reads the ring of a stuck worker, hence the mutex.
+	done atomic.Int64
+	mu   sync.Mutex
+	ring []event
+	next int
+}
+
+// record is called before an operation starts, so the history of a stuck
+// worker ends with the operation it is stuck in.
+func (w *worker) record(e event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.ring) == 0 {
+		return
+	}
+	w.ring[w.next%len(w.ring)] = e
+	w.next++
+}
+
+func (w *worker) amend(ok bool, value int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.ring) == 0 {
+		return
+	}
+	last := &w.ring[(w.next-1)%len(w.ring)]
+	last.ok, last.value, last.running = ok, value, false
+}
+
+// history returns the recorded events oldest first.
+func (w *worker) history() []event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := min(w.next, len(w.ring))
+	events := make([]event, 0, n)
+	for i := w.next - n; i < w.next; i++ {
+		events = append(events, w.ring[i%len(w.ring)])
+	}
+	return events
+}
+
+type failure struct {
+	message string
+	worker  int
+	history []event
+}
+
+func (f *failure) String() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "%s\nhistory of worker %d, oldest first:\n", f.message, f.worker)
+	for _, e := range f.history {
+		fmt.Fprintf(sb, "  %v\n", e)
+	}
+	return sb.String()
+}
+
+type outcome struct {
+	pushes  int
+	pops    int
+	failure *failure
+	// stalled runs leave their stuck goroutines behind, so no further run
+	// should be started in the same process.
+	stalled bool
+}
+
+// run drives a fresh target with cfg.workers goroutines for cfg.duration,
+// then drains it and checks that every pushed value was popped exactly once.
+func run(newTarget func() target, cfg config) outcome {
+	tgt := newTarget()
+	popped := make([]seen, cfg.workers)
+	workers := make([]*worker, cfg.workers)
+	for i := range workers {
+		workers[i] = &worker{
+			id:      i,
+			rng:     rand.New(rand.NewPCG(cfg.seed, uint64(i))),
+			ring:    make([]event, cfg.history),
+			lastSeq: map[int]int{},
+		}
+	}
+
+	var (
+		stop      atomic.Bool
+		firstFail *failure
+		failOnce  sync.Once
+	)
+	start := time.Now()
+	fail := func(w *worker, format string, args ...any) {
+		failOnce.Do(func() {
+			firstFail = &failure{message: fmt.Sprintf(format, args...), worker: w.id, history: w.history()}
+			stop.Store(true)
+		})
+	}
+
+	consume := func(w *worker, op string, value int) {
+		producer, seq := decode(value)
+		if producer < 0 || producer >= cfg.workers || seq >= maxSeq {
+			fail(w, "%s returned %d, which was never pushed", op, value)
+			return
+		}
+		if popped[producer].mark(seq) {
+			fail(w, "%s returned w%d#%d a second time", op, producer, seq)
+			return
+		}
+		if tgt.fifo {
+			if last, ok := w.lastSeq[producer]; ok && seq <= last {
+				fail(w, "%s returned w%d#%d after w%d#%d", op, producer, seq, producer, last)
+				return
+			}
+			w.lastSeq[producer] = seq
+		}
+	}
+
+	deadline := start.Add(cfg.duration)
+	wg := sync.WaitGroup{}
+	wg.Add(cfg.workers)
+	for _, w := range workers {
+		go func(w *worker) {
+			defer wg.Done()
+			for i := 0; !stop.Load(); i++ {
+				if i%256 == 0 && time.Now().After(deadline) {
+					return
+				}
+				w.injectFault(cfg.faults)
+
+				if w.rng.IntN(2) == 0 && w.pushed < maxSeq {
+					op := tgt.pushes[w.rng.IntN(len(tgt.pushes))]
+					value := encode(w.id, w.pushed)
+					w.record(event{at: time.Since(start), op: op.name, value: value, ok: true})
+					op.push(value)
+					w.pushed++
+				} else {
+					op := tgt.pops[w.rng.IntN(len(tgt.pops))]
+					w.record(event{at: time.Since(start), op: op.name, running: true})
+					value, ok := op.pop()
+					w.amend(ok, value)
+					if ok {
+						w.pops++
+						consume(w, op.name, value)
+					}
+				}
+				w.done.Add(1)
+			}
+		}(w)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	if stuck := watch(workers, finished, cfg.stall); stuck != nil {
+		stop.Store(true)
+		return outcome{
+			failure: &failure{
+				message: fmt.Sprintf("worker %d completed no operation for %v, the container livelocked", stuck.id, cfg.stall),
+				worker:  stuck.id,
+				history: stuck.history(),
+			},
+			stalled: true,
+		}
+	}
+
+	res := outcome{}
+	for _, w := range workers {
+		res.pushes += w.pushed
+		res.pops += w.pops
+	}
+	if firstFail != nil {
+		res.failure = firstFail
+		return res
+	}
+
+	// drain what is left with the first worker's bookkeeping
+	drainer := workers[0]
+	drainer.lastSeq = map[int]int{}
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for _, op := range tgt.pops {
+			for firstFail == nil {
+				drainer.record(event{at: time.Since(start), op: op.name, running: true})
+				value, ok := op.pop()
+				drainer.amend(ok, value)
+				drainer.done.Add(1)
+				if !ok {
+					break
+				}
+				res.pops++
+				consume(drainer, op.name, value)
+			}
+		}
+	}()
+	if watch([]*worker{drainer}, drained, cfg.stall) != nil {
+		return outcome{
+			failure: &failure{
+				message: fmt.Sprintf("draining completed no operation for %v, the container livelocked", cfg.stall),
+				worker:  drainer.id,
+				history: drainer.history(),
+			},
+			stalled: true,
+		}
+	}
+	if firstFail != nil {
+		res.failure = firstFail
+		return res
+	}
+
+	for producer, w := range workers {
+		for seq := 0; seq < w.pushed; seq++ {
+			if !popped[producer].has(seq) {
+				return outcome{
+					pushes:  res.pushes,
+					pops:    res.pops,
+					failure: &failure{message: fmt.Sprintf("w%d#%d was pushed but never popped", producer, seq), worker: producer, history: w.history()},
+				}
+			}
+		}
+	}
+	return res
+}
+
+// watch returns a worker that completed no operation for the stall
+// duration, or nil once finished is closed.
+func watch(workers []*worker, finished <-chan struct{}, stall time.Duration) *worker {
+	if stall <= 0 {
+		<-finished
+		return nil
+	}
+
+	last := make([]int64, len(workers))
+	since := make([]time.Time, len(workers))
+	now := time.Now()
+	for i := range since {
+		since[i] = now
+	}
+
+	ticker := time.NewTicker(stall / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-finished:
+			return nil
+		case now := <-ticker.C:
+			for i, w := range workers {
+				if done := w.done.Load(); done != last[i] {
+					last[i], since[i] = done, now
+				} else if now.Sub(since[i]) >= stall {
+					return w
+				}
+			}
+		}
+	}
+}
+
+func (w *worker) injectFault(probability float64) {
+	if probability <= 0 || w.rng.Float64() >= probability {
+		return
+	}
+	switch w.rng.IntN(3) {
+	case 0:
+		runtime.Gosched()
+	case 1:
+		time.Sleep(time.Duration(w.rng.IntN(50)) * time.Microsecond)
+	default:
+		// busy loop, so the goroutine is preempted in the middle of its quantum
+		for i, n := 0, w.rng.IntN(10_000); i < n; i++ {
+			_ = w.rng.Uint64()
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/peletor/treiber/deque"
+	"github.com/peletor/treiber/queue"
+	"github.com/peletor/treiber/stack"
+)
+
+type operation struct {
+	name string
+	push func(value int)
+	pop  func() (value int, ok bool)
+}
+
+// target is a fresh container together with the operations the workers
+// pick from. fifo enables the per-producer order check.
+type target struct {
+	pushes []operation
+	pops   []operation
+	fifo   bool
+}
+
+var targets = map[string]func() target{
+	"stack": func() target {
+		st := stack.NewStack()
+		return target{
+			pushes: []operation{{name: "Push", push: st.Push}},
+			pops:   []operation{{name: "Pop", pop: st.Pop}},
+		}
+	},
+	"queue": func() target {
+		que := queue.NewQueue()
+		return target{
+			pushes: []operation{{name: "Push", push: que.Push}},
+			pops:   []operation{{name: "Pop", pop: que.Pop}},
+			fifo:   true,
+		}
+	},
+	"deque": func() target {
+		deq := deque.NewDeque()
+		return target{
+			pushes: []operation{{name: "PushBack", push: deq.PushBack}, {name: "PushFront", push: deq.PushFront}},
+			pops:   []operation{{name: "PopBack", pop: deq.PopBack}, {name: "PopFront", pop: deq.PopFront}},
+		}
+	},
+}
+
+func targetNames() []string {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,15 @@
+// Command treibervet reports misuse of the containers of this module, see
+// package treibervet:
+//
+//	go run ./cmd/treibervet ./...
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/singlechecker"
+
+	"github.com/peletor/treiber/analysis/treibervet"
+)
+
+func main() {
+	singlechecker.Main(treibervet.Analyzer)
+}
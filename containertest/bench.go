@@ -0,0 +1,42 @@
+package containertest
+
+import (
+	"sync"
+	"testing"
+)
+
+// BenchmarkProducerConsumer runs pairs producers that push b.N values
+// between them, and as many consumers, each popping exactly as many values
+// as its paired producer pushes. Consumers spin on empty pops, so the ends
+// of the container are hammered from both sides.
+func BenchmarkProducerConsumer[T any](b *testing.B, pairs int, push func(T), pop func() (T, bool), value func(i int) T) {
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	wg := sync.WaitGroup{}
+	wg.Add(2 * pairs)
+
+	for p := 0; p < pairs; p++ {
+		share := b.N / pairs
+		if p < b.N%pairs {
+			share++
+		}
+
+		go func(share int) {
+			defer wg.Done()
+			for i := 0; i < share; i++ {
+				push(value(i))
+			}
+		}(share)
+
+		go func(share int) {
+			defer wg.Done()
+			for share > 0 {
+				if _, ok := pop(); ok {
+					share--
+				}
+			}
+		}(share)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,278 @@
+// Package containertest checks that an implementation of the treiber
+// interfaces behaves like a stack, a queue or a deque:
+//
+//	func TestConformance(t *testing.T) {
+//		containertest.TestContainer(t, containertest.LIFO, func() treiber.Container[int] {
+//			return NewStack()
+//		}, containertest.Int)
+//	}
+package containertest
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/peletor/treiber"
+)
+
+// Order is the order in which a container returns its values.
+type Order int
+
+const (
+	FIFO Order = iota
+	LIFO
+	// Unordered is for relaxed containers, e.g. a sharded queue: every value
+	// pushed must be popped exactly once, in any order.
+	Unordered
+)
+
+func (o Order) String() string {
+	switch o {
+	case LIFO:
+		return "LIFO"
+	case Unordered:
+		return "Unordered"
+	}
+	return "FIFO"
+}
+
+// Int is the value function for containers of int: value i is i.
+func Int(i int) int { return i }
+
+const (
+	sequentialItems = 100
+	goroutines      = 8
+	perGoroutine    = 1_000
+)
+
+// TestContainer runs the conformance tests against containers made by
+// newContainer, each test with a fresh one. value must return distinct
+// values for distinct i.
+func TestContainer[T comparable](t *testing.T, order Order, newContainer func() treiber.Container[T], value func(i int) T) {
+	t.Run("Empty", func(t *testing.T) {
+		testEmpty(t, newContainer())
+	})
+	t.Run(order.String(), func(t *testing.T) {
+		testOrder(t, order, newContainer(), value)
+	})
+	t.Run("Interleaved", func(t *testing.T) {
+		testInterleaved(t, order, newContainer(), value)
+	})
+	t.Run("Concurrent conservation", func(t *testing.T) {
+		testConservation(t, order == FIFO, newContainer(), value)
+	})
+}
+
+// TestDoubleEnded runs TestContainer for every pairing of the ends of
+// deques made by newDeque, and checks conservation with goroutines using
+// both ends at once.
+func TestDoubleEnded[T comparable](t *testing.T, newDeque func() treiber.DoubleEnded[T], value func(i int) T) {
+	pairings := []struct {
+		name  string
+		order Order
+		adapt func(treiber.DoubleEnded[T]) treiber.Container[T]
+	}{
+		{"PushBack PopFront", FIFO, func(d treiber.DoubleEnded[T]) treiber.Container[T] {
+			return ends[T]{d, d.PushBack, d.PopFront}
+		}},
+		{"PushFront PopBack", FIFO, func(d treiber.DoubleEnded[T]) treiber.Container[T] {
+			return ends[T]{d, d.PushFront, d.PopBack}
+		}},
+		{"PushBack PopBack", LIFO, func(d treiber.DoubleEnded[T]) treiber.Container[T] {
+			return ends[T]{d, d.PushBack, d.PopBack}
+		}},
+		{"PushFront PopFront", LIFO, func(d treiber.DoubleEnded[T]) treiber.Container[T] {
+			return ends[T]{d, d.PushFront, d.PopFront}
+		}},
+	}
+	for _, p := range pairings {
+		t.Run(p.name, func(t *testing.T) {
+			TestContainer(t, p.order, func() treiber.Container[T] { return p.adapt(newDeque()) }, value)
+		})
+	}
+
+	t.Run("Both ends concurrent conservation", func(t *testing.T) {
+		testConservation(t, false, mixed[T]{newDeque(), &atomic.Uint64{}}, value)
+	})
+}
+
+// ends makes a Container of one push and one pop end of a deque.
+type ends[T any] struct {
+	treiber.DoubleEnded[T]
+	push func(T)
+	pop  func() (T, bool)
+}
+
+func (e ends[T]) Push(value T)   { e.push(value) }
+func (e ends[T]) Pop() (T, bool) { return e.pop() }
+
+// mixed alternates between the ends of a deque, whichever goroutine calls.
+type mixed[T any] struct {
+	treiber.DoubleEnded[T]
+	calls *atomic.Uint64
+}
+
+func (m mixed[T]) Push(value T) {
+	if m.calls.Add(1)%2 == 0 {
+		m.PushFront(value)
+	} else {
+		m.PushBack(value)
+	}
+}
+
+func (m mixed[T]) Pop() (T, bool) {
+	if m.calls.Add(1)%2 == 0 {
+		return m.PopFront()
+	}
+	return m.PopBack()
+}
+
+func testEmpty[T comparable](t *testing.T, c treiber.Container[T]) {
+	var zero T
+	if value, ok := c.Pop(); ok || value != zero {
+		t.Errorf("Pop on an empty container = %v, %v, want the zero value, false", value, ok)
+	}
+	if n := c.Len(); n != 0 {
+		t.Errorf("Len of an empty container = %d", n)
+	}
+}
+
+// expected returns the index of the value the i-th pop of n pushed values
+// must return.
+func expected(order Order, n, i int) int {
+	if order == LIFO {
+		return n - 1 - i
+	}
+	return i
+}
+
+func testOrder[T comparable](t *testing.T, order Order, c treiber.Container[T], value func(int) T) {
+	for i := 0; i < sequentialItems; i++ {
+		c.Push(value(i))
+	}
+	if n := c.Len(); n != sequentialItems {
+		t.Errorf("Len after %d pushes = %d", sequentialItems, n)
+	}
+	left := make(map[T]bool, sequentialItems)
+	for i := 0; i < sequentialItems; i++ {
+		left[value(i)] = true
+	}
+	for i := 0; i < sequentialItems; i++ {
+		got, ok := c.Pop()
+		if order == Unordered {
+			if !ok || !left[got] {
+				t.Fatalf("pop %d = %v, %v, want one of the values not yet popped", i, got, ok)
+			}
+			delete(left, got)
+			continue
+		}
+		if want := value(expected(order, sequentialItems, i)); !ok || got != want {
+			t.Fatalf("pop %d = %v, %v, want %v, true", i, got, ok, want)
+		}
+	}
+	testEmpty(t, c)
+}
+
+// testInterleaved pushes two values and pops one, so the container is never
+// drained in between.
+func testInterleaved[T comparable](t *testing.T, order Order, c treiber.Container[T], value func(int) T) {
+	var model []int
+	for i := 0; i < sequentialItems; i++ {
+		c.Push(value(2 * i))
+		c.Push(value(2*i + 1))
+		model = append(model, 2*i, 2*i+1)
+
+		var want int
+		switch order {
+		case LIFO:
+			want, model = model[len(model)-1], model[:len(model)-1]
+		case Unordered:
+			got, ok := c.Pop()
+			at := slices.IndexFunc(model, func(v int) bool { return value(v) == got })
+			if !ok || at < 0 {
+				t.Fatalf("pop %d = %v, %v, want one of the values not yet popped", i, got, ok)
+			}
+			model = slices.Delete(model, at, at+1)
+			continue
+		default:
+			want, model = model[0], model[1:]
+		}
+		if got, ok := c.Pop(); !ok || got != value(want) {
+			t.Fatalf("pop %d = %v, %v, want %v, true", i, got, ok, value(want))
+		}
+	}
+	if n := c.Len(); n != len(model) {
+		t.Errorf("Len = %d, want %d", n, len(model))
+	}
+}
+
+// testConservation has every goroutine push its own values, each followed by
+// a pop, then drains the container. Every value must come out exactly
+// once, and with fifo every goroutine must see each producer's values in the
+// order they were pushed.
+func testConservation[T comparable](t *testing.T, fifo bool, c treiber.Container[T], value func(int) T) {
+	index := make(map[T]int, goroutines*perGoroutine)
+	for i := 0; i < goroutines*perGoroutine; i++ {
+		index[value(i)] = i
+	}
+
+	var mu sync.Mutex
+	popped := make([]int, goroutines*perGoroutine)
+	var failure error
+	consume := func(v T, last []int) {
+		i, known := index[v]
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case !known:
+			failure = fmt.Errorf("popped %v, which was never pushed", v)
+		case popped[i] > 0:
+			failure = fmt.Errorf("popped %v twice", v)
+		case fifo && i <= last[i/perGoroutine]:
+			failure = fmt.Errorf("popped %v after %v, pushed later by the same goroutine", v, value(last[i/perGoroutine]))
+		}
+		if known {
+			popped[i]++
+			last[i/perGoroutine] = i
+		}
+	}
+	newLast := func() []int {
+		last := make([]int, goroutines)
+		for i := range last {
+			last[i] = -1
+		}
+		return last
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			defer wg.Done()
+			last := newLast()
+			for i := 0; i < perGoroutine; i++ {
+				c.Push(value(g*perGoroutine + i))
+				if v, ok := c.Pop(); ok {
+					consume(v, last)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	last := newLast()
+	for v, ok := c.Pop(); ok; v, ok = c.Pop() {
+		consume(v, last)
+	}
+	if failure != nil {
+		t.Fatal(failure)
+	}
+	for i, n := range popped {
+		if n == 0 {
+			t.Fatalf("%v was pushed but never popped", value(i))
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package containertest
+
+import (
+	"github.com/peletor/treiber"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// slice is a mutex-guarded reference deque, with the front at index 0.
+type slice[T any] struct {
+	mu     sync.Mutex
+	values []T
+}
+
+func (s *slice[T]) PushBack(value T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = append(s.values, value)
+}
+
+func (s *slice[T]) PushFront(value T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = append([]T{value}, s.values...)
+}
+
+func (s *slice[T]) PopBack() (value T, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.values) == 0 {
+		return value, false
+	}
+	value = s.values[len(s.values)-1]
+	s.values = s.values[:len(s.values)-1]
+	return value, true
+}
+
+func (s *slice[T]) PopFront() (value T, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.values) == 0 {
+		return value, false
+	}
+	value = s.values[0]
+	s.values = s.values[1:]
+	return value, true
+}
+
+func (s *slice[T]) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.values)
+}
+
+// sliceStack and sliceQueue use one end of slice each way.
+type sliceStack[T any] struct{ slice[T] }
+
+func (s *sliceStack[T]) Push(value T)   { s.PushBack(value) }
+func (s *sliceStack[T]) Pop() (T, bool) { return s.PopBack() }
+
+type sliceQueue[T any] struct{ slice[T] }
+
+func (q *sliceQueue[T]) Push(value T)   { q.PushBack(value) }
+func (q *sliceQueue[T]) Pop() (T, bool) { return q.PopFront() }
+
+func TestReferenceImplementations(t *testing.T) {
+	t.Run("Stack", func(t *testing.T) {
+		TestContainer(t, LIFO, func() treiber.Container[int] { return &sliceStack[int]{} }, Int)
+	})
+
+	t.Run("Queue", func(t *testing.T) {
+		TestContainer(t, FIFO, func() treiber.Container[int] { return &sliceQueue[int]{} }, Int)
+	})
+
+	t.Run("Unordered", func(t *testing.T) {
+		TestContainer(t, Unordered, func() treiber.Container[int] { return &sliceStack[int]{} }, Int)
+		TestContainer(t, Unordered, func() treiber.Container[int] { return &sliceQueue[int]{} }, Int)
+	})
+
+	t.Run("Deque", func(t *testing.T) {
+		TestDoubleEnded(t, func() treiber.DoubleEnded[int] { return &slice[int]{} }, Int)
+	})
+
+	t.Run("Strings", func(t *testing.T) {
+		TestContainer(t, FIFO, func() treiber.Container[string] { return &sliceQueue[string]{} }, strconv.Itoa)
+	})
+}
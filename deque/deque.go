@@ -1,176 +1,517 @@
 package deque
 
 import (
-	"sync/atomic"
 	"unsafe"
+
+	"github.com/peletor/treiber/backoff"
+	"github.com/peletor/treiber/internal/kcas"
+	"github.com/peletor/treiber/internal/nocopy"
+	"github.com/peletor/treiber/internal/pad"
+	"github.com/peletor/treiber/internal/sched"
+	"github.com/peletor/treiber/internal/stats"
+	"github.com/peletor/treiber/tracing"
 )
 
 type dequeItem struct {
 	value int
 	prev  unsafe.Pointer
 	next  unsafe.Pointer
+	// claim is nil until a pop claims the item, see claimed
+	claim unsafe.Pointer
+}
+
+// paddedDequeItem fills a whole cache line, so neighbouring nodes are never
+// written through the same line.
+type paddedDequeItem struct {
+	dequeItem
+	_ [pad.CacheLineSize - unsafe.Sizeof(dequeItem{})]byte
 }
 
+// claimed is the claim of a popped item. A popped item stays linked until a
+// later pop squeezes it out.
+var claimed = unsafe.Pointer(new(int))
+
+// Deque keeps front and back on separate cache lines, so goroutines working
+// on opposite ends do not invalidate each other's line.
+//
+// The ends are only hints, after Java's ConcurrentLinkedDeque: the items
+// form a doubly linked list that always holds at least one item, and the
+// first and the last item are the ones without prev and without next. A
+// push links its item to the first or the last item with one CAS. A pop
+// claims the closest item that is not popped yet with one CAS, and only
+// then squeezes popped items out of the links, so pops at one end never
+// have to move the other end.
+// The zero Deque is empty and ready to use; it must not be copied after
+// first use.
 type Deque struct {
+	_     nocopy.NoCopy
+	_     pad.CacheLinePad
 	front unsafe.Pointer
+	_     pad.CacheLinePad
 	back  unsafe.Pointer
+	_     pad.CacheLinePad
+
+	stats       stats.Counters
+	paddedNodes bool
+	backoff     backoff.Backoff
+	tracer      tracing.Tracer
+}
+
+// Stats is a snapshot of the counters of a container.
+// The counters are only maintained when built with the treiberstats tag.
+type Stats = stats.Snapshot
+
+// Option configures a Deque created by NewDeque.
+type Option func(*Deque)
+
+// WithPaddedNodes allocates every item on its own cache line.
+// It costs memory, but adjacent items are no longer falsely shared.
+func WithPaddedNodes() Option {
+	return func(d *Deque) {
+		d.paddedNodes = true
+	}
+}
+
+// WithBackoff makes every CAS loop of the deque wait with b before retrying.
+func WithBackoff(b backoff.Backoff) Option {
+	return func(d *Deque) {
+		d.backoff = b
+	}
+}
+
+// WithTracer reports the start, retries and end of every operation to t.
+func WithTracer(t tracing.Tracer) Option {
+	return func(d *Deque) {
+		d.tracer = t
+	}
 }
 
-func NewDeque() Deque {
-	return Deque{}
+func NewDeque(opts ...Option) *Deque {
+	d := &Deque{}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+func (d *Deque) newItem(value int) unsafe.Pointer {
+	if d.paddedNodes {
+		item := &paddedDequeItem{dequeItem: dequeItem{value: value}}
+		return unsafe.Pointer(&item.dequeItem)
+	}
+	return unsafe.Pointer(&dequeItem{value: value})
+}
+
+// head returns the front hint. A zero Deque gets its first item here.
+func (d *Deque) head() unsafe.Pointer {
+	if front := sched.LoadPointer(&d.front); front != nil {
+		return front
+	}
+	d.init()
+	return sched.LoadPointer(&d.front)
+}
+
+// tail returns the back hint. A zero Deque gets its first item here.
+func (d *Deque) tail() unsafe.Pointer {
+	if back := sched.LoadPointer(&d.back); back != nil {
+		return back
+	}
+	d.init()
+	return sched.LoadPointer(&d.back)
+}
+
+// init links the popped item every deque starts with, as the list is never
+// empty. The back is set after the front, to whatever the front is by then.
+func (d *Deque) init() {
+	sched.CompareAndSwapPointer(&d.front, nil, unsafe.Pointer(&dequeItem{claim: claimed}))
+	sched.CompareAndSwapPointer(&d.back, nil, sched.LoadPointer(&d.front))
+}
+
+// firstFrom follows prev from item to the first item.
+func firstFrom(item unsafe.Pointer) unsafe.Pointer {
+	for prev := kcas.Load(&(*dequeItem)(item).prev); prev != nil; prev = kcas.Load(&(*dequeItem)(item).prev) {
+		item = prev
+	}
+	return item
+}
+
+// lastFrom follows next from item to the last item.
+func lastFrom(item unsafe.Pointer) unsafe.Pointer {
+	for next := kcas.Load(&(*dequeItem)(item).next); next != nil; next = kcas.Load(&(*dequeItem)(item).next) {
+		item = next
+	}
+	return item
+}
+
+// first returns the item without prev, and moves the front hint to it.
+func (d *Deque) first() unsafe.Pointer {
+	front := d.head()
+	first := firstFrom(front)
+	if first != front {
+		sched.CompareAndSwapPointer(&d.front, front, first)
+	}
+	return first
+}
+
+// last returns the item without next, and moves the back hint to it.
+func (d *Deque) last() unsafe.Pointer {
+	back := d.tail()
+	last := lastFrom(back)
+	if last != back {
+		sched.CompareAndSwapPointer(&d.back, back, last)
+	}
+	return last
+}
+
+// popped reports whether a pop claimed the item.
+func popped(item unsafe.Pointer) bool {
+	return kcas.Load(&(*dequeItem)(item).claim) != nil
+}
+
+// findBack returns the last item that is not popped, or nil if every item
+// is. current is false if an item was pushed at the back during the walk;
+// the result may be outdated then.
+func (d *Deque) findBack() (item unsafe.Pointer, current bool) {
+	last := d.last()
+	item = last
+	for item != nil && popped(item) {
+		item = kcas.Load(&(*dequeItem)(item).prev)
+	}
+	// recheck that nothing follows, so item was the last one not popped
+	return item, kcas.Load(&(*dequeItem)(last).next) == nil
+}
+
+// findFront is findBack for the front end.
+func (d *Deque) findFront() (item unsafe.Pointer, current bool) {
+	first := d.first()
+	item = first
+	for item != nil && popped(item) {
+		item = kcas.Load(&(*dequeItem)(item).next)
+	}
+	return item, kcas.Load(&(*dequeItem)(first).prev) == nil
 }
 
 func (d *Deque) PushBack(value int) {
-	newItem := unsafe.Pointer(&dequeItem{value: value, next: nil})
+	span := tracing.Start(d.tracer, "Deque.PushBack")
+	defer tracing.End(span)
 
-	for {
-		back := atomic.LoadPointer(&d.back)
-		(*dequeItem)(newItem).prev = back
-
-		if back != nil {
-			// Deque is not empty. dequeItem = *q.back exist
-			next := atomic.LoadPointer(&(*dequeItem)(back).next)
-			// if d.back is not changed in other goroutine
-			if back == atomic.LoadPointer(&d.back) {
-				if next == nil {
-					if atomic.CompareAndSwapPointer(&(*dequeItem)(back).next, next, newItem) {
-						// try to move d.back
-						atomic.CompareAndSwapPointer(&d.back, back, newItem)
-						return
-					}
-				} else {
-					// try to fix d.back
-					atomic.CompareAndSwapPointer(&d.back, back, next)
-				}
-			}
-		} else {
-			// Deque is empty.
-
-			// if deque back is not changed in other goroutine
-			if back == atomic.LoadPointer(&d.back) {
-				if atomic.CompareAndSwapPointer(&d.back, back, newItem) {
-					// try to move deque front
-					atomic.CompareAndSwapPointer(&d.front, nil, newItem)
-					return
-				} else {
-					// try to fix deque front
-					atomic.CompareAndSwapPointer(&d.front, nil, newItem)
-				}
+	newItem := d.newItem(value)
+
+	for attempt := 0; ; attempt++ {
+		back := d.tail()
+		last := lastFrom(back)
+		(*dequeItem)(newItem).prev = last
+		if sched.CompareAndSwapPointer(&(*dequeItem)(last).next, nil, newItem) {
+			// move d.back once it is two items behind, failure is fine
+			if last != back {
+				sched.CompareAndSwapPointer(&d.back, back, newItem)
 			}
+			d.stats.Push()
+			return
 		}
+		d.stats.CASFailure()
+		d.retry(span, attempt)
 	}
 }
 
 func (d *Deque) PopBack() (value int, ok bool) {
-	for {
-		back := atomic.LoadPointer(&d.back)
-		front := atomic.LoadPointer(&d.front)
-		if back == nil {
+	span := tracing.Start(d.tracer, "Deque.PopBack")
+	defer tracing.End(span)
+
+	for attempt := 0; ; attempt++ {
+		item, current := d.findBack()
+		switch {
+		case !current:
+			// an item was pushed at the back meanwhile
+		case item == nil:
 			// Deque is empty
+			d.stats.EmptyPop()
 			return 0, false
+		case sched.CompareAndSwapPointer(&(*dequeItem)(item).claim, nil, claimed):
+			unlink(item)
+			d.stats.Pop()
+			return (*dequeItem)(item).value, true
+		default:
+			d.stats.CASFailure()
 		}
+		d.retry(span, attempt)
+	}
+}
 
-		// Deque is not empty. dequeItem = *q.back exist
-		prev := atomic.LoadPointer(&(*dequeItem)(back).prev)
-
-		if back == atomic.LoadPointer(&d.back) {
-			// if deque has only one dequeItem
-			if prev == nil {
-				if atomic.CompareAndSwapPointer(&d.back, back, nil) {
-					// Try to move deque front
-					atomic.CompareAndSwapPointer(&d.front, front, nil)
-
-					return (*dequeItem)(back).value, true
-				}
-			} else {
-				// now prevItem.next == q.back
-				prevItemNext := atomic.LoadPointer(&(*dequeItem)(prev).next)
-				// try to make prevItem = last item (prevItem.next = nil)
-				if prevItemNext != nil {
-					if atomic.CompareAndSwapPointer(&(*dequeItem)(prev).next, prevItemNext, nil) {
-						// try to move d.back
-						atomic.CompareAndSwapPointer(&d.back, back, prev)
-						return (*dequeItem)(back).value, true
-					}
-				}
+func (d *Deque) PushFront(value int) {
+	span := tracing.Start(d.tracer, "Deque.PushFront")
+	defer tracing.End(span)
+
+	newItem := d.newItem(value)
+
+	for attempt := 0; ; attempt++ {
+		front := d.head()
+		first := firstFrom(front)
+		(*dequeItem)(newItem).next = first
+		if sched.CompareAndSwapPointer(&(*dequeItem)(first).prev, nil, newItem) {
+			// move d.front once it is two items behind, failure is fine
+			if first != front {
+				sched.CompareAndSwapPointer(&d.front, front, newItem)
 			}
+			d.stats.Push()
+			return
 		}
+		d.stats.CASFailure()
+		d.retry(span, attempt)
 	}
 }
 
-func (d *Deque) PushFront(value int) {
-	newItem := unsafe.Pointer(&dequeItem{value: value, prev: nil})
+func (d *Deque) PopFront() (value int, ok bool) {
+	span := tracing.Start(d.tracer, "Deque.PopFront")
+	defer tracing.End(span)
 
+	for attempt := 0; ; attempt++ {
+		item, current := d.findFront()
+		switch {
+		case !current:
+			// an item was pushed at the front meanwhile
+		case item == nil:
+			// Deque is empty
+			d.stats.EmptyPop()
+			return 0, false
+		case sched.CompareAndSwapPointer(&(*dequeItem)(item).claim, nil, claimed):
+			unlink(item)
+			d.stats.Pop()
+			return (*dequeItem)(item).value, true
+		default:
+			d.stats.CASFailure()
+		}
+		d.retry(span, attempt)
+	}
+}
+
+// unlink squeezes the popped item x, and the popped items around it, out of
+// the links. The first and the last item stay linked even when popped, and
+// next to them a popped item is only squeezed out together with the next
+// one, so that most pops at the ends cost a single CAS.
+func unlink(x unsafe.Pointer) {
+	prev := kcas.Load(&(*dequeItem)(x).prev)
+	next := kcas.Load(&(*dequeItem)(x).next)
+	switch {
+	case prev == nil && next == nil:
+		// x is the only item
+	case prev == nil:
+		unlinkFirst(x, next)
+	case next == nil:
+		unlinkLast(x, prev)
+	default:
+		hops := 1
+		before, isFirst := prev, false
+		for popped(before) {
+			p := kcas.Load(&(*dequeItem)(before).prev)
+			if p == nil {
+				isFirst = true
+				break
+			}
+			before = p
+			hops++
+		}
+		after, isLast := next, false
+		for popped(after) {
+			n := kcas.Load(&(*dequeItem)(after).next)
+			if n == nil {
+				isLast = true
+				break
+			}
+			after = n
+			hops++
+		}
+		if hops < 2 && (isFirst || isLast) {
+			return
+		}
+		skipPoppedSuccessors(before)
+		skipPoppedPredecessors(after)
+	}
+}
+
+// unlinkFirst links the popped first item to the closest item after it
+// that is not popped, or to the last item, once there are two popped items
+// in between.
+func unlinkFirst(first, next unsafe.Pointer) {
+	p, hops := next, 0
+	for popped(p) {
+		n := kcas.Load(&(*dequeItem)(p).next)
+		if n == nil {
+			break
+		}
+		p = n
+		hops++
+	}
+	if hops >= 2 && sched.CompareAndSwapPointer(&(*dequeItem)(first).next, next, p) {
+		skipPoppedPredecessors(p)
+	}
+}
+
+// unlinkLast is unlinkFirst for the last item.
+func unlinkLast(last, prev unsafe.Pointer) {
+	p, hops := prev, 0
+	for popped(p) {
+		n := kcas.Load(&(*dequeItem)(p).prev)
+		if n == nil {
+			break
+		}
+		p = n
+		hops++
+	}
+	if hops >= 2 && sched.CompareAndSwapPointer(&(*dequeItem)(last).prev, prev, p) {
+		skipPoppedSuccessors(p)
+	}
+}
+
+// skipPoppedSuccessors points x.next at the closest item after it that is
+// not popped, or at the last item. It tries again while x is still reached
+// from the items around it, that is while it is not popped or is the first.
+func skipPoppedSuccessors(x unsafe.Pointer) {
 	for {
-		front := atomic.LoadPointer(&d.front)
-		(*dequeItem)(newItem).next = front
-
-		if front != nil {
-			// Deque is not empty. dequeItem = *q.front exist
-			prev := atomic.LoadPointer(&(*dequeItem)(front).prev)
-			// if d.front is not changed in other goroutine
-			if front == atomic.LoadPointer(&d.front) {
-				if prev == nil {
-					if atomic.CompareAndSwapPointer(&(*dequeItem)(front).prev, prev, newItem) {
-						// try to move d.front
-						atomic.CompareAndSwapPointer(&d.front, front, newItem)
-						return
-					}
-				} else {
-					// try to fix d.front
-					atomic.CompareAndSwapPointer(&d.front, front, prev)
-				}
+		next := kcas.Load(&(*dequeItem)(x).next)
+		if next == nil {
+			return
+		}
+		p := next
+		for popped(p) {
+			n := kcas.Load(&(*dequeItem)(p).next)
+			if n == nil {
+				break
 			}
-		} else {
-			// Deque is empty
+			p = n
+		}
+		if p == next || sched.CompareAndSwapPointer(&(*dequeItem)(x).next, next, p) {
+			return
+		}
+		if popped(x) && kcas.Load(&(*dequeItem)(x).prev) != nil {
+			return
+		}
+	}
+}
+
+// skipPoppedPredecessors is skipPoppedSuccessors for x.prev.
+func skipPoppedPredecessors(x unsafe.Pointer) {
+	for {
+		prev := kcas.Load(&(*dequeItem)(x).prev)
+		if prev == nil {
+			return
+		}
+		p := prev
+		for popped(p) {
+			n := kcas.Load(&(*dequeItem)(p).prev)
+			if n == nil {
+				break
+			}
+			p = n
+		}
+		if p == prev || sched.CompareAndSwapPointer(&(*dequeItem)(x).prev, prev, p) {
+			return
+		}
+		if popped(x) && kcas.Load(&(*dequeItem)(x).next) != nil {
+			return
+		}
+	}
+}
 
-			// if d.front is not changed in other goroutine
-			if front == atomic.LoadPointer(&d.front) {
-				if atomic.CompareAndSwapPointer(&d.front, front, newItem) {
-					// try to move deque back
-					atomic.CompareAndSwapPointer(&d.back, nil, newItem)
-					return
-				} else {
-					atomic.CompareAndSwapPointer(&d.back, nil, newItem)
-				}
+// PeekBack returns the value at the back of the deque without removing it.
+func (d *Deque) PeekBack() (value int, ok bool) {
+	for {
+		if item, current := d.findBack(); current {
+			if item == nil {
+				return 0, false
 			}
+			return (*dequeItem)(item).value, true
 		}
 	}
 }
 
-func (d *Deque) PopFront() (value int, ok bool) {
+// PeekFront returns the value at the front of the deque without removing it.
+func (d *Deque) PeekFront() (value int, ok bool) {
 	for {
-		front := atomic.LoadPointer(&d.front)
-		back := atomic.LoadPointer(&d.back)
-		if front == nil {
-			// Deque is empty
-			return 0, false
+		if item, current := d.findFront(); current {
+			if item == nil {
+				return 0, false
+			}
+			return (*dequeItem)(item).value, true
 		}
+	}
+}
 
-		// Deque is not empty. dequeItem = *q.front exist
-		next := atomic.LoadPointer(&(*dequeItem)(front).next)
-
-		if front == atomic.LoadPointer(&d.front) {
-			// if deque has only one dequeItem
-			if next == nil {
-				if atomic.CompareAndSwapPointer(&d.front, front, nil) {
-					// Try to move deque back
-					atomic.CompareAndSwapPointer(&d.back, back, nil)
-
-					return (*dequeItem)(front).value, true
-				}
-			} else {
-				// now nextItem.prev == q.front
-				nextItemPrev := atomic.LoadPointer(&(*dequeItem)(next).prev)
-				// try to make nextItem = first item (nextItem.prev = nil)
-				if nextItemPrev != nil {
-					if atomic.CompareAndSwapPointer(&(*dequeItem)(next).prev, nextItemPrev, nil) {
-						// try to move d.front
-						atomic.CompareAndSwapPointer(&d.front, front, next)
-						return (*dequeItem)(front).value, true
-					}
-				}
+// Len counts the items that are not popped by walking the deque from the
+// front to the back. It is O(n), and under concurrent pushes and pops the
+// result is only an approximation.
+func (d *Deque) Len() int {
+	length := 0
+	for item := d.first(); item != nil; item = kcas.Load(&(*dequeItem)(item).next) {
+		if !popped(item) {
+			length++
+		}
+	}
+	return length
+}
+
+// Snapshot returns the values of the deque, front first. It is
+// linearizable: after the walk, one KCAS that swaps nothing checks that the
+// first item is still the first, that every link followed is unchanged and
+// that no value taken was popped meanwhile, so at that instant the deque
+// held exactly these values. It retries while pushes and pops keep changing
+// the deque.
+func (d *Deque) Snapshot() []int {
+	span := tracing.Start(d.tracer, "Deque.Snapshot")
+	defer tracing.End(span)
+
+	for attempt := 0; ; attempt++ {
+		first := d.first()
+		var values []int
+		entries := []kcas.Entry{{Addr: &(*dequeItem)(first).prev}}
+		for item := first; item != nil; {
+			if !popped(item) {
+				values = append(values, (*dequeItem)(item).value)
+				entries = append(entries, kcas.Entry{Addr: &(*dequeItem)(item).claim})
 			}
+			next := kcas.Load(&(*dequeItem)(item).next)
+			entries = append(entries, kcas.Entry{Addr: &(*dequeItem)(item).next, Old: next, New: next})
+			item = next
+		}
+		if kcas.CompareAndSwap(entries...) {
+			return values
+		}
+		d.retry(span, attempt)
+	}
+}
+
+// Preview returns up to n values from the front of the deque, front first.
+// Like Len it walks the items without checks, so under concurrent pushes
+// and pops it may include values popped during the walk, or miss pushed
+// ones.
+func (d *Deque) Preview(n int) []int {
+	if n <= 0 {
+		return nil
+	}
+	var values []int
+	for item := d.first(); item != nil && len(values) < n; item = kcas.Load(&(*dequeItem)(item).next) {
+		if !popped(item) {
+			values = append(values, (*dequeItem)(item).value)
 		}
 	}
+	return values
+}
+
+// Stats returns the counters of the deque.
+func (d *Deque) Stats() Stats {
+	return d.stats.Snapshot()
+}
+
+// PublishExpvar exports Stats as the expvar variable name.
+// It does nothing unless built with the treiberstats tag.
+func (d *Deque) PublishExpvar(name string) {
+	stats.Publish(name, d.Stats)
+}
+
+// retry is called at the end of every failed iteration of a CAS loop.
+func (d *Deque) retry(span tracing.Span, attempt int) {
+	tracing.Retry(span, attempt)
+	if d.backoff != nil {
+		d.backoff.Wait(attempt)
+	}
 }
@@ -1,20 +1,26 @@
 package deque
 
 import (
+	"fmt"
+	"github.com/peletor/treiber"
+	"github.com/peletor/treiber/backoff"
+	"github.com/peletor/treiber/containertest"
+	"github.com/peletor/treiber/internal/stats"
 	"github.com/stretchr/testify/assert"
 	"sync"
 	"testing"
+	"unsafe"
 )
 
 func TestNewDeque(t *testing.T) {
 	t.Run("Deque type exist", func(t *testing.T) {
 		var deq Deque
-		assert.IsType(t, Deque{}, deq)
+		assert.IsType(t, &Deque{}, &deq)
 	})
 
 	t.Run("New Deque", func(t *testing.T) {
 		deq := NewDeque()
-		assert.IsType(t, Deque{}, deq)
+		assert.IsType(t, &Deque{}, deq)
 	})
 
 	t.Run("New Deque empty", func(t *testing.T) {
@@ -23,6 +29,37 @@ func TestNewDeque(t *testing.T) {
 	})
 }
 
+// front and back return the first and the last item that is not popped.
+// The ends of the deque are only hints, and may still point at popped items.
+func front(d *Deque) unsafe.Pointer {
+	if d.front == nil {
+		return nil
+	}
+	return next(d.first())
+}
+
+func back(d *Deque) unsafe.Pointer {
+	if d.back == nil {
+		return nil
+	}
+	return prev(d.last())
+}
+
+// next and prev skip popped items, starting at item itself.
+func next(item unsafe.Pointer) unsafe.Pointer {
+	for item != nil && (*dequeItem)(item).claim != nil {
+		item = (*dequeItem)(item).next
+	}
+	return item
+}
+
+func prev(item unsafe.Pointer) unsafe.Pointer {
+	for item != nil && (*dequeItem)(item).claim != nil {
+		item = (*dequeItem)(item).prev
+	}
+	return item
+}
+
 func TestPushBack(t *testing.T) {
 	const value = 15
 
@@ -35,29 +72,29 @@ func TestPushBack(t *testing.T) {
 
 	t.Run("PushBack do something", func(t *testing.T) {
 		deq := NewDeque()
-		assert.Nil(t, deq.back)
+		assert.Nil(t, back(deq))
 		deq.PushBack(value)
-		assert.NotNil(t, deq.back)
+		assert.NotNil(t, back(deq))
 	})
 
 	t.Run("PushBack move deque back", func(t *testing.T) {
 		deq := NewDeque()
-		oldBack := deq.back
+		oldBack := back(deq)
 		deq.PushBack(value)
-		newBack := deq.back
+		newBack := back(deq)
 		assert.NotEqual(t, oldBack, newBack)
 	})
 
 	t.Run("PushBack: back points to last item", func(t *testing.T) {
 		deq := NewDeque()
 		deq.PushBack(value)
-		assert.Nil(t, (*dequeItem)(deq.back).next)
+		assert.Nil(t, next((*dequeItem)(back(deq)).next))
 	})
 
 	t.Run("PushBack: back points to item with correct value", func(t *testing.T) {
 		deq := NewDeque()
 		deq.PushBack(value)
-		assert.Equal(t, value, (*dequeItem)(deq.back).value)
+		assert.Equal(t, value, (*dequeItem)(back(deq)).value)
 	})
 }
 
@@ -87,16 +124,17 @@ func TestPopBack(t *testing.T) {
 	t.Run("PopBack do something", func(t *testing.T) {
 		deq := NewDeque()
 		deq.PushBack(value)
-		oldBack := deq.back
+		oldBack := back(deq)
 		deq.PopBack()
-		newBack := deq.back
+		newBack := back(deq)
 		assert.NotEqual(t, oldBack, newBack)
 	})
 
 	t.Run("PopBack return correct value", func(t *testing.T) {
 		deq := NewDeque()
 		deq.PushBack(value)
-		val, _ := deq.PopBack()
+		val, ok := deq.PopBack()
+		assert.True(t, ok)
 		assert.Equal(t, value, val)
 	})
 
@@ -105,7 +143,7 @@ func TestPopBack(t *testing.T) {
 		deq.PushBack(value)
 		deq.PushBack(value)
 		deq.PopBack()
-		assert.Nil(t, (*dequeItem)(deq.back).next)
+		assert.Nil(t, next((*dequeItem)(back(deq)).next))
 	})
 }
 
@@ -219,29 +257,29 @@ func TestPushFront(t *testing.T) {
 
 	t.Run("PushFront do something", func(t *testing.T) {
 		deq := NewDeque()
-		assert.Nil(t, deq.front)
+		assert.Nil(t, front(deq))
 		deq.PushFront(value)
-		assert.NotNil(t, deq.front)
+		assert.NotNil(t, front(deq))
 	})
 
 	t.Run("PushFront move deque front", func(t *testing.T) {
 		deq := NewDeque()
-		oldFront := deq.front
+		oldFront := front(deq)
 		deq.PushFront(value)
-		newFront := deq.front
+		newFront := front(deq)
 		assert.NotEqual(t, oldFront, newFront)
 	})
 
 	t.Run("PushFront: front points to first item", func(t *testing.T) {
 		deq := NewDeque()
 		deq.PushFront(value)
-		assert.Nil(t, (*dequeItem)(deq.front).prev)
+		assert.Nil(t, prev((*dequeItem)(front(deq)).prev))
 	})
 
 	t.Run("PushFront: front points to item with correct value", func(t *testing.T) {
 		deq := NewDeque()
 		deq.PushFront(value)
-		assert.Equal(t, value, (*dequeItem)(deq.front).value)
+		assert.Equal(t, value, (*dequeItem)(front(deq)).value)
 	})
 }
 
@@ -321,16 +359,17 @@ func TestPopFront(t *testing.T) {
 	t.Run("PopFront do something", func(t *testing.T) {
 		deq := NewDeque()
 		deq.PushFront(value)
-		oldFront := deq.front
+		oldFront := front(deq)
 		deq.PopFront()
-		newFront := deq.front
+		newFront := front(deq)
 		assert.NotEqual(t, oldFront, newFront)
 	})
 
 	t.Run("PopFront return correct value", func(t *testing.T) {
 		deq := NewDeque()
 		deq.PushFront(value)
-		val, _ := deq.PopFront()
+		val, ok := deq.PopFront()
+		assert.True(t, ok)
 		assert.Equal(t, value, val)
 	})
 
@@ -339,7 +378,7 @@ func TestPopFront(t *testing.T) {
 		deq.PushFront(value)
 		deq.PushFront(value)
 		deq.PopFront()
-		assert.Nil(t, (*dequeItem)(deq.front).prev)
+		assert.Nil(t, prev((*dequeItem)(front(deq)).prev))
 	})
 }
 
@@ -393,9 +432,55 @@ func TestPushFrontPopFront(t *testing.T) {
 	})
 }
 
+func TestLen(t *testing.T) {
+	const count = 100
+
+	deq := NewDeque()
+	assert.Equal(t, 0, deq.Len())
+	for i := 0; i < count; i++ {
+		deq.PushBack(i)
+		deq.PushFront(i)
+	}
+	assert.Equal(t, 2*count, deq.Len())
+	deq.PopBack()
+	deq.PopFront()
+	assert.Equal(t, 2*count-2, deq.Len())
+}
+
+func TestPeek(t *testing.T) {
+	deq := NewDeque()
+	_, ok := deq.PeekBack()
+	assert.False(t, ok)
+	_, ok = deq.PeekFront()
+	assert.False(t, ok)
+
+	deq.PushBack(2)
+	deq.PushFront(1)
+	deq.PushBack(3)
+	back, ok := deq.PeekBack()
+	assert.True(t, ok)
+	assert.Equal(t, 3, back)
+	front, ok := deq.PeekFront()
+	assert.True(t, ok)
+	assert.Equal(t, 1, front)
+	assert.Equal(t, 3, deq.Len())
+}
+
 func TestPushBackPopFront(t *testing.T) {
 	const count = 100
 
+	t.Run("PushBackPopFront several times with padded nodes", func(t *testing.T) {
+		deq := NewDeque(WithPaddedNodes())
+		for i := 0; i < count; i++ {
+			deq.PushBack(i)
+		}
+		for i := 0; i < count; i++ {
+			val, ok := deq.PopFront()
+			assert.True(t, ok)
+			assert.Equal(t, i, val)
+		}
+	})
+
 	t.Run("PushBackPopFront several times", func(t *testing.T) {
 		deq := NewDeque()
 		for i := 0; i < count; i++ {
@@ -586,3 +671,181 @@ func TestDequeConcurrencyPushBackPopFront(t *testing.T) {
 		assert.False(t, ok) // Queue must be empty
 	})
 }
+
+func TestDequeConformance(t *testing.T) {
+	t.Run("NewDeque", func(t *testing.T) {
+		containertest.TestDoubleEnded(t, func() treiber.DoubleEnded[int] { return NewDeque() }, containertest.Int)
+	})
+
+	t.Run("Zero value", func(t *testing.T) {
+		containertest.TestDoubleEnded(t, func() treiber.DoubleEnded[int] { return &Deque{} }, containertest.Int)
+	})
+}
+
+func TestDequeStats(t *testing.T) {
+	deq := NewDeque()
+	deq.PushBack(1)
+	deq.PushFront(2)
+	deq.PopBack()
+	deq.PopFront()
+	deq.PopFront()
+
+	want := Stats{}
+	if stats.Enabled {
+		want = Stats{Pushes: 2, Pops: 2, EmptyPops: 1}
+	}
+	assert.Equal(t, want, deq.Stats())
+}
+
+func TestDequeConcurrencyBackoff(t *testing.T) {
+	const count = 10_000
+
+	t.Run("PushBack PopFront with backoff", func(t *testing.T) {
+		deq := NewDeque(WithBackoff(backoff.Proportional{}))
+
+		wg := sync.WaitGroup{}
+		wg.Add(count)
+
+		for i := 0; i < count; i++ {
+			go func(value int) {
+				defer wg.Done()
+				deq.PushBack(value)
+				deq.PopFront()
+				deq.PushBack(value)
+			}(i)
+		}
+		wg.Wait()
+
+		cnt := 0
+		for _, ok := deq.PopFront(); ok; _, ok = deq.PopFront() {
+			cnt++
+		}
+
+		assert.Equal(t, cnt, count)
+	})
+}
+
+func BenchmarkDeque(b *testing.B) {
+	b.Run("PushBack", func(b *testing.B) {
+		deq := NewDeque()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			deq.PushBack(i)
+		}
+	})
+
+	b.Run("PushFront", func(b *testing.B) {
+		deq := NewDeque()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			deq.PushFront(i)
+		}
+	})
+
+	b.Run("PopBack", func(b *testing.B) {
+		deq := NewDeque()
+		for i := 0; i < b.N; i++ {
+			deq.PushBack(i)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			deq.PopBack()
+		}
+	})
+
+	b.Run("PopFront", func(b *testing.B) {
+		deq := NewDeque()
+		for i := 0; i < b.N; i++ {
+			deq.PushBack(i)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			deq.PopFront()
+		}
+	})
+
+	b.Run("PushBackPopFront parallel", func(b *testing.B) {
+		deq := NewDeque()
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				deq.PushBack(i)
+				deq.PopFront()
+			}
+		})
+	})
+
+	b.Run("PushFrontPopBack parallel", func(b *testing.B) {
+		deq := NewDeque()
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				deq.PushFront(i)
+				deq.PopBack()
+			}
+		})
+	})
+}
+
+func BenchmarkDequeProducerConsumer(b *testing.B) {
+	layouts := []struct {
+		name string
+		opts []Option
+	}{
+		{"compact", nil},
+		{"padded", []Option{WithPaddedNodes()}},
+	}
+
+	for _, layout := range layouts {
+		for _, pairs := range []int{1, 2, 4, 8} {
+			b.Run(fmt.Sprintf("nodes=%s/pairs=%d", layout.name, pairs), func(b *testing.B) {
+				deq := NewDeque(layout.opts...)
+				containertest.BenchmarkProducerConsumer(b, pairs, deq.PushBack, deq.PopFront, containertest.Int)
+			})
+		}
+	}
+}
+
+func BenchmarkDequeBackoff(b *testing.B) {
+	strategies := []struct {
+		name    string
+		backoff backoff.Backoff
+	}{
+		{"none", nil},
+		{"exponential", backoff.Exponential{}},
+		{"yield", backoff.Yield{}},
+		{"proportional", backoff.Proportional{}},
+	}
+
+	for _, strategy := range strategies {
+		for _, goroutines := range []int{1, 4, 16, 64} {
+			b.Run(fmt.Sprintf("%s/goroutines=%d", strategy.name, goroutines), func(b *testing.B) {
+				deq := NewDeque(WithBackoff(strategy.backoff))
+				b.ReportAllocs()
+				b.ResetTimer()
+				benchmarkContention(b.N, goroutines, func(value int) {
+					deq.PushBack(value)
+					deq.PopFront()
+				})
+			})
+		}
+	}
+}
+
+// benchmarkContention runs op n times spread over the given number of goroutines.
+func benchmarkContention(n, goroutines int, op func(int)) {
+	wg := sync.WaitGroup{}
+	wg.Add(goroutines)
+
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < n; i += goroutines {
+				op(i)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
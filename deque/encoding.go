@@ -0,0 +1,41 @@
+package deque
+
+import "github.com/peletor/treiber/internal/snapshot"
+
+// MarshalBinary encodes Snapshot, front first. gob uses it too, as it uses
+// UnmarshalBinary, which pushes the values so they pop in the same order
+// again. Decode into a new Deque: the values of a non-empty one are kept, and
+// mixed with the decoded ones.
+func (d *Deque) MarshalBinary() ([]byte, error) {
+	return snapshot.MarshalBinary(d.Snapshot()), nil
+}
+
+func (d *Deque) UnmarshalBinary(data []byte) error {
+	values, err := snapshot.UnmarshalBinary(data)
+	if err != nil {
+		return err
+	}
+	d.restore(values)
+	return nil
+}
+
+// MarshalJSON encodes Snapshot as a JSON array, front first.
+func (d *Deque) MarshalJSON() ([]byte, error) {
+	return snapshot.MarshalJSON(d.Snapshot())
+}
+
+func (d *Deque) UnmarshalJSON(data []byte) error {
+	values, err := snapshot.UnmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+	d.restore(values)
+	return nil
+}
+
+// restore pushes values, given front first.
+func (d *Deque) restore(values []int) {
+	for _, value := range values {
+		d.PushBack(value)
+	}
+}
@@ -0,0 +1,131 @@
+package deque
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDequeSnapshot(t *testing.T) {
+	deq := NewDeque()
+	assert.Empty(t, deq.Snapshot())
+	assert.Empty(t, (&Deque{}).Snapshot())
+
+	deq.PushBack(2)
+	deq.PushBack(3)
+	deq.PushFront(1)
+	deq.PushBack(4)
+	deq.PopBack()
+	assert.Equal(t, []int{1, 2, 3}, deq.Snapshot())
+	assert.Equal(t, 3, deq.Len())
+	assert.Equal(t, []int{1, 2}, deq.Preview(2))
+	assert.Equal(t, []int{1, 2, 3}, deq.Preview(10))
+	assert.Empty(t, deq.Preview(0))
+	assert.Empty(t, (&Deque{}).Preview(10))
+}
+
+func TestDequeEncoding(t *testing.T) {
+	newDeque := func() *Deque {
+		deq := NewDeque()
+		for i := 0; i < 10; i++ {
+			if i%2 == 0 {
+				deq.PushBack(i)
+			} else {
+				deq.PushFront(i)
+			}
+		}
+		return deq
+	}
+	popAll := func(deq *Deque) []int {
+		var values []int
+		for value, ok := deq.PopFront(); ok; value, ok = deq.PopFront() {
+			values = append(values, value)
+		}
+		return values
+	}
+	want := popAll(newDeque())
+
+	t.Run("Binary", func(t *testing.T) {
+		data, err := newDeque().MarshalBinary()
+		require.NoError(t, err)
+		restored := NewDeque()
+		require.NoError(t, restored.UnmarshalBinary(data))
+		assert.Equal(t, want, popAll(restored))
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		data, err := json.Marshal(newDeque())
+		require.NoError(t, err)
+		assert.JSONEq(t, "[9,7,5,3,1,0,2,4,6,8]", string(data))
+		restored := NewDeque()
+		require.NoError(t, json.Unmarshal(data, restored))
+		assert.Equal(t, want, popAll(restored))
+	})
+
+	t.Run("gob", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		require.NoError(t, gob.NewEncoder(&buf).Encode(newDeque()))
+		restored := NewDeque()
+		require.NoError(t, gob.NewDecoder(&buf).Decode(restored))
+		assert.Equal(t, want, popAll(restored))
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		data, err := json.Marshal(NewDeque())
+		require.NoError(t, err)
+		assert.Equal(t, "[]", string(data))
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		deq := NewDeque()
+		assert.Error(t, deq.UnmarshalBinary([]byte{1, 5}))
+		assert.Error(t, deq.UnmarshalJSON([]byte(`"x"`)))
+		assert.Zero(t, deq.Len())
+	})
+}
+
+// one producer pushes 1, 2, 3, ... at the back and another -1, -2, -3, ...
+// at the front, while a consumer pops from the front, so every state of
+// the deque is a run of negatives followed by an ascending run
+func TestDequeSnapshotConcurrency(t *testing.T) {
+	const count = 10000
+
+	deq := NewDeque()
+	done := atomic.Int32{}
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+	for _, push := range []func(int){deq.PushBack, func(i int) { deq.PushFront(-i) }} {
+		go func(push func(int)) {
+			defer wg.Done()
+			for i := 1; i <= count; i++ {
+				push(i)
+			}
+			done.Add(1)
+		}(push)
+	}
+	go func() {
+		defer wg.Done()
+		for done.Load() < 2 {
+			deq.PopFront()
+		}
+	}()
+
+	for done.Load() < 2 {
+		values := deq.Snapshot()
+		for i := 1; i < len(values); i++ {
+			prev, value := values[i-1], values[i]
+			ok := prev < 0 && value < 0 && value == prev+1 ||
+				prev < 0 && value > 0 ||
+				prev > 0 && value == prev+1
+			if !assert.True(t, ok, "%v", values) {
+				return
+			}
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,195 @@
+package deque
+
+import (
+	"fmt"
+	"github.com/peletor/treiber/internal/linearize"
+	"slices"
+	"sync"
+	"testing"
+)
+
+const (
+	opPushBack = iota
+	opPushFront
+	opPopBack
+	opPopFront
+	opCount
+)
+
+var opNames = [opCount]string{"PushBack", "PushFront", "PopBack", "PopFront"}
+
+type fuzzOp struct {
+	kind  byte
+	value int
+}
+
+func (op fuzzOp) isPush() bool {
+	return op.kind == opPushBack || op.kind == opPushFront
+}
+
+func (op fuzzOp) String() string {
+	if op.isPush() {
+		return fmt.Sprintf("%s(%d)", opNames[op.kind], op.value)
+	}
+	return opNames[op.kind] + "()"
+}
+
+type result struct {
+	value int
+	ok    bool
+}
+
+// decodeOps reads one operation per byte; a push takes its value from the
+// byte that follows.
+func decodeOps(data []byte) []fuzzOp {
+	var ops []fuzzOp
+	for i := 0; i < len(data); i++ {
+		op := fuzzOp{kind: data[i] % opCount}
+		if op.isPush() && i+1 < len(data) {
+			i++
+			op.value = int(data[i])
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+func encodeOps(ops ...fuzzOp) []byte {
+	var data []byte
+	for _, op := range ops {
+		data = append(data, op.kind)
+		if op.isPush() {
+			data = append(data, byte(op.value))
+		}
+	}
+	return data
+}
+
+// addSeeds adds the scenarios of the push and pop tests to the corpus, with
+// ten items where the tests use more.
+func addSeeds(f *testing.F) {
+	const count = 10
+	var pushBacks, pushFronts, popBacks, popFronts, backPairs, frontPairs, both []fuzzOp
+	for i := 0; i < count; i++ {
+		pushBack, pushFront := fuzzOp{kind: opPushBack, value: i}, fuzzOp{kind: opPushFront, value: i}
+		pushBacks = append(pushBacks, pushBack)
+		pushFronts = append(pushFronts, pushFront)
+		popBacks = append(popBacks, fuzzOp{kind: opPopBack})
+		popFronts = append(popFronts, fuzzOp{kind: opPopFront})
+		backPairs = append(backPairs, pushBack, fuzzOp{kind: opPopBack})
+		frontPairs = append(frontPairs, pushFront, fuzzOp{kind: opPopFront})
+		both = append(both, pushBack, pushFront)
+	}
+
+	f.Add(encodeOps(popBacks[0]))
+	f.Add(encodeOps(popFronts[0]))
+	f.Add(encodeOps(pushBacks[5], popBacks[0]))
+	f.Add(encodeOps(pushFronts[5], popFronts[0]))
+	f.Add(encodeOps(pushBacks[5], pushBacks[5], popBacks[0]))
+	f.Add(encodeOps(pushFronts[5], pushFronts[5], popFronts[0]))
+	f.Add(encodeOps(slices.Concat(pushBacks, popBacks, popBacks[:1])...))
+	f.Add(encodeOps(slices.Concat(backPairs, popBacks[:1])...))
+	f.Add(encodeOps(slices.Concat(pushFronts, popFronts, popFronts[:1])...))
+	f.Add(encodeOps(slices.Concat(frontPairs, popFronts[:1])...))
+	f.Add(encodeOps(slices.Concat(pushFronts, popBacks)...))
+	f.Add(encodeOps(slices.Concat(pushBacks, popFronts)...))
+	f.Add(encodeOps(slices.Concat(both, popBacks[:1], popFronts[:1])...))
+}
+
+// model is the sequential reference: a slice with the front at index 0.
+type model []int
+
+func (m model) apply(op fuzzOp) (model, result) {
+	switch op.kind {
+	case opPushBack:
+		return append(slices.Clip(m), op.value), result{}
+	case opPushFront:
+		return append(model{op.value}, m...), result{}
+	}
+	if len(m) == 0 {
+		return m, result{}
+	}
+	if op.kind == opPopBack {
+		return m[:len(m)-1], result{m[len(m)-1], true}
+	}
+	return m[1:], result{m[0], true}
+}
+
+func apply(deq *Deque, op fuzzOp) result {
+	var value int
+	var ok bool
+	switch op.kind {
+	case opPushBack:
+		deq.PushBack(op.value)
+	case opPushFront:
+		deq.PushFront(op.value)
+	case opPopBack:
+		value, ok = deq.PopBack()
+	default:
+		value, ok = deq.PopFront()
+	}
+	return result{value, ok}
+}
+
+func FuzzDeque(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		deq := NewDeque()
+		var m model
+		for i, op := range decodeOps(data) {
+			var want result
+			m, want = m.apply(op)
+			if got := apply(deq, op); got != want {
+				t.Fatalf("op %d %v: got %v, model %v", i, op, got, want)
+			}
+			if deq.Len() != len(m) {
+				t.Fatalf("op %d %v: Len %d, model %d", i, op, deq.Len(), len(m))
+			}
+		}
+	})
+}
+
+var dequeModel = linearize.Model[model, fuzzOp, result]{
+	Init: func() model { return nil },
+	Step: func(m model, op fuzzOp, got result) (bool, model) {
+		next, want := m.apply(op)
+		return got == want, next
+	},
+	Key: func(m model) string { return fmt.Sprint([]int(m)) },
+}
+
+// maxConcurrentOps keeps the linearizability check, which is exponential in
+// the worst case, fast.
+const maxConcurrentOps = 64
+
+// FuzzDequeConcurrent deals the operations round robin to 2 to 4 goroutines,
+// depending on the length of the input.
+func FuzzDequeConcurrent(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		goroutines := 2 + len(data)%3
+		ops := decodeOps(data)
+		ops = ops[:min(len(ops), maxConcurrentOps)]
+
+		deq := NewDeque()
+		clock := linearize.Clock{}
+		history := make([]linearize.Operation[fuzzOp, result], len(ops))
+		wg := sync.WaitGroup{}
+		wg.Add(goroutines)
+		for g := 0; g < goroutines; g++ {
+			go func(g int) {
+				defer wg.Done()
+				for i := g; i < len(ops); i += goroutines {
+					call := clock.Now()
+					got := apply(deq, ops[i])
+					history[i] = linearize.Operation[fuzzOp, result]{Input: ops[i], Output: got, Call: call, Return: clock.Now()}
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		if !linearize.Check(dequeModel, history) {
+			t.Fatalf("history is not linearizable: %v", history)
+		}
+	})
+}
@@ -0,0 +1,121 @@
+//go:build treibersched
+
+package deque
+
+import (
+	"fmt"
+	"github.com/peletor/treiber/internal/linearize"
+	"github.com/peletor/treiber/internal/sched"
+	"github.com/stretchr/testify/assert"
+	"slices"
+	"testing"
+)
+
+// scenario starts from a deque holding initial, front to back, and runs one
+// goroutine per push and per pop.
+type scenario struct {
+	initial   []int
+	pushBack  []int
+	pushFront []int
+	popBack   int
+	popFront  int
+}
+
+// test checks that the history of the goroutines is linearizable, that the
+// popped values and the ones left over are exactly the pushed ones, and that
+// the links between the items left agree in both directions.
+func (s scenario) test() ([]func(), func() error) {
+	deq := NewDeque()
+	var ops []fuzzOp
+	for _, value := range s.initial {
+		deq.PushBack(value)
+		// the initial pushes precede every goroutine
+		ops = append(ops, fuzzOp{kind: opPushBack, value: value})
+	}
+	initial := len(ops)
+	for _, value := range s.pushBack {
+		ops = append(ops, fuzzOp{kind: opPushBack, value: value})
+	}
+	for _, value := range s.pushFront {
+		ops = append(ops, fuzzOp{kind: opPushFront, value: value})
+	}
+	for i := 0; i < s.popBack; i++ {
+		ops = append(ops, fuzzOp{kind: opPopBack})
+	}
+	for i := 0; i < s.popFront; i++ {
+		ops = append(ops, fuzzOp{kind: opPopFront})
+	}
+
+	clock := linearize.Clock{}
+	history := make([]linearize.Operation[fuzzOp, result], len(ops))
+	for i, op := range ops[:initial] {
+		history[i] = linearize.Operation[fuzzOp, result]{Input: op, Call: clock.Now(), Return: clock.Now()}
+	}
+	var goroutines []func()
+	for i := initial; i < len(ops); i++ {
+		goroutines = append(goroutines, func() {
+			call := clock.Now()
+			got := apply(deq, ops[i])
+			history[i] = linearize.Operation[fuzzOp, result]{Input: ops[i], Output: got, Call: call, Return: clock.Now()}
+		})
+	}
+
+	return goroutines, func() error {
+		if !linearize.Check(dequeModel, history) {
+			return fmt.Errorf("history is not linearizable: %v", history)
+		}
+
+		var left, reversed []int
+		for item := next(deq.first()); item != nil; item = next((*dequeItem)(item).next) {
+			left = append(left, (*dequeItem)(item).value)
+		}
+		for item := prev(deq.last()); item != nil; item = prev((*dequeItem)(item).prev) {
+			reversed = append(reversed, (*dequeItem)(item).value)
+		}
+		slices.Reverse(reversed)
+		if !slices.Equal(left, reversed) {
+			return fmt.Errorf("next links give %v, prev links %v", left, reversed)
+		}
+		if deq.Len() != len(left) {
+			return fmt.Errorf("Len is %d with %d items left", deq.Len(), len(left))
+		}
+
+		pushed := slices.Sorted(slices.Values(slices.Concat(s.initial, s.pushBack, s.pushFront)))
+		var popped []int
+		for _, op := range history[initial:] {
+			if op.Output.ok {
+				popped = append(popped, op.Output.value)
+			}
+		}
+		got := slices.Sorted(slices.Values(slices.Concat(popped, left)))
+		if !slices.Equal(pushed, got) {
+			return fmt.Errorf("pushed %v, popped %v and left %v", pushed, popped, left)
+		}
+		return nil
+	}
+}
+
+func TestDequeSchedules(t *testing.T) {
+	scenarios := map[string]scenario{
+		"PushBack PopFront on one item":   {initial: []int{1}, pushBack: []int{2}, popFront: 1},
+		"PushFront PopBack on one item":   {initial: []int{1}, pushFront: []int{2}, popBack: 1},
+		"PopBack PopFront on two items":   {initial: []int{1, 2}, popBack: 1, popFront: 1},
+		"PopBack PopFront on one item":    {initial: []int{1}, popBack: 1, popFront: 1},
+		"PushBack PushFront on empty":     {pushBack: []int{1}, pushFront: []int{2}},
+		"Pushes and pops at both ends":    {initial: []int{1, 2}, pushBack: []int{3}, pushFront: []int{4}, popBack: 1, popFront: 1},
+		"Two pushes at the back and pop":  {initial: []int{1}, pushBack: []int{2, 3}, popFront: 1},
+		"PushFront and pops at both ends": {initial: []int{1}, pushFront: []int{2}, popBack: 1, popFront: 1},
+		"PushBack and pops at both ends":  {initial: []int{1}, pushBack: []int{2}, popBack: 1, popFront: 1},
+	}
+
+	for name, s := range scenarios {
+		t.Run(name, func(t *testing.T) {
+			failure, runs, _ := sched.Enumerate(20_000, s.test)
+			assert.Nil(t, failure)
+			assert.Positive(t, runs)
+
+			failure = sched.Explore(1_000, func(seed uint64) sched.Strategy { return sched.PCT(seed, 3, 60) }, s.test)
+			assert.Nil(t, failure)
+		})
+	}
+}
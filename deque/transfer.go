@@ -0,0 +1,78 @@
+package deque
+
+import (
+	"unsafe"
+
+	"github.com/peletor/treiber/internal/kcas"
+	"github.com/peletor/treiber/internal/sched"
+)
+
+// The Take and Put entries of both ends implement transfer.Source and
+// transfer.Sink through transfer.Back and transfer.Front; use
+// transfer.Transfer rather than calling them.
+//
+// TakeBackEntry returns the KCAS entry that claims the back item, and done
+// to call once the KCAS succeeded. ok is false if the deque is empty.
+func (d *Deque) TakeBackEntry() (e kcas.Entry, value int, ok bool, done func()) {
+	for attempt := 0; ; attempt++ {
+		switch item, current := d.findBack(); {
+		case !current:
+			// an item was pushed at the back meanwhile
+		case item == nil:
+			// Deque is empty
+			d.stats.EmptyPop()
+			return kcas.Entry{}, 0, false, nil
+		default:
+			return d.take(item)
+		}
+		d.retry(nil, attempt)
+	}
+}
+
+// TakeFrontEntry is TakeBackEntry for the front end.
+func (d *Deque) TakeFrontEntry() (e kcas.Entry, value int, ok bool, done func()) {
+	for attempt := 0; ; attempt++ {
+		switch item, current := d.findFront(); {
+		case !current:
+			// an item was pushed at the front meanwhile
+		case item == nil:
+			// Deque is empty
+			d.stats.EmptyPop()
+			return kcas.Entry{}, 0, false, nil
+		default:
+			return d.take(item)
+		}
+		d.retry(nil, attempt)
+	}
+}
+
+// take returns the entry that claims item, like PopBack and PopFront do.
+func (d *Deque) take(item unsafe.Pointer) (e kcas.Entry, value int, ok bool, done func()) {
+	return kcas.Entry{Addr: &(*dequeItem)(item).claim, New: claimed}, (*dequeItem)(item).value, true, func() {
+		unlink(item)
+		d.stats.Pop()
+	}
+}
+
+// PutBackEntry returns the KCAS entry that links an item with value after
+// the last one; done moves the back hint to it.
+func (d *Deque) PutBackEntry(value int) (e kcas.Entry, done func()) {
+	newItem := d.newItem(value)
+	last := d.last()
+	(*dequeItem)(newItem).prev = last
+	return kcas.Entry{Addr: &(*dequeItem)(last).next, New: newItem}, func() {
+		sched.CompareAndSwapPointer(&d.back, last, newItem)
+		d.stats.Push()
+	}
+}
+
+// PutFrontEntry is PutBackEntry for the front end.
+func (d *Deque) PutFrontEntry(value int) (e kcas.Entry, done func()) {
+	newItem := d.newItem(value)
+	first := d.first()
+	(*dequeItem)(newItem).next = first
+	return kcas.Entry{Addr: &(*dequeItem)(first).prev, New: newItem}, func() {
+		sched.CompareAndSwapPointer(&d.front, first, newItem)
+		d.stats.Push()
+	}
+}
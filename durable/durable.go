@@ -0,0 +1,364 @@
+// Package durable is a queue.Queue that survives restarts.
+//
+// Every pushed value is appended to a log of segment files before it is
+// linked into an in-memory queue.Queue, which all pops are served from. The
+// number of popped values, the consumer offset, is checkpointed every few
+// pops, and segments whose records were all popped are deleted.
+//
+// Delivery is at least once: a crash hands the values popped since the last
+// checkpoint out again after the restart.
+//
+// The log is not a way to hold more values than fit in memory: every value
+// pushed and not popped stays in the in-memory queue as well, one item of a
+// queue.Queue each, and Open reads them all back in. Keep the backlog
+// bounded by the consumers.
+package durable
+
+import (
+	"errors"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/peletor/treiber/internal/nocopy"
+	"github.com/peletor/treiber/queue"
+)
+
+const (
+	// DefaultSegmentSize is the size of a full segment, in bytes.
+	DefaultSegmentSize = 64 << 20
+	// DefaultCheckpointEvery is the number of pops between two writes of
+	// the consumer offset.
+	DefaultCheckpointEvery = 64
+)
+
+var ErrClosed = errors.New("durable: queue is closed")
+
+// Queue must not be copied; use the pointer returned by Open.
+type Queue struct {
+	_ nocopy.NoCopy
+
+	dir             string
+	segmentSize     int64
+	syncEvery       int
+	checkpointEvery int
+
+	memory *queue.Queue
+
+	// mu guards the segment files: appends, rolls and deletes.
+	mu       sync.Mutex
+	segments []*segment
+	active   *os.File
+	unsynced int
+	frame    []byte
+	// deletableAt is the offset that consumes the oldest segment that is
+	// not the active one. Checkpoints below it leave mu alone.
+	deletableAt atomic.Uint64
+
+	closed atomic.Bool
+
+	// popped counts the values popped from memory. Pops leave memory in log
+	// order, so it never overtakes the records that really left.
+	popped atomic.Uint64
+	// offsetMu serializes the writes of the offsets file; committed is the
+	// offset written last.
+	offsetMu    sync.Mutex
+	committed   atomic.Uint64
+	offsetWrite int
+}
+
+// Option configures a Queue opened by Open.
+type Option func(*Queue)
+
+// WithSegmentSize sets the size of a full segment. It is rounded down to a
+// whole number of records.
+func WithSegmentSize(bytes int64) Option {
+	return func(q *Queue) {
+		q.segmentSize = max(bytes/frameSize, 1) * frameSize
+	}
+}
+
+// WithSyncEvery flushes the active segment and the offsets file to stable
+// storage every n writes; 1, the default, syncs every push and checkpoint.
+// With 0 only Sync and Close flush, and a machine crash may lose recent
+// writes, though a crash of the process alone does not.
+func WithSyncEvery(n int) Option {
+	return func(q *Queue) {
+		q.syncEvery = n
+	}
+}
+
+// WithCheckpointEvery writes the consumer offset once every n pops, by
+// default DefaultCheckpointEvery. A crash delivers up to n values again,
+// and a few more if pops raced the last checkpoint; 1 checkpoints after
+// every pop. Values less than 1 are treated as 1.
+func WithCheckpointEvery(n int) Option {
+	return func(q *Queue) {
+		q.checkpointEvery = max(n, 1)
+	}
+}
+
+// Open recovers the queue stored in dir, creating dir if needed. The last
+// segment is truncated after its last intact record; a damaged record in
+// any other segment fails with ErrCorrupt.
+func Open(dir string, opts ...Option) (*Queue, error) {
+	q := &Queue{
+		dir:             dir,
+		segmentSize:     DefaultSegmentSize,
+		syncEvery:       1,
+		checkpointEvery: DefaultCheckpointEvery,
+		memory:          queue.NewQueue(),
+		frame:           make([]byte, frameSize),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	offset, err := readOffset(dir)
+	if err != nil {
+		return nil, err
+	}
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, seg := range segments {
+		err := seg.scan(i == len(segments)-1, func(index uint64, value int) {
+			if index >= offset {
+				q.memory.Push(value)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	q.segments = segments
+	q.committed.Store(offset)
+	q.popped.Store(offset)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.deleteConsumed(offset); err != nil {
+		return nil, err
+	}
+	// a log that ends before the offset, as after a crash that lost
+	// unsynced appends, gets a new segment at the offset on the next push
+	if last := q.lastSegment(); last != nil && last.base+last.count >= offset {
+		if q.active, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// Push appends value to the log, then to the in-memory queue. Appends are
+// serialized; the in-memory queue is what keeps pops lock-free.
+func (q *Queue) Push(value int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed.Load() {
+		return ErrClosed
+	}
+
+	if err := q.roll(); err != nil {
+		return err
+	}
+	last := q.lastSegment()
+	encodeFrame(q.frame, value)
+	if _, err := q.active.Write(q.frame); err != nil {
+		// cut a partial frame, or recovery would drop every later record
+		return errors.Join(err, q.active.Truncate(int64(last.count)*frameSize))
+	}
+	last.count++
+	if err := q.maybeSync(); err != nil {
+		return err
+	}
+
+	// linked under mu, so memory holds the values in log order
+	q.memory.Push(value)
+	return nil
+}
+
+// roll starts a new segment when there is no active one or it is full.
+// The new segment starts at the end of the log, or at the consumer offset
+// if the log ends before it, so that no index below the offset is reused.
+func (q *Queue) roll() error {
+	last := q.lastSegment()
+	if q.active != nil {
+		if int64(last.count+1)*frameSize <= q.segmentSize {
+			return nil
+		}
+		if err := q.active.Sync(); err != nil {
+			return err
+		}
+		if err := q.active.Close(); err != nil {
+			return err
+		}
+		q.active = nil
+	}
+
+	base := q.committed.Load()
+	if last != nil {
+		base = max(base, last.base+last.count)
+	}
+	seg := &segment{base: base, path: segmentPath(q.dir, base)}
+	file, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	q.active = file
+	q.segments = append(q.segments, seg)
+	q.updateDeletable()
+	return nil
+}
+
+// lastSegment returns the newest segment, or nil if there is none.
+func (q *Queue) lastSegment() *segment {
+	if len(q.segments) == 0 {
+		return nil
+	}
+	return q.segments[len(q.segments)-1]
+}
+
+func (q *Queue) maybeSync() error {
+	if q.syncEvery <= 0 {
+		return nil
+	}
+	q.unsynced++
+	if q.unsynced < q.syncEvery {
+		return nil
+	}
+	q.unsynced = 0
+	return q.active.Sync()
+}
+
+// Pop removes the oldest value from the in-memory queue, and checkpoints
+// the consumer offset if it is due. If that fails, the value is still
+// returned together with the error, and will be delivered again after a
+// restart.
+func (q *Queue) Pop() (value int, ok bool, err error) {
+	if q.closed.Load() {
+		return 0, false, ErrClosed
+	}
+	value, ok = q.memory.Pop()
+	if !ok {
+		return 0, false, nil
+	}
+	if q.popped.Add(1)-q.committed.Load() < uint64(q.checkpointEvery) {
+		return value, true, nil
+	}
+	return value, true, q.checkpoint()
+}
+
+// checkpoint persists the consumer offset and deletes the segments it
+// consumed completely. Pops do not wait for each other: if a checkpoint is
+// being written, the pops it misses are left to the next one.
+func (q *Queue) checkpoint() error {
+	if !q.offsetMu.TryLock() {
+		return nil
+	}
+	defer q.offsetMu.Unlock()
+	offset := q.popped.Load()
+	if offset <= q.committed.Load() {
+		return nil
+	}
+
+	flush := false
+	if q.syncEvery > 0 {
+		q.offsetWrite++
+		flush = q.offsetWrite >= q.syncEvery
+		if flush {
+			q.offsetWrite = 0
+		}
+	}
+	if err := writeOffset(q.dir, offset, flush); err != nil {
+		return err
+	}
+	q.committed.Store(offset)
+
+	if offset < q.deletableAt.Load() {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.deleteConsumed(offset)
+}
+
+// deleteConsumed removes the segments below offset, except the active one.
+func (q *Queue) deleteConsumed(offset uint64) error {
+	defer q.updateDeletable()
+	for len(q.segments) > 1 && q.segments[0].base+q.segments[0].count <= offset {
+		if err := os.Remove(q.segments[0].path); err != nil {
+			return err
+		}
+		q.segments = q.segments[1:]
+	}
+	return nil
+}
+
+// updateDeletable sets deletableAt after the segments changed, under mu.
+func (q *Queue) updateDeletable() {
+	if len(q.segments) < 2 {
+		q.deletableAt.Store(math.MaxUint64)
+		return
+	}
+	q.deletableAt.Store(q.segments[0].base + q.segments[0].count)
+}
+
+// Len returns the number of values pushed and not popped, as Queue.Len.
+func (q *Queue) Len() int {
+	return q.memory.Len()
+}
+
+// Sync flushes the active segment and the consumer offset to stable storage.
+func (q *Queue) Sync() error {
+	if err := q.syncOffset(); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed.Load() {
+		return ErrClosed
+	}
+	q.unsynced = 0
+	if q.active == nil {
+		return nil
+	}
+	return q.active.Sync()
+}
+
+func (q *Queue) syncOffset() error {
+	q.offsetMu.Lock()
+	defer q.offsetMu.Unlock()
+	offset := max(q.committed.Load(), q.popped.Load())
+	if offset == 0 {
+		return nil
+	}
+	if err := writeOffset(q.dir, offset, true); err != nil {
+		return err
+	}
+	q.committed.Store(offset)
+	return nil
+}
+
+// Close syncs and closes the log. Values left in the queue are recovered
+// by the next Open.
+func (q *Queue) Close() error {
+	if q.closed.Swap(true) {
+		return ErrClosed
+	}
+	err := q.syncOffset()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.active != nil {
+		err = errors.Join(err, q.active.Sync(), q.active.Close())
+	}
+	return err
+}
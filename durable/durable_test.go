@@ -0,0 +1,328 @@
+package durable
+
+import (
+	"github.com/peletor/treiber"
+	"github.com/peletor/treiber/containertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func pushAll(t *testing.T, q *Queue, values ...int) {
+	t.Helper()
+	for _, value := range values {
+		require.NoError(t, q.Push(value))
+	}
+}
+
+func popAll(t *testing.T, q *Queue) []int {
+	t.Helper()
+	var values []int
+	for {
+		value, ok, err := q.Pop()
+		require.NoError(t, err)
+		if !ok {
+			return values
+		}
+		values = append(values, value)
+	}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	require.NoError(t, err)
+	return files
+}
+
+// container fails the test on the errors of Push and Pop.
+type container struct {
+	*Queue
+	t *testing.T
+}
+
+func (q container) Push(value int) {
+	if err := q.Queue.Push(value); err != nil {
+		q.t.Errorf("Push(%d): %v", value, err)
+	}
+}
+
+func (q container) Pop() (int, bool) {
+	value, ok, err := q.Queue.Pop()
+	if err != nil {
+		q.t.Errorf("Pop: %v", err)
+	}
+	return value, ok
+}
+
+func TestConformance(t *testing.T) {
+	containertest.TestContainer(t, containertest.FIFO, func() treiber.Container[int] {
+		q, err := Open(t.TempDir(), WithSegmentSize(1<<10))
+		require.NoError(t, err)
+		t.Cleanup(func() { assert.NoError(t, q.Close()) })
+		return container{q, t}
+	}, containertest.Int)
+}
+
+func TestQueue(t *testing.T) {
+	t.Run("Push-Pop", func(t *testing.T) {
+		q, err := Open(t.TempDir())
+		require.NoError(t, err)
+		defer q.Close()
+
+		pushAll(t, q, 1, 2, 3)
+		assert.Equal(t, 3, q.Len())
+		assert.Equal(t, []int{1, 2, 3}, popAll(t, q))
+	})
+
+	t.Run("Empty Pop", func(t *testing.T) {
+		q, err := Open(t.TempDir())
+		require.NoError(t, err)
+		defer q.Close()
+
+		value, ok, err := q.Pop()
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Zero(t, value)
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		q, err := Open(t.TempDir())
+		require.NoError(t, err)
+		require.NoError(t, q.Close())
+
+		assert.ErrorIs(t, q.Push(1), ErrClosed)
+		_, _, err = q.Pop()
+		assert.ErrorIs(t, err, ErrClosed)
+		assert.ErrorIs(t, q.Close(), ErrClosed)
+	})
+}
+
+func TestRecovery(t *testing.T) {
+	t.Run("Reopen keeps the values not popped", func(t *testing.T) {
+		dir := t.TempDir()
+		q, err := Open(dir)
+		require.NoError(t, err)
+		pushAll(t, q, 1, 2, 3, 4)
+		_, _, err = q.Pop()
+		require.NoError(t, err)
+		require.NoError(t, q.Close())
+
+		q, err = Open(dir)
+		require.NoError(t, err)
+		defer q.Close()
+		pushAll(t, q, 5)
+		assert.Equal(t, []int{2, 3, 4, 5}, popAll(t, q))
+	})
+
+	t.Run("Crash without Close", func(t *testing.T) {
+		dir := t.TempDir()
+		q, err := Open(dir, WithCheckpointEvery(1))
+		require.NoError(t, err)
+		pushAll(t, q, 1, 2, 3)
+		_, _, err = q.Pop()
+		require.NoError(t, err)
+		// q is abandoned, as by a killed process
+
+		q, err = Open(dir)
+		require.NoError(t, err)
+		defer q.Close()
+		assert.Equal(t, []int{2, 3}, popAll(t, q))
+	})
+
+	t.Run("Crash redelivers the pops since the checkpoint", func(t *testing.T) {
+		dir := t.TempDir()
+		q, err := Open(dir, WithCheckpointEvery(3))
+		require.NoError(t, err)
+		pushAll(t, q, 1, 2, 3, 4, 5)
+		for i := 0; i < 4; i++ {
+			_, _, err = q.Pop()
+			require.NoError(t, err)
+		}
+		// q is abandoned after the checkpoint of the third pop
+
+		q, err = Open(dir)
+		require.NoError(t, err)
+		defer q.Close()
+		assert.Equal(t, []int{4, 5}, popAll(t, q))
+	})
+
+	t.Run("Crash with the offset beyond the end of the log", func(t *testing.T) {
+		losses := map[string]func(t *testing.T, files []string){
+			"Unsynced appends lost": func(t *testing.T, files []string) {
+				require.NoError(t, os.Truncate(files[len(files)-1], frameSize))
+			},
+			"Segments deleted": func(t *testing.T, files []string) {
+				for _, file := range files {
+					require.NoError(t, os.Remove(file))
+				}
+			},
+		}
+		for name, lose := range losses {
+			t.Run(name, func(t *testing.T) {
+				dir := t.TempDir()
+				q, err := Open(dir, WithCheckpointEvery(1))
+				require.NoError(t, err)
+				pushAll(t, q, 1, 2, 3)
+				assert.Equal(t, []int{1, 2, 3}, popAll(t, q))
+				require.NoError(t, q.Close())
+				lose(t, segmentFiles(t, dir))
+
+				q, err = Open(dir)
+				require.NoError(t, err)
+				pushAll(t, q, 4, 5)
+				// q is abandoned, as by a killed process
+
+				q, err = Open(dir)
+				require.NoError(t, err)
+				defer q.Close()
+				assert.Equal(t, []int{4, 5}, popAll(t, q))
+			})
+		}
+	})
+
+	t.Run("Torn write at the end", func(t *testing.T) {
+		dir := t.TempDir()
+		q, err := Open(dir)
+		require.NoError(t, err)
+		pushAll(t, q, 1, 2)
+		require.NoError(t, q.Close())
+
+		files := segmentFiles(t, dir)
+		require.Len(t, files, 1)
+		f, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		_, err = f.Write([]byte{0, 0, 0, 8, 1, 2})
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		q, err = Open(dir)
+		require.NoError(t, err)
+		pushAll(t, q, 3)
+		require.NoError(t, q.Close())
+
+		q, err = Open(dir)
+		require.NoError(t, err)
+		defer q.Close()
+		assert.Equal(t, []int{1, 2, 3}, popAll(t, q))
+	})
+
+	t.Run("Corrupt last record", func(t *testing.T) {
+		dir := t.TempDir()
+		q, err := Open(dir)
+		require.NoError(t, err)
+		pushAll(t, q, 1, 2)
+		require.NoError(t, q.Close())
+
+		flipByte(t, segmentFiles(t, dir)[0], frameSize+10)
+
+		q, err = Open(dir)
+		require.NoError(t, err)
+		defer q.Close()
+		assert.Equal(t, []int{1}, popAll(t, q))
+	})
+
+	t.Run("Corrupt record in an older segment", func(t *testing.T) {
+		dir := t.TempDir()
+		q, err := Open(dir, WithSegmentSize(2*frameSize))
+		require.NoError(t, err)
+		pushAll(t, q, 1, 2, 3)
+		require.NoError(t, q.Close())
+
+		flipByte(t, segmentFiles(t, dir)[0], 10)
+
+		_, err = Open(dir)
+		assert.ErrorIs(t, err, ErrCorrupt)
+	})
+
+	t.Run("Corrupt offsets file", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, offsetsFile), []byte("garbage"), 0o644))
+
+		_, err := Open(dir)
+		assert.ErrorIs(t, err, ErrCorrupt)
+	})
+}
+
+func flipByte(t *testing.T, path string, at int64) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[at] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+}
+
+func TestSegments(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(dir, WithSegmentSize(3*frameSize), WithSyncEvery(0), WithCheckpointEvery(1))
+	require.NoError(t, err)
+	defer q.Close()
+
+	pushAll(t, q, 1, 2, 3, 4, 5, 6, 7)
+	assert.Len(t, segmentFiles(t, dir), 3)
+
+	for i := 0; i < 4; i++ {
+		_, _, err := q.Pop()
+		require.NoError(t, err)
+	}
+	// the first segment is consumed completely
+	assert.Len(t, segmentFiles(t, dir), 2)
+
+	assert.Equal(t, []int{5, 6, 7}, popAll(t, q))
+	// the active segment stays
+	assert.Len(t, segmentFiles(t, dir), 1)
+
+	pushAll(t, q, 8)
+	require.NoError(t, q.Close())
+	q, err = Open(dir, WithSegmentSize(3*frameSize))
+	require.NoError(t, err)
+	assert.Equal(t, []int{8}, popAll(t, q))
+}
+
+func TestConcurrency(t *testing.T) {
+	const (
+		goroutines = 8
+		count      = 200
+	)
+
+	dir := t.TempDir()
+	q, err := Open(dir, WithSegmentSize(64*frameSize), WithSyncEvery(0))
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	popped := map[int]int{}
+	wg := sync.WaitGroup{}
+	wg.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				assert.NoError(t, q.Push(g*count+i))
+				if value, ok, err := q.Pop(); ok {
+					assert.NoError(t, err)
+					mu.Lock()
+					popped[value]++
+					mu.Unlock()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	require.NoError(t, q.Close())
+
+	// whatever was not popped before Close comes back after Open
+	q, err = Open(dir, WithSegmentSize(64*frameSize))
+	require.NoError(t, err)
+	defer q.Close()
+	for _, value := range popAll(t, q) {
+		popped[value]++
+	}
+
+	assert.Len(t, popped, goroutines*count)
+	for value, n := range popped {
+		assert.Equal(t, 1, n, "value %d", value)
+	}
+}
@@ -0,0 +1,168 @@
+package durable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A frame is one record on disk:
+//
+//	length  uint32  length of the payload, always 8
+//	crc     uint32  CRC-32C of length and payload
+//	payload int64   the value, big endian
+const (
+	payloadSize = 8
+	frameSize   = 8 + payloadSize
+)
+
+const (
+	segmentExt  = ".seg"
+	offsetsFile = "consumer.offset"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var ErrCorrupt = errors.New("durable: corrupt data")
+
+func encodeFrame(frame []byte, value int) {
+	binary.BigEndian.PutUint32(frame[0:], payloadSize)
+	binary.BigEndian.PutUint64(frame[8:], uint64(value))
+	binary.BigEndian.PutUint32(frame[4:], frameCRC(frame))
+}
+
+// decodeFrame returns the value of frame, or false if it is torn or corrupt.
+func decodeFrame(frame []byte) (int, bool) {
+	if binary.BigEndian.Uint32(frame[0:]) != payloadSize || binary.BigEndian.Uint32(frame[4:]) != frameCRC(frame) {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint64(frame[8:])), true
+}
+
+func frameCRC(frame []byte) uint32 {
+	crc := crc32.Update(0, crcTable, frame[0:4])
+	return crc32.Update(crc, crcTable, frame[8:frameSize])
+}
+
+// segment is a file of frames. base is the index of its first record in the
+// whole log.
+type segment struct {
+	base  uint64
+	count uint64
+	path  string
+}
+
+func segmentPath(dir string, base uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, segmentExt))
+}
+
+// listSegments returns the segments of dir ordered by base.
+func listSegments(dir string) ([]*segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []*segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, &segment{base: base, path: filepath.Join(dir, name)})
+	}
+	slices.SortFunc(segments, func(a, b *segment) int {
+		switch {
+		case a.base < b.base:
+			return -1
+		case a.base > b.base:
+			return 1
+		}
+		return 0
+	})
+	return segments, nil
+}
+
+// scan calls f for every record of the segment and sets its count. A torn
+// or corrupt frame ends the scan; it is an error unless last is set, in
+// which case the file is truncated to the frames before it, as a crash
+// during a write leaves the last segment.
+func (s *segment) scan(last bool, f func(index uint64, value int)) error {
+	file, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	frame := make([]byte, frameSize)
+	s.count = 0
+	for {
+		_, err := io.ReadFull(file, frame)
+		if err == io.EOF {
+			return nil
+		}
+		value, ok := decodeFrame(frame)
+		if err != nil || !ok {
+			if !last {
+				return fmt.Errorf("%w: %s, record %d", ErrCorrupt, s.path, s.count)
+			}
+			return file.Truncate(int64(s.count) * frameSize)
+		}
+		f(s.base+s.count, value)
+		s.count++
+	}
+}
+
+// readOffset returns the persisted consumer offset of dir, zero if none was
+// written yet.
+func readOffset(dir string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, offsetsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 12 || binary.BigEndian.Uint32(data[8:]) != crc32.Checksum(data[:8], crcTable) {
+		return 0, fmt.Errorf("%w: %s", ErrCorrupt, offsetsFile)
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// writeOffset replaces the offsets file through a rename, so a crash leaves
+// either the old or the new offset.
+func writeOffset(dir string, offset uint64, flush bool) error {
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint64(data, offset)
+	binary.BigEndian.PutUint32(data[8:], crc32.Checksum(data[:8], crcTable))
+
+	tmp := filepath.Join(dir, offsetsFile+".tmp")
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if flush {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, offsetsFile))
+}
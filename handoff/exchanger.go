@@ -0,0 +1,78 @@
+package handoff
+
+import (
+	"context"
+	"unsafe"
+
+	"github.com/peletor/treiber/internal/nocopy"
+	"github.com/peletor/treiber/internal/sched"
+)
+
+// cancelled is the match of an offer whose Exchange gave up.
+var cancelled = unsafe.Pointer(new(int))
+
+// offer is the value of a goroutine waiting in Exchange.
+type offer[T any] struct {
+	value T
+	// match is nil while the offer waits, then the offer it was swapped
+	// with, or cancelled. ready is closed once it is matched.
+	match unsafe.Pointer
+	ready chan struct{}
+}
+
+// Exchanger pairs goroutines that swap values: each Exchange waits for
+// another one and returns its value. A single slot holds the waiting
+// offer, so under heavy contention goroutines mostly retry against each
+// other; it suits pairs of goroutines, such as a producer and a consumer
+// that swap a full buffer for an empty one.
+//
+// The zero Exchanger is ready to use; it must not be copied after first
+// use.
+type Exchanger[T any] struct {
+	_    nocopy.NoCopy
+	slot unsafe.Pointer
+}
+
+// Exchange waits for another Exchange, gives it value and returns its
+// value. It returns ctx.Err() if ctx is done first, and then no value was
+// given away.
+func (e *Exchanger[T]) Exchange(ctx context.Context, value T) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	var mine *offer[T]
+
+	for {
+		slot := sched.LoadPointer(&e.slot)
+		if slot != nil {
+			// take the waiting offer out of the slot, then match it, unless
+			// it was cancelled meanwhile
+			other := (*offer[T])(slot)
+			if sched.CompareAndSwapPointer(&e.slot, slot, nil) &&
+				sched.CompareAndSwapPointer(&other.match, nil, unsafe.Pointer(&offer[T]{value: value})) {
+				close(other.ready)
+				return other.value, nil
+			}
+			continue
+		}
+
+		if mine == nil {
+			mine = &offer[T]{value: value, ready: make(chan struct{})}
+		}
+		if !sched.CompareAndSwapPointer(&e.slot, nil, unsafe.Pointer(mine)) {
+			continue
+		}
+		select {
+		case <-mine.ready:
+		case <-ctx.Done():
+			if sched.CompareAndSwapPointer(&mine.match, nil, cancelled) {
+				// leave the slot, unless someone took it out already
+				sched.CompareAndSwapPointer(&e.slot, unsafe.Pointer(mine), nil)
+				return zero, ctx.Err()
+			}
+			// matched meanwhile, the exchange happened
+		}
+		return (*offer[T])(sched.LoadPointer(&mine.match)).value, nil
+	}
+}
@@ -0,0 +1,37 @@
+// Package handoff passes values directly from one goroutine to another,
+// without a buffer in between.
+//
+// A SynchronousQueue has no capacity: a Put waits for a Take and the other
+// way round, like an unbuffered channel that can be fair or unfair and
+// whose waiters can give up with a context. An Exchanger pairs goroutines
+// that swap values.
+//
+// A SynchronousQueue keeps its waiting goroutines in the dual containers of
+// Scherer and Scott, queue.TransferQueue and stack.TransferStack, whose
+// producers wait as well as their consumers, as in the SynchronousQueue of
+// Java.
+package handoff
+
+import (
+	"context"
+
+	"github.com/peletor/treiber/queue"
+	"github.com/peletor/treiber/stack"
+)
+
+// waiters holds the waiting Puts and Takes; only one kind waits at a
+// time. A SynchronousQueue never pushes, so every value in it is the one of
+// a waiting Transfer.
+type waiters interface {
+	Transfer(ctx context.Context, value int) error
+	TryTransfer(value int) bool
+	PopWait(ctx context.Context) (int, error)
+	Pop() (int, bool)
+	Len() int
+	Waiting() int
+}
+
+var (
+	_ waiters = (*queue.TransferQueue)(nil)
+	_ waiters = (*stack.TransferStack)(nil)
+)
@@ -0,0 +1,221 @@
+package handoff
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+var modes = map[string]func() *SynchronousQueue{
+	"Unfair": func() *SynchronousQueue { return NewSynchronousQueue() },
+	"Fair":   func() *SynchronousQueue { return NewSynchronousQueue(WithFair()) },
+}
+
+func TestSynchronousQueue(t *testing.T) {
+	ctx := context.Background()
+
+	for name, newQueue := range modes {
+		t.Run(name, func(t *testing.T) {
+			t.Run("Put waits for Take", func(t *testing.T) {
+				q := newQueue()
+				put := make(chan error)
+				go func() { put <- q.Put(ctx, 1) }()
+
+				require.Eventually(t, func() bool { return q.WaitingPuts() == 1 }, time.Second, time.Millisecond)
+				select {
+				case <-put:
+					t.Fatal("Put returned before a Take")
+				case <-time.After(10 * time.Millisecond):
+				}
+				value, err := q.Take(ctx)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, value)
+				assert.NoError(t, <-put)
+			})
+
+			t.Run("Take waits for Put", func(t *testing.T) {
+				q := newQueue()
+				took := make(chan int)
+				go func() {
+					value, _ := q.Take(ctx)
+					took <- value
+				}()
+
+				require.Eventually(t, func() bool { return q.WaitingTakes() == 1 }, time.Second, time.Millisecond)
+				assert.True(t, q.Offer(2))
+				assert.Equal(t, 2, <-took)
+			})
+
+			t.Run("No buffering", func(t *testing.T) {
+				q := newQueue()
+				assert.False(t, q.Offer(1))
+				_, ok := q.Poll()
+				assert.False(t, ok, "an Offer without a Take must not be kept")
+
+				timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+				defer cancel()
+				assert.ErrorIs(t, q.Put(timeout, 2), context.DeadlineExceeded)
+				_, ok = q.Poll()
+				assert.False(t, ok, "a cancelled Put must not be kept")
+				_, err := q.Take(timeout)
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+				assert.False(t, q.Offer(3), "a cancelled Take must not receive")
+				assert.Equal(t, 0, q.WaitingPuts()+q.WaitingTakes())
+			})
+		})
+	}
+}
+
+func TestSynchronousQueueOrder(t *testing.T) {
+	const count = 5
+	ctx := context.Background()
+
+	for name, newQueue := range modes {
+		t.Run(name, func(t *testing.T) {
+			q := newQueue()
+			wg := sync.WaitGroup{}
+			wg.Add(count)
+			for i := 0; i < count; i++ {
+				go func() {
+					defer wg.Done()
+					assert.NoError(t, q.Put(ctx, i))
+				}()
+				require.Eventually(t, func() bool { return q.WaitingPuts() == i+1 }, time.Second, time.Millisecond)
+			}
+
+			var values []int
+			for i := 0; i < count; i++ {
+				value, ok := q.Poll()
+				require.True(t, ok)
+				values = append(values, value)
+			}
+			wg.Wait()
+			if name == "Fair" {
+				assert.Equal(t, []int{0, 1, 2, 3, 4}, values)
+			} else {
+				assert.Equal(t, []int{4, 3, 2, 1, 0}, values)
+			}
+		})
+	}
+}
+
+func TestSynchronousQueueConcurrency(t *testing.T) {
+	const (
+		producers = 4
+		consumers = 4
+		count     = 1000
+	)
+	ctx := context.Background()
+
+	for name, newQueue := range modes {
+		t.Run(name, func(t *testing.T) {
+			q := newQueue()
+			var mu sync.Mutex
+			var received []int
+			wg := sync.WaitGroup{}
+			wg.Add(producers + consumers)
+			for p := 0; p < producers; p++ {
+				go func() {
+					defer wg.Done()
+					for i := 0; i < count; i++ {
+						assert.NoError(t, q.Put(ctx, p*count+i))
+					}
+				}()
+			}
+			for c := 0; c < consumers; c++ {
+				go func() {
+					defer wg.Done()
+					for i := 0; i < producers*count/consumers; i++ {
+						value, err := q.Take(ctx)
+						assert.NoError(t, err)
+						mu.Lock()
+						received = append(received, value)
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			sort.Ints(received)
+			require.Len(t, received, producers*count)
+			for i, value := range received {
+				require.Equal(t, i, value)
+			}
+		})
+	}
+}
+
+func TestExchanger(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Pair", func(t *testing.T) {
+		var e Exchanger[string]
+		got := make(chan string)
+		go func() {
+			value, err := e.Exchange(ctx, "a")
+			assert.NoError(t, err)
+			got <- value
+		}()
+		value, err := e.Exchange(ctx, "b")
+		assert.NoError(t, err)
+		assert.Equal(t, "a", value)
+		assert.Equal(t, "b", <-got)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		var e Exchanger[int]
+		timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err := e.Exchange(timeout, 1)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// the cancelled offer is not swapped with a later one
+		timeout, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err = e.Exchange(timeout, 2)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("Many goroutines", func(t *testing.T) {
+		const goroutines = 16
+		var e Exchanger[int]
+		// a fixed number of rounds could leave the last goroutine without a
+		// partner, so they exchange until the deadline
+		timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+
+		got := make([][]int, goroutines)
+		wg := sync.WaitGroup{}
+		wg.Add(goroutines)
+		for g := 0; g < goroutines; g++ {
+			go func() {
+				defer wg.Done()
+				for r := 0; ; r++ {
+					value, err := e.Exchange(timeout, g<<32|r)
+					if err != nil {
+						return
+					}
+					got[g] = append(got[g], value)
+				}
+			}()
+		}
+		wg.Wait()
+
+		// every goroutine swapped with a partner that swapped with it
+		partner := map[int]int{}
+		for g := range got {
+			for r, value := range got[g] {
+				assert.NotEqual(t, g, value>>32)
+				partner[g<<32|r] = value
+			}
+		}
+		assert.NotEmpty(t, partner)
+		for mine, theirs := range partner {
+			assert.Equal(t, mine, partner[theirs])
+		}
+	})
+}
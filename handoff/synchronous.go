@@ -0,0 +1,79 @@
+package handoff
+
+import (
+	"context"
+
+	"github.com/peletor/treiber/internal/nocopy"
+	"github.com/peletor/treiber/queue"
+	"github.com/peletor/treiber/stack"
+)
+
+// SynchronousQueue hands every value from a Put to a Take. It has no
+// capacity: Put waits until a Take receives its value, and Take waits until
+// a Put provides one. Offer and Poll only succeed if the other side waits
+// already.
+//
+// By default the latest waiter is served first, as in stack.TransferStack,
+// which keeps the goroutines with warm caches busy; WithFair serves them in
+// the order they came, as in queue.TransferQueue.
+//
+// Create it with NewSynchronousQueue; it must not be copied after first
+// use.
+type SynchronousQueue struct {
+	_       nocopy.NoCopy
+	waiters waiters
+}
+
+// Option configures a SynchronousQueue created by NewSynchronousQueue.
+type Option func(*SynchronousQueue)
+
+// WithFair serves waiting Puts and Takes in the order they came.
+func WithFair() Option {
+	return func(q *SynchronousQueue) {
+		q.waiters = queue.NewTransferQueue()
+	}
+}
+
+func NewSynchronousQueue(opts ...Option) *SynchronousQueue {
+	q := &SynchronousQueue{waiters: stack.NewTransferStack()}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// Put waits until a Take receives value. It returns ctx.Err() if ctx is
+// done first, and then no Take received value.
+func (q *SynchronousQueue) Put(ctx context.Context, value int) error {
+	return q.waiters.Transfer(ctx, value)
+}
+
+// Take waits for a Put and returns its value. It returns ctx.Err() if ctx
+// is done first.
+func (q *SynchronousQueue) Take(ctx context.Context) (value int, err error) {
+	return q.waiters.PopWait(ctx)
+}
+
+// Offer hands value to a waiting Take. It reports false, and drops value,
+// if no Take is waiting.
+func (q *SynchronousQueue) Offer(value int) bool {
+	return q.waiters.TryTransfer(value)
+}
+
+// Poll receives the value of a waiting Put. ok is false if no Put is
+// waiting.
+func (q *SynchronousQueue) Poll() (value int, ok bool) {
+	return q.waiters.Pop()
+}
+
+// WaitingPuts counts the Puts waiting for a Take. It is only an
+// approximation under concurrent Puts and Takes.
+func (q *SynchronousQueue) WaitingPuts() int {
+	return q.waiters.Len()
+}
+
+// WaitingTakes counts the Takes waiting for a Put, with the same caveats as
+// WaitingPuts.
+func (q *SynchronousQueue) WaitingTakes() int {
+	return q.waiters.Waiting()
+}
@@ -0,0 +1,441 @@
+// Package httpapi exposes registered containers as REST resources, so
+// operators can inspect and manipulate a live service with curl:
+//
+//	GET    /queues                  names and lengths of the queues
+//	GET    /queues/{name}?n=10      length and the first n values
+//	POST   /queues/{name}           push {"value": 5}
+//	DELETE /queues/{name}/head      pop, {"value": 5} or 204 if empty
+//	GET    /queues/{name}/events    server-sent events of pushes
+//
+// Stacks live under /stacks and pop at /stacks/{name}/top. Deques live
+// under /deques and push and pop at /deques/{name}/back and
+// /deques/{name}/front. Errors are {"error": "..."}.
+//
+// The Register methods return a wrapper of the container; the events
+// report the pushes through the API and through the wrapper, with their
+// values.
+//
+// Lengths and previews come from weakly consistent walks, see
+// queue.Queue.Len and queue.Queue.Preview.
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/peletor/treiber/deque"
+	"github.com/peletor/treiber/queue"
+	"github.com/peletor/treiber/stack"
+)
+
+var ErrDuplicate = errors.New("httpapi: container already registered")
+
+// DefaultPreview and MaxPreview bound the n of a GET.
+const (
+	DefaultPreview = 10
+	MaxPreview     = 1000
+)
+
+// eventBuffer is the number of events a slow subscriber may fall behind
+// before further events are dropped for it.
+const eventBuffer = 64
+
+// plurals maps the first path segment to the kind of container.
+var plurals = map[string]string{"stacks": "stack", "queues": "queue", "deques": "deque"}
+
+type entry struct {
+	kind    string
+	name    string
+	push    map[string]func(int)
+	pop     map[string]func() (int, bool)
+	len     func() int
+	preview func(n int) []int
+	stream  *stream
+}
+
+// event is the data of a push event.
+type event struct {
+	End   string `json:"end,omitempty"`
+	Value int    `json:"value"`
+}
+
+// stream fans the push events of one container out to its subscribers.
+// The list of subscribers is replaced under Handler.mu, so publish, which
+// every push calls, reads it without a lock.
+type stream struct {
+	subscribers atomic.Pointer[[]chan event]
+	// done is closed by Unregister and ends the event streams. The
+	// subscriber channels themselves are never closed, as a push may still
+	// be sending to them.
+	done chan struct{}
+}
+
+func newStream() *stream {
+	return &stream{done: make(chan struct{})}
+}
+
+// publish sends ev to the subscribers that keep up.
+func (s *stream) publish(ev event) {
+	subscribers := s.subscribers.Load()
+	if subscribers == nil {
+		return
+	}
+	for _, ch := range *subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// subscribe and unsubscribe must be called with Handler.mu held.
+func (s *stream) subscribe(ch chan event) {
+	var subscribers []chan event
+	if old := s.subscribers.Load(); old != nil {
+		subscribers = slices.Clone(*old)
+	}
+	subscribers = append(subscribers, ch)
+	s.subscribers.Store(&subscribers)
+}
+
+func (s *stream) unsubscribe(ch chan event) {
+	subscribers := slices.DeleteFunc(slices.Clone(*s.subscribers.Load()), func(c chan event) bool { return c == ch })
+	s.subscribers.Store(&subscribers)
+}
+
+// Handler serves the registered containers. Register them before or while
+// it serves; it is safe for concurrent use.
+type Handler struct {
+	mux *http.ServeMux
+
+	mu      sync.RWMutex
+	entries map[string]*entry
+}
+
+func NewHandler() *Handler {
+	h := &Handler{
+		mux:     http.NewServeMux(),
+		entries: make(map[string]*entry),
+	}
+	h.mux.HandleFunc("GET /{kind}", h.list)
+	h.mux.HandleFunc("GET /{kind}/{name}", h.get)
+	h.mux.HandleFunc("GET /{kind}/{name}/events", h.events)
+	h.mux.HandleFunc("POST /{kind}/{name}", h.push)
+	h.mux.HandleFunc("POST /{kind}/{name}/{end}", h.push)
+	h.mux.HandleFunc("DELETE /{kind}/{name}/{end}", h.pop)
+	return h
+}
+
+// Stack is a registered stack.Stack. Its Push reports the value to the
+// event streams of the stack; pushes made to the stack.Stack directly are
+// not seen, so the service pushes through the Stack.
+type Stack struct {
+	*stack.Stack
+	stream *stream
+}
+
+func (s *Stack) Push(value int) {
+	s.Stack.Push(value)
+	s.stream.publish(event{Value: value})
+}
+
+// Queue is a registered queue.Queue, whose Push is reported as Stack.Push.
+type Queue struct {
+	*queue.Queue
+	stream *stream
+}
+
+func (q *Queue) Push(value int) {
+	q.Queue.Push(value)
+	q.stream.publish(event{Value: value})
+}
+
+// Deque is a registered deque.Deque, whose PushBack and PushFront are
+// reported as Stack.Push.
+type Deque struct {
+	*deque.Deque
+	stream *stream
+}
+
+func (d *Deque) PushBack(value int) {
+	d.Deque.PushBack(value)
+	d.stream.publish(event{End: "back", Value: value})
+}
+
+func (d *Deque) PushFront(value int) {
+	d.Deque.PushFront(value)
+	d.stream.publish(event{End: "front", Value: value})
+}
+
+// RegisterStack serves s under name and returns the Stack to push through.
+func (h *Handler) RegisterStack(name string, s *stack.Stack) (*Stack, error) {
+	registered := &Stack{Stack: s, stream: newStream()}
+	err := h.register(&entry{
+		kind:    "stack",
+		name:    name,
+		push:    map[string]func(int){"": registered.Push},
+		pop:     map[string]func() (int, bool){"top": s.Pop},
+		len:     s.Len,
+		preview: s.Preview,
+		stream:  registered.stream,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return registered, nil
+}
+
+// RegisterQueue serves q under name and returns the Queue to push through.
+func (h *Handler) RegisterQueue(name string, q *queue.Queue) (*Queue, error) {
+	registered := &Queue{Queue: q, stream: newStream()}
+	err := h.register(&entry{
+		kind:    "queue",
+		name:    name,
+		push:    map[string]func(int){"": registered.Push},
+		pop:     map[string]func() (int, bool){"head": q.Pop},
+		len:     q.Len,
+		preview: q.Preview,
+		stream:  registered.stream,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return registered, nil
+}
+
+// RegisterDeque serves d under name and returns the Deque to push through.
+func (h *Handler) RegisterDeque(name string, d *deque.Deque) (*Deque, error) {
+	registered := &Deque{Deque: d, stream: newStream()}
+	err := h.register(&entry{
+		kind:    "deque",
+		name:    name,
+		push:    map[string]func(int){"back": registered.PushBack, "front": registered.PushFront},
+		pop:     map[string]func() (int, bool){"back": d.PopBack, "front": d.PopFront},
+		len:     d.Len,
+		preview: d.Preview,
+		stream:  registered.stream,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return registered, nil
+}
+
+func (h *Handler) register(e *entry) error {
+	key := e.kind + "/" + e.name
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.entries[key]; ok {
+		return fmt.Errorf("%w: %s %q", ErrDuplicate, e.kind, e.name)
+	}
+	h.entries[key] = e
+	return nil
+}
+
+// Unregister removes the container of the given kind ("stack", "queue" or
+// "deque") and name, and ends its event streams. It reports whether the
+// container was registered.
+func (h *Handler) Unregister(kind, name string) bool {
+	key := kind + "/" + name
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e, ok := h.entries[key]
+	if ok {
+		close(e.stream.done)
+		delete(h.entries, key)
+	}
+	return ok
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// lookup returns the container named by the path, or writes a 404.
+func (h *Handler) lookup(w http.ResponseWriter, r *http.Request) (*entry, bool) {
+	kind, ok := plurals[r.PathValue("kind")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown resource %q", r.PathValue("kind"))
+		return nil, false
+	}
+
+	h.mu.RLock()
+	e, ok := h.entries[kind+"/"+r.PathValue("name")]
+	h.mu.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "no %s %q", kind, r.PathValue("name"))
+		return nil, false
+	}
+	return e, true
+}
+
+type summary struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Len  int    `json:"len"`
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	kind, ok := plurals[r.PathValue("kind")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown resource %q", r.PathValue("kind"))
+		return
+	}
+
+	h.mu.RLock()
+	var entries []*entry
+	for _, e := range h.entries {
+		if e.kind == kind {
+			entries = append(entries, e)
+		}
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	summaries := make([]summary, len(entries))
+	for i, e := range entries {
+		summaries[i] = summary{Name: e.name, Kind: e.kind, Len: e.len()}
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
+	e, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	n := DefaultPreview
+	if param := r.URL.Query().Get("n"); param != "" {
+		var err error
+		if n, err = strconv.Atoi(param); err != nil || n < 0 || n > MaxPreview {
+			writeError(w, http.StatusBadRequest, "n must be in [0, %d]", MaxPreview)
+			return
+		}
+	}
+	preview := e.preview(n)
+	if preview == nil {
+		preview = []int{}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		summary
+		Preview []int `json:"preview"`
+	}{summary{Name: e.name, Kind: e.kind, Len: e.len()}, preview})
+}
+
+func (h *Handler) push(w http.ResponseWriter, r *http.Request) {
+	e, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	end := r.PathValue("end")
+	push, ok := e.push[end]
+	if !ok {
+		writeError(w, http.StatusNotFound, "cannot push to %s %q at %q", e.kind, e.name, end)
+		return
+	}
+
+	var body struct {
+		Value *int `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Value == nil {
+		writeError(w, http.StatusBadRequest, `body must be {"value": <int>}`)
+		return
+	}
+
+	push(*body.Value)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) pop(w http.ResponseWriter, r *http.Request) {
+	e, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	pop, ok := e.pop[r.PathValue("end")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "cannot pop %s %q at %q", e.kind, e.name, r.PathValue("end"))
+		return
+	}
+
+	value, ok := pop()
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Value int `json:"value"`
+	}{value})
+}
+
+// events streams a push event per push until the client goes away or the
+// container is unregistered.
+func (h *Handler) events(w http.ResponseWriter, r *http.Request) {
+	e, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	ch := make(chan event, eventBuffer)
+
+	h.mu.Lock()
+	if h.entries[e.kind+"/"+e.name] != e {
+		h.mu.Unlock()
+		writeError(w, http.StatusNotFound, "no %s %q", e.kind, e.name)
+		return
+	}
+	e.stream.subscribe(ch)
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		e.stream.unsubscribe(ch)
+		h.mu.Unlock()
+	}()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	// a comment, so clients see the stream open before the first push
+	fmt.Fprint(w, ": subscribed\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-e.stream.done:
+			return
+		case ev := <-ch:
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: push\ndata: %s\n\n", data)
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,220 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/peletor/treiber/deque"
+	"github.com/peletor/treiber/queue"
+	"github.com/peletor/treiber/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func do(t *testing.T, h http.Handler, method, target, body string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	data, err := io.ReadAll(rec.Result().Body)
+	require.NoError(t, err)
+	return rec.Code, strings.TrimSpace(string(data))
+}
+
+func TestRegister(t *testing.T) {
+	h := NewHandler()
+	stk := stack.NewStack()
+	registered, err := h.RegisterStack("free", stk)
+	assert.NoError(t, err)
+	assert.Same(t, stk, registered.Stack)
+	_, err = h.RegisterQueue("free", queue.NewQueue())
+	assert.NoError(t, err)
+	registered, err = h.RegisterStack("free", stk)
+	assert.ErrorIs(t, err, ErrDuplicate)
+	assert.Nil(t, registered)
+
+	assert.True(t, h.Unregister("stack", "free"))
+	assert.False(t, h.Unregister("stack", "free"))
+	code, _ := do(t, h, "GET", "/stacks/free", "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestQueue(t *testing.T) {
+	h := NewHandler()
+	que := queue.NewQueue()
+	_, err := h.RegisterQueue("jobs", que)
+	require.NoError(t, err)
+	que.Push(1)
+
+	code, _ := do(t, h, "POST", "/queues/jobs", `{"value": 2}`)
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = do(t, h, "POST", "/queues/jobs", `{"value": 3}`)
+	assert.Equal(t, http.StatusNoContent, code)
+
+	code, body := do(t, h, "GET", "/queues/jobs?n=2", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"name": "jobs", "kind": "queue", "len": 3, "preview": [1, 2]}`, body)
+
+	code, body = do(t, h, "GET", "/queues", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `[{"name": "jobs", "kind": "queue", "len": 3}]`, body)
+
+	for _, want := range []string{`{"value": 1}`, `{"value": 2}`, `{"value": 3}`} {
+		code, body = do(t, h, "DELETE", "/queues/jobs/head", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.JSONEq(t, want, body)
+	}
+	code, body = do(t, h, "DELETE", "/queues/jobs/head", "")
+	assert.Equal(t, http.StatusNoContent, code)
+	assert.Empty(t, body)
+
+	code, body = do(t, h, "GET", "/queues/jobs", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"name": "jobs", "kind": "queue", "len": 0, "preview": []}`, body)
+}
+
+func TestStackAndDeque(t *testing.T) {
+	h := NewHandler()
+	stk := stack.NewStack()
+	deq := deque.NewDeque()
+	_, err := h.RegisterStack("undo", stk)
+	require.NoError(t, err)
+	_, err = h.RegisterDeque("work", deq)
+	require.NoError(t, err)
+
+	do(t, h, "POST", "/stacks/undo", `{"value": 1}`)
+	do(t, h, "POST", "/stacks/undo", `{"value": 2}`)
+	_, body := do(t, h, "DELETE", "/stacks/undo/top", "")
+	assert.JSONEq(t, `{"value": 2}`, body)
+
+	do(t, h, "POST", "/deques/work/back", `{"value": 2}`)
+	do(t, h, "POST", "/deques/work/front", `{"value": 1}`)
+	do(t, h, "POST", "/deques/work/back", `{"value": 3}`)
+	_, body = do(t, h, "GET", "/deques/work", "")
+	assert.JSONEq(t, `{"name": "work", "kind": "deque", "len": 3, "preview": [1, 2, 3]}`, body)
+	_, body = do(t, h, "DELETE", "/deques/work/back", "")
+	assert.JSONEq(t, `{"value": 3}`, body)
+	_, body = do(t, h, "DELETE", "/deques/work/front", "")
+	assert.JSONEq(t, `{"value": 1}`, body)
+	assert.Equal(t, 1, deq.Len())
+}
+
+func TestErrors(t *testing.T) {
+	h := NewHandler()
+	_, err := h.RegisterQueue("jobs", queue.NewQueue())
+	require.NoError(t, err)
+	_, err = h.RegisterDeque("work", deque.NewDeque())
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		method, target, body string
+		code                 int
+	}{
+		{"GET", "/lists", "", http.StatusNotFound},
+		{"GET", "/queues/missing", "", http.StatusNotFound},
+		{"GET", "/queues/jobs?n=-1", "", http.StatusBadRequest},
+		{"GET", "/queues/jobs?n=x", "", http.StatusBadRequest},
+		{"POST", "/queues/jobs", `{"value": "x"}`, http.StatusBadRequest},
+		{"POST", "/queues/jobs", `{}`, http.StatusBadRequest},
+		{"POST", "/queues/jobs/front", `{"value": 1}`, http.StatusNotFound},
+		{"POST", "/deques/work", `{"value": 1}`, http.StatusNotFound},
+		{"DELETE", "/queues/jobs/tail", "", http.StatusNotFound},
+		{"PUT", "/queues/jobs", "", http.StatusMethodNotAllowed},
+	} {
+		code, body := do(t, h, tc.method, tc.target, tc.body)
+		assert.Equal(t, tc.code, code, "%s %s", tc.method, tc.target)
+		if code != http.StatusMethodNotAllowed {
+			var e struct{ Error string }
+			assert.NoError(t, json.Unmarshal([]byte(body), &e))
+			assert.NotEmpty(t, e.Error, "%s %s", tc.method, tc.target)
+		}
+	}
+}
+
+// subscribe opens the event stream of target and returns its lines once
+// the stream is open.
+func subscribe(t *testing.T, srv *httptest.Server, target string) <-chan string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+target, nil)
+	require.NoError(t, err)
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := make(chan string)
+	scanner := bufio.NewScanner(resp.Body)
+	// the subscribed comment and its blank line
+	require.True(t, scanner.Scan())
+	require.True(t, scanner.Scan())
+	go func() {
+		defer close(lines)
+		defer resp.Body.Close()
+		for scanner.Scan() {
+			if scanner.Text() != "" {
+				lines <- scanner.Text()
+			}
+		}
+	}()
+	return lines
+}
+
+func TestEvents(t *testing.T) {
+	h := NewHandler()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	deq, err := h.RegisterDeque("work", deque.NewDeque())
+	require.NoError(t, err)
+	jobs, err := h.RegisterQueue("jobs", queue.NewQueue())
+	require.NoError(t, err)
+
+	t.Run("API pushes", func(t *testing.T) {
+		lines := subscribe(t, srv, "/deques/work/events")
+		resp, err := srv.Client().Post(srv.URL+"/deques/work/front", "application/json", strings.NewReader(`{"value": 7}`))
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, "event: push", <-lines)
+		assert.Equal(t, `data: {"end":"front","value":7}`, <-lines)
+	})
+
+	t.Run("Service pushes", func(t *testing.T) {
+		lines := subscribe(t, srv, "/queues/jobs/events")
+		jobs.Push(1)
+		jobs.Pop()
+		resp, err := srv.Client().Post(srv.URL+"/queues/jobs", "application/json", strings.NewReader(`{"value": 2}`))
+		require.NoError(t, err)
+		resp.Body.Close()
+		deq.PushBack(3)
+
+		// one event per push, the pop is not reported
+		for _, value := range []string{"1", "2"} {
+			assert.Equal(t, "event: push", <-lines)
+			assert.Equal(t, `data: {"value":`+value+`}`, <-lines)
+		}
+	})
+
+	t.Run("Zero values", func(t *testing.T) {
+		lines := subscribe(t, srv, "/deques/work/events")
+		deq.PushBack(0)
+		assert.Equal(t, "event: push", <-lines)
+		assert.Equal(t, `data: {"end":"back","value":0}`, <-lines)
+	})
+
+	t.Run("Unregister ends the stream", func(t *testing.T) {
+		lines := subscribe(t, srv, "/deques/work/events")
+		h.Unregister("deque", "work")
+		for range lines {
+			t.Fatal("event after Unregister")
+		}
+		// the subscriber channels stay open, so a late push cannot panic
+		assert.NotPanics(t, func() { deq.PushBack(1) })
+	})
+}
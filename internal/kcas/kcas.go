@@ -0,0 +1,182 @@
+// Package kcas swaps several pointer words atomically: all of them change,
+// or none does.
+//
+// It implements the CASN of Harris, Fraser and Pratt (DISC 2002). A KCAS
+// first installs a descriptor in each of its words in address order, each
+// with an RDCSS that only succeeds while the descriptor is undecided, then
+// decides and replaces the descriptors with the new or the old values.
+// Anyone who meets a descriptor helps to finish its KCAS, so the words
+// never stay locked.
+//
+// Descriptors are told apart from the values by the low bits of the
+// pointer, which are free as every value points to a word-aligned item.
+// Every load of a word that a KCAS may touch must go through Load. A plain
+// CompareAndSwapPointer with a value returned by Load is fine: it fails on
+// a descriptor, as a descriptor never equals a value.
+package kcas
+
+import (
+	"cmp"
+	"slices"
+	"unsafe"
+
+	"github.com/peletor/treiber/internal/sched"
+)
+
+// Entry is one word of a KCAS: the word at Addr goes from Old to New. Old
+// and New are values, as returned by Load.
+type Entry struct {
+	Addr     *unsafe.Pointer
+	Old, New unsafe.Pointer
+}
+
+const (
+	tagDescriptor = 1
+	tagRDCSS      = 2
+	tagMask       = 3
+)
+
+// the states of a descriptor, told apart by address
+var (
+	undecided = unsafe.Pointer(new(int))
+	succeeded = unsafe.Pointer(new(int))
+	failed    = unsafe.Pointer(new(int))
+)
+
+type descriptor struct {
+	status  unsafe.Pointer
+	entries []Entry
+}
+
+// rdcss installs the descriptor d in the word of entry, if the word holds
+// entry.Old and d is undecided.
+type rdcss struct {
+	d     *descriptor
+	entry *Entry
+}
+
+func tag(p unsafe.Pointer, t uintptr) unsafe.Pointer {
+	return unsafe.Pointer(uintptr(p) + t)
+}
+
+func untag(p unsafe.Pointer) unsafe.Pointer {
+	return unsafe.Pointer(uintptr(p) &^ tagMask)
+}
+
+func tagOf(p unsafe.Pointer) uintptr {
+	return uintptr(p) & tagMask
+}
+
+// Load returns the value of the word at addr, finishing the KCAS that is
+// in progress on it, if any.
+func Load(addr *unsafe.Pointer) unsafe.Pointer {
+	// the common case, small enough to be inlined
+	if v := sched.LoadPointer(addr); tagOf(v) == 0 {
+		return v
+	}
+	return load(addr)
+}
+
+func load(addr *unsafe.Pointer) unsafe.Pointer {
+	for {
+		v := sched.LoadPointer(addr)
+		switch tagOf(v) {
+		case tagRDCSS:
+			(*rdcss)(untag(v)).complete()
+		case tagDescriptor:
+			(*descriptor)(untag(v)).run()
+		default:
+			return v
+		}
+	}
+}
+
+// CompareAndSwap swaps the words of entries from their Old to their New
+// values if all of them hold their Old value, as one atomic step. It
+// reports whether it did. The words must be distinct.
+func CompareAndSwap(entries ...Entry) bool {
+	entries = slices.Clone(entries)
+	// a global order on the words keeps two KCASes from installing into
+	// each other's words forever
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return cmp.Compare(uintptr(unsafe.Pointer(a.Addr)), uintptr(unsafe.Pointer(b.Addr)))
+	})
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Addr == entries[i-1].Addr {
+			panic("kcas: word swapped twice")
+		}
+	}
+
+	d := &descriptor{status: undecided, entries: entries}
+	return d.run()
+}
+
+// run performs or helps the KCAS of d and reports whether it succeeded.
+func (d *descriptor) run() bool {
+	self := tag(unsafe.Pointer(d), tagDescriptor)
+
+	if sched.LoadPointer(&d.status) == undecided {
+		status := succeeded
+		for i := 0; i < len(d.entries) && status == succeeded; {
+			e := &d.entries[i]
+			switch v := d.install(e); {
+			case v == self:
+				// installed by a helper
+				i++
+			case tagOf(v) == tagDescriptor:
+				// finish the other KCAS first, then try the word again
+				(*descriptor)(untag(v)).run()
+			case v != e.Old:
+				status = failed
+			default:
+				i++
+			}
+		}
+		sched.CompareAndSwapPointer(&d.status, undecided, status)
+	}
+
+	ok := sched.LoadPointer(&d.status) == succeeded
+	for i := range d.entries {
+		e := &d.entries[i]
+		if ok {
+			sched.CompareAndSwapPointer(e.Addr, self, e.New)
+		} else {
+			sched.CompareAndSwapPointer(e.Addr, self, e.Old)
+		}
+	}
+	return ok
+}
+
+// install puts d into the word of e while d is undecided. It returns what
+// it found in the word: e.Old if it installed d, or anything else, which
+// may be a descriptor.
+func (d *descriptor) install(e *Entry) unsafe.Pointer {
+	r := &rdcss{d: d, entry: e}
+	tagged := tag(unsafe.Pointer(r), tagRDCSS)
+
+	for {
+		v := sched.LoadPointer(e.Addr)
+		if tagOf(v) == tagRDCSS {
+			(*rdcss)(untag(v)).complete()
+			continue
+		}
+		if v != e.Old {
+			return v
+		}
+		if sched.CompareAndSwapPointer(e.Addr, e.Old, tagged) {
+			r.complete()
+			return e.Old
+		}
+	}
+}
+
+// complete replaces r in its word by its descriptor if that is still
+// undecided, or else by the old value.
+func (r *rdcss) complete() {
+	tagged := tag(unsafe.Pointer(r), tagRDCSS)
+	if sched.LoadPointer(&r.d.status) == undecided {
+		sched.CompareAndSwapPointer(r.entry.Addr, tagged, tag(unsafe.Pointer(r.d), tagDescriptor))
+	} else {
+		sched.CompareAndSwapPointer(r.entry.Addr, tagged, r.entry.Old)
+	}
+}
@@ -0,0 +1,123 @@
+package kcas
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func value(v int) unsafe.Pointer {
+	return unsafe.Pointer(&v)
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	a, b := value(1), value(2)
+	x, y := value(3), value(4)
+	words := []unsafe.Pointer{a, b}
+
+	t.Run("Success", func(t *testing.T) {
+		ok := CompareAndSwap(Entry{&words[0], a, x}, Entry{&words[1], b, y})
+		assert.True(t, ok)
+		assert.Equal(t, x, Load(&words[0]))
+		assert.Equal(t, y, Load(&words[1]))
+	})
+
+	t.Run("Failure changes nothing", func(t *testing.T) {
+		ok := CompareAndSwap(Entry{&words[0], x, a}, Entry{&words[1], b, a})
+		assert.False(t, ok)
+		assert.Equal(t, x, Load(&words[0]))
+		assert.Equal(t, y, Load(&words[1]))
+	})
+
+	t.Run("Nil words", func(t *testing.T) {
+		var w unsafe.Pointer
+		assert.True(t, CompareAndSwap(Entry{&w, nil, a}, Entry{&words[0], x, nil}))
+		assert.Equal(t, a, Load(&w))
+		assert.Nil(t, Load(&words[0]))
+	})
+
+	t.Run("Duplicate word", func(t *testing.T) {
+		assert.Panics(t, func() {
+			CompareAndSwap(Entry{&words[1], y, a}, Entry{&words[1], y, b})
+		})
+	})
+}
+
+// a token moves between words by concurrent KCASes; a KCAS that swaps every
+// word with itself succeeds only on a consistent state, which must hold
+// the token exactly once
+func TestConcurrent(t *testing.T) {
+	const (
+		words   = 4
+		movers  = 4
+		moves   = 5000
+		readers = 2
+	)
+
+	var w [words]unsafe.Pointer
+	w[0] = value(0)
+
+	wg := sync.WaitGroup{}
+	wg.Add(movers)
+	for i := 0; i < movers; i++ {
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for n := 0; n < moves; {
+				from, to := r.Intn(words), r.Intn(words)
+				if from == to {
+					continue
+				}
+				token := Load(&w[from])
+				if token == nil || Load(&w[to]) != nil {
+					continue
+				}
+				if CompareAndSwap(Entry{&w[from], token, nil}, Entry{&w[to], nil, value(*(*int)(token) + 1)}) {
+					n++
+				}
+			}
+		}(int64(i))
+	}
+
+	done := make(chan struct{})
+	readersDone := sync.WaitGroup{}
+	readersDone.Add(readers)
+	for i := 0; i < readers; i++ {
+		go func() {
+			defer readersDone.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				var entries []Entry
+				tokens := 0
+				for j := range w {
+					v := Load(&w[j])
+					entries = append(entries, Entry{&w[j], v, v})
+					if v != nil {
+						tokens++
+					}
+				}
+				if CompareAndSwap(entries...) {
+					assert.Equal(t, 1, tokens)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(done)
+	readersDone.Wait()
+
+	moved := 0
+	for j := range w {
+		if v := Load(&w[j]); v != nil {
+			moved += *(*int)(v)
+		}
+	}
+	assert.Equal(t, movers*moves, moved)
+}
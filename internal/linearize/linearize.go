@@ -0,0 +1,160 @@
+// Package linearize checks whether a concurrent history of operations is
+// linearizable with respect to a sequential model.
+//
+// It implements the algorithm of Wing and Gong with the state cache of
+// Lowe: operations are linearized depth first in an order consistent with
+// their real time intervals, and a combination of linearized operations and
+// model state that already failed is never explored twice.
+package linearize
+
+import (
+	"slices"
+	"sync/atomic"
+)
+
+// Operation is one call of a history. Call and Return are read from the
+// same Clock, before the call starts and after it returned.
+type Operation[I, O any] struct {
+	Input  I
+	Output O
+	Call   int64
+	Return int64
+}
+
+// Model is the sequential specification of a container.
+type Model[S, I, O any] struct {
+	Init func() S
+	// Step applies input to state and reports whether output is what the
+	// model returns, together with the next state. It must not modify state.
+	Step func(state S, input I, output O) (ok bool, next S)
+	// Key identifies a state, equal states must have equal keys.
+	Key func(state S) string
+}
+
+// Clock hands out strictly increasing timestamps to concurrent goroutines.
+type Clock struct {
+	now atomic.Int64
+}
+
+func (c *Clock) Now() int64 {
+	return c.now.Add(1)
+}
+
+type entry struct {
+	op    int
+	call  bool
+	time  int64
+	match *entry // the return entry of a call
+	prev  *entry
+	next  *entry
+}
+
+type frame[S any] struct {
+	call  *entry
+	state S
+}
+
+// Check reports whether history is linearizable with respect to m.
+func Check[S, I, O any](m Model[S, I, O], history []Operation[I, O]) bool {
+	head := buildList(history)
+	state := m.Init()
+	linearized := make([]uint64, (len(history)+63)/64)
+	cache := map[string]struct{}{}
+	var stack []frame[S]
+
+	e := head.next
+	for head.next != nil {
+		if e.call {
+			op := history[e.op]
+			if ok, next := m.Step(state, op.Input, op.Output); ok {
+				setBit(linearized, e.op)
+				key := cacheKey(linearized, m.Key(next))
+				if _, seen := cache[key]; !seen {
+					cache[key] = struct{}{}
+					stack = append(stack, frame[S]{call: e, state: state})
+					state = next
+					lift(e)
+					e = head.next
+					continue
+				}
+				clearBit(linearized, e.op)
+			}
+			e = e.next
+			continue
+		}
+
+		// e returned before anything left could be linearized: backtrack
+		if len(stack) == 0 {
+			return false
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = top.state
+		clearBit(linearized, top.call.op)
+		unlift(top.call)
+		e = top.call.next
+	}
+	return true
+}
+
+// buildList returns a sentinel followed by the calls and returns of history
+// in time order.
+func buildList[I, O any](history []Operation[I, O]) *entry {
+	entries := make([]*entry, 0, 2*len(history))
+	for i, op := range history {
+		ret := &entry{op: i, time: op.Return}
+		entries = append(entries, &entry{op: i, call: true, time: op.Call, match: ret}, ret)
+	}
+	slices.SortStableFunc(entries, func(a, b *entry) int {
+		switch {
+		case a.time < b.time:
+			return -1
+		case a.time > b.time:
+			return 1
+		}
+		return 0
+	})
+
+	head := &entry{}
+	prev := head
+	for _, e := range entries {
+		e.prev, prev.next = prev, e
+		prev = e
+	}
+	return head
+}
+
+// lift removes a call and its return from the list. They keep their own
+// links, so unlift can put them back in place.
+func lift(call *entry) {
+	call.prev.next = call.next
+	call.next.prev = call.prev
+	ret := call.match
+	ret.prev.next = ret.next
+	if ret.next != nil {
+		ret.next.prev = ret.prev
+	}
+}
+
+func unlift(call *entry) {
+	ret := call.match
+	ret.prev.next = ret
+	if ret.next != nil {
+		ret.next.prev = ret
+	}
+	call.prev.next = call
+	call.next.prev = call
+}
+
+func setBit(bits []uint64, i int)   { bits[i/64] |= 1 << (i % 64) }
+func clearBit(bits []uint64, i int) { bits[i/64] &^= 1 << (i % 64) }
+
+func cacheKey(bits []uint64, state string) string {
+	key := make([]byte, 0, 8*len(bits)+len(state))
+	for _, word := range bits {
+		for i := 0; i < 64; i += 8 {
+			key = append(key, byte(word>>i))
+		}
+	}
+	return string(append(key, state...))
+}
@@ -0,0 +1,84 @@
+package linearize
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+// register is a model of an integer register: a negative input reads the
+// value, any other input writes it.
+var register = Model[int, int, int]{
+	Init: func() int { return 0 },
+	Step: func(state, input, output int) (bool, int) {
+		if input < 0 {
+			return output == state, state
+		}
+		return true, input
+	},
+	Key: strconv.Itoa,
+}
+
+const read = -1
+
+func TestCheck(t *testing.T) {
+	t.Run("Empty history", func(t *testing.T) {
+		assert.True(t, Check(register, nil))
+	})
+
+	t.Run("Sequential history", func(t *testing.T) {
+		history := []Operation[int, int]{
+			{Input: 1, Call: 1, Return: 2},
+			{Input: read, Output: 1, Call: 3, Return: 4},
+		}
+		assert.True(t, Check(register, history))
+	})
+
+	t.Run("Stale read", func(t *testing.T) {
+		history := []Operation[int, int]{
+			{Input: 1, Call: 1, Return: 2},
+			{Input: read, Output: 0, Call: 3, Return: 4},
+		}
+		assert.False(t, Check(register, history))
+	})
+
+	t.Run("Overlapping write", func(t *testing.T) {
+		// the read overlaps the write, so it may see either value
+		for _, output := range []int{0, 1} {
+			history := []Operation[int, int]{
+				{Input: 1, Call: 1, Return: 4},
+				{Input: read, Output: output, Call: 2, Return: 3},
+			}
+			assert.True(t, Check(register, history))
+		}
+	})
+
+	t.Run("Reads disagree on order", func(t *testing.T) {
+		// two writes overlap, and two later reads see them in both orders
+		history := []Operation[int, int]{
+			{Input: 1, Call: 1, Return: 5},
+			{Input: 2, Call: 2, Return: 6},
+			{Input: read, Output: 1, Call: 7, Return: 8},
+			{Input: read, Output: 2, Call: 9, Return: 10},
+		}
+		assert.False(t, Check(register, history))
+	})
+
+	t.Run("Write after an overlapping read", func(t *testing.T) {
+		// both writes overlap the first read, which saw 2, so the write of 1
+		// must be linearized after that read
+		history := []Operation[int, int]{
+			{Input: 2, Call: 1, Return: 6},
+			{Input: 1, Call: 2, Return: 7},
+			{Input: read, Output: 2, Call: 3, Return: 8},
+			{Input: read, Output: 1, Call: 9, Return: 10},
+		}
+		assert.True(t, Check(register, history))
+	})
+}
+
+func TestClock(t *testing.T) {
+	clock := Clock{}
+	first := clock.Now()
+	assert.Less(t, first, clock.Now())
+}
@@ -0,0 +1,10 @@
+// Package pad keeps hot fields of the containers on separate cache lines.
+package pad
+
+// CacheLineSize is twice the usual 64 bytes: amd64 prefetches cache lines in
+// adjacent pairs and arm64 cores commonly use 128-byte lines.
+const CacheLineSize = 128
+
+// CacheLinePad is placed between fields that are written by different
+// goroutines, the same way golang.org/x/sys/cpu.CacheLinePad is used.
+type CacheLinePad struct{ _ [CacheLineSize]byte }
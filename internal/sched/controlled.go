@@ -0,0 +1,284 @@
+//go:build treibersched
+
+package sched
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// MaxSteps bounds the scheduling decisions of one run. A run that needs more
+// is reported with ErrLivelock.
+const MaxSteps = 100_000
+
+var (
+	ErrLivelock = errors.New("sched: schedule exceeded MaxSteps, the goroutines livelocked")
+	ErrDiverged = errors.New("sched: replayed schedule picked a goroutine that cannot run")
+)
+
+// Test builds a fresh scenario for one run: the bodies of the goroutines to
+// schedule, and a check called after all of them returned. Code in Test
+// itself runs before scheduling starts.
+type Test func() (goroutines []func(), check func() error)
+
+// Strategy picks the goroutine that runs until its next scheduling point.
+// runnable lists the indexes of the goroutines that have not returned,
+// step counts the decisions made so far in this run.
+type Strategy interface {
+	Next(runnable []int, step int) int
+}
+
+type event struct {
+	goroutine int
+	done      bool
+	panicked  any
+}
+
+type controller struct {
+	events  chan event
+	resume  []chan struct{}
+	current int
+}
+
+var active atomic.Pointer[controller]
+
+// Point parks the calling goroutine until the scheduler picks it again.
+// While Run is active every call to Point is attributed to the goroutine the
+// scheduler resumed last, so nothing else may use the containers meanwhile.
+func Point() {
+	if c := active.Load(); c != nil {
+		me := c.current
+		c.events <- event{goroutine: me}
+		<-c.resume[me]
+	}
+}
+
+// Run executes test once, one goroutine at a time, in the order chosen by s.
+// It returns the schedule, the index of the goroutine resumed at every step,
+// and the error of the check or of the run itself. Goroutines still parked
+// when a run is abandoned are leaked.
+func Run(s Strategy, test Test) (schedule []int, err error) {
+	goroutines, check := test()
+
+	c := &controller{events: make(chan event), resume: make([]chan struct{}, len(goroutines))}
+	for i := range c.resume {
+		c.resume[i] = make(chan struct{})
+	}
+	if !active.CompareAndSwap(nil, c) {
+		panic("sched: Run is already active")
+	}
+	defer active.Store(nil)
+
+	for i, f := range goroutines {
+		go func() {
+			<-c.resume[i]
+			defer func() {
+				c.events <- event{goroutine: i, done: true, panicked: recover()}
+			}()
+			f()
+		}()
+	}
+
+	runnable := make([]int, len(goroutines))
+	for i := range runnable {
+		runnable[i] = i
+	}
+	for len(runnable) > 0 {
+		if len(schedule) == MaxSteps {
+			return schedule, ErrLivelock
+		}
+		g := s.Next(runnable, len(schedule))
+		if !contains(runnable, g) {
+			return schedule, ErrDiverged
+		}
+		schedule = append(schedule, g)
+
+		c.current = g
+		c.resume[g] <- struct{}{}
+		ev := <-c.events
+		if ev.done {
+			if ev.panicked != nil {
+				return schedule, fmt.Errorf("sched: goroutine %d panicked: %v", g, ev.panicked)
+			}
+			runnable = remove(runnable, g)
+		}
+	}
+
+	// the check runs unscheduled, it may use the containers freely
+	active.Store(nil)
+	if check == nil {
+		return schedule, nil
+	}
+	return schedule, check()
+}
+
+// Failure describes the first failing run of Explore or Enumerate.
+// Replay(Schedule) repeats it exactly; for Explore so does the strategy
+// built from Seed.
+type Failure struct {
+	Seed     uint64
+	Schedule []int
+	Err      error
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("seed %d, schedule %v: %v", f.Seed, f.Schedule, f.Err)
+}
+
+// Explore runs test under the strategies built for seeds 1..runs and
+// returns the first failure, or nil.
+func Explore(runs int, newStrategy func(seed uint64) Strategy, test Test) *Failure {
+	for seed := uint64(1); seed <= uint64(runs); seed++ {
+		if schedule, err := Run(newStrategy(seed), test); err != nil {
+			return &Failure{Seed: seed, Schedule: schedule, Err: err}
+		}
+	}
+	return nil
+}
+
+// Enumerate runs test under every schedule, depth first, up to limit runs.
+// It returns the first failure, the number of runs and whether the whole
+// schedule tree was covered.
+func Enumerate(limit int, test Test) (failure *Failure, runs int, complete bool) {
+	dfs := &depthFirst{}
+	for runs < limit {
+		dfs.step = 0
+		schedule, err := Run(dfs, test)
+		runs++
+		if err != nil {
+			return &Failure{Schedule: schedule, Err: err}, runs, false
+		}
+		if !dfs.advance() {
+			return nil, runs, true
+		}
+	}
+	return nil, runs, false
+}
+
+// Random resumes a uniformly chosen runnable goroutine at every step.
+func Random(seed uint64) Strategy {
+	return &random{rng: rand.New(rand.NewPCG(seed, 0))}
+}
+
+type random struct {
+	rng *rand.Rand
+}
+
+func (r *random) Next(runnable []int, _ int) int {
+	return runnable[r.rng.IntN(len(runnable))]
+}
+
+// PCT is the probabilistic concurrency testing strategy of Burckhardt et al.:
+// goroutines get random distinct priorities and the highest runnable one
+// always runs, except that at depth-1 random steps among the first steps
+// decisions the running goroutine drops below all others. A bug that needs
+// depth ordering constraints is found with probability of at least
+// 1/(n*steps^(depth-1)) per run.
+func PCT(seed uint64, depth, steps int) Strategy {
+	return &pct{rng: rand.New(rand.NewPCG(seed, 1)), depth: depth, steps: steps}
+}
+
+type pct struct {
+	rng      *rand.Rand
+	depth    int
+	steps    int
+	priority []int
+	change   map[int]int // step -> the low priority given at that step
+}
+
+func (p *pct) Next(runnable []int, step int) int {
+	if p.priority == nil {
+		// every goroutine is runnable before the first step
+		n := len(runnable)
+		p.priority = make([]int, n)
+		for i, g := range p.rng.Perm(n) {
+			p.priority[g] = p.depth + i
+		}
+		p.change = make(map[int]int, p.depth)
+		for i := 1; i < p.depth; i++ {
+			p.change[p.rng.IntN(max(p.steps, 1))] = p.depth - i
+		}
+	}
+
+	best := highest(runnable, p.priority)
+	if low, ok := p.change[step]; ok {
+		p.priority[best] = low
+		best = highest(runnable, p.priority)
+	}
+	return best
+}
+
+func highest(runnable, priority []int) int {
+	best := runnable[0]
+	for _, g := range runnable[1:] {
+		if priority[g] > priority[best] {
+			best = g
+		}
+	}
+	return best
+}
+
+// Replay resumes the goroutines in the order of schedule.
+func Replay(schedule []int) Strategy {
+	return replay(schedule)
+}
+
+type replay []int
+
+func (r replay) Next(_ []int, step int) int {
+	if step >= len(r) {
+		return -1
+	}
+	return r[step]
+}
+
+// depthFirst walks the schedule tree: it follows the choices of the previous
+// run up to the deepest decision with an untried alternative.
+type depthFirst struct {
+	choices []int // index into runnable taken at every step
+	options []int // number of runnable goroutines at every step
+	step    int
+}
+
+func (d *depthFirst) Next(runnable []int, step int) int {
+	if step < len(d.choices) {
+		d.options[step] = len(runnable)
+	} else {
+		d.choices = append(d.choices, 0)
+		d.options = append(d.options, len(runnable))
+	}
+	d.step = step + 1
+	return runnable[d.choices[step]]
+}
+
+func (d *depthFirst) advance() bool {
+	d.choices, d.options = d.choices[:d.step], d.options[:d.step]
+	for i := len(d.choices) - 1; i >= 0; i-- {
+		if d.choices[i]+1 < d.options[i] {
+			d.choices[i]++
+			d.choices, d.options = d.choices[:i+1], d.options[:i+1]
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []int, x int) bool {
+	for _, v := range list {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(list []int, x int) []int {
+	for i, v := range list {
+		if v == x {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
@@ -0,0 +1,119 @@
+//go:build treibersched
+
+package sched
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var errLostUpdate = errors.New("lost update")
+
+// racyCounter increments a counter without atomics, with a scheduling
+// point between the read and the write.
+func racyCounter(goroutines int) Test {
+	return func() ([]func(), func() error) {
+		counter := 0
+		increment := func() {
+			Point()
+			value := counter
+			Point()
+			counter = value + 1
+		}
+
+		bodies := make([]func(), goroutines)
+		for i := range bodies {
+			bodies[i] = increment
+		}
+		return bodies, func() error {
+			if counter != goroutines {
+				return errLostUpdate
+			}
+			return nil
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Run("Sequential schedule", func(t *testing.T) {
+		schedule, err := Run(Replay([]int{0, 0, 0, 1, 1, 1}), racyCounter(2))
+		assert.NoError(t, err)
+		assert.Equal(t, []int{0, 0, 0, 1, 1, 1}, schedule)
+	})
+
+	t.Run("Interleaved schedule", func(t *testing.T) {
+		_, err := Run(Replay([]int{0, 1, 0, 1, 0, 1}), racyCounter(2))
+		assert.ErrorIs(t, err, errLostUpdate)
+	})
+
+	t.Run("Diverged replay", func(t *testing.T) {
+		_, err := Run(Replay([]int{0, 0, 0, 0}), racyCounter(2))
+		assert.ErrorIs(t, err, ErrDiverged)
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		_, err := Run(Random(1), func() ([]func(), func() error) {
+			return []func(){func() { panic("boom") }}, nil
+		})
+		assert.ErrorContains(t, err, "boom")
+	})
+
+	t.Run("Livelock", func(t *testing.T) {
+		_, err := Run(Random(1), func() ([]func(), func() error) {
+			return []func(){func() {
+				for {
+					Point()
+				}
+			}}, nil
+		})
+		assert.ErrorIs(t, err, ErrLivelock)
+	})
+
+	t.Run("Point outside Run", func(t *testing.T) {
+		assert.NotPanics(t, Point)
+	})
+}
+
+func TestEnumerate(t *testing.T) {
+	t.Run("Finds lost update", func(t *testing.T) {
+		failure, _, _ := Enumerate(1000, racyCounter(2))
+		if assert.NotNil(t, failure) {
+			assert.ErrorIs(t, failure.Err, errLostUpdate)
+
+			_, err := Run(Replay(failure.Schedule), racyCounter(2))
+			assert.ErrorIs(t, err, errLostUpdate)
+		}
+	})
+
+	t.Run("Covers every schedule", func(t *testing.T) {
+		test := func() ([]func(), func() error) {
+			body := func() { Point() }
+			return []func(){body, body}, nil
+		}
+		// each goroutine takes two steps: one to its Point, one to return
+		failure, runs, complete := Enumerate(1000, test)
+		assert.Nil(t, failure)
+		assert.True(t, complete)
+		assert.Equal(t, 6, runs) // (2+2)! / (2! * 2!)
+	})
+}
+
+func TestExplore(t *testing.T) {
+	for name, strategy := range map[string]func(uint64) Strategy{
+		"Random": Random,
+		"PCT":    func(seed uint64) Strategy { return PCT(seed, 2, 10) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			failure := Explore(1000, strategy, racyCounter(3))
+			if assert.NotNil(t, failure) {
+				assert.ErrorIs(t, failure.Err, errLostUpdate)
+
+				// the seed reproduces the schedule exactly
+				schedule, err := Run(strategy(failure.Seed), racyCounter(3))
+				assert.ErrorIs(t, err, errLostUpdate)
+				assert.Equal(t, failure.Schedule, schedule)
+			}
+		})
+	}
+}
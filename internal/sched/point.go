@@ -0,0 +1,6 @@
+//go:build !treibersched
+
+package sched
+
+// Point does nothing unless built with the treibersched tag.
+func Point() {}
@@ -0,0 +1,27 @@
+// Package sched puts scheduling points in front of every atomic operation of
+// the containers.
+//
+// In normal builds Point is empty and the helpers are plain atomics. Built
+// with the treibersched tag, Point hands control to the scheduler started by
+// Run, which decides which goroutine performs the next atomic operation.
+// That makes rare interleavings of the CAS loops reachable, and a failing
+// schedule replayable from its seed.
+package sched
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// LoadPointer is atomic.LoadPointer preceded by a scheduling point.
+func LoadPointer(addr *unsafe.Pointer) unsafe.Pointer {
+	Point()
+	return atomic.LoadPointer(addr)
+}
+
+// CompareAndSwapPointer is atomic.CompareAndSwapPointer preceded by a
+// scheduling point.
+func CompareAndSwapPointer(addr *unsafe.Pointer, old, new unsafe.Pointer) bool {
+	Point()
+	return atomic.CompareAndSwapPointer(addr, old, new)
+}
@@ -0,0 +1,70 @@
+// Package snapshot encodes the values of a container snapshot, in pop order,
+// for the encoding methods of the containers.
+//
+// The binary form is a version byte, the number of values as a uvarint and
+// then every value as a varint.
+package snapshot
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const version = 1
+
+var ErrFormat = errors.New("snapshot: malformed data")
+
+func MarshalBinary(values []int) []byte {
+	data := make([]byte, 0, 1+binary.MaxVarintLen64*(1+len(values)))
+	data = append(data, version)
+	data = binary.AppendUvarint(data, uint64(len(values)))
+	for _, value := range values {
+		data = binary.AppendVarint(data, int64(value))
+	}
+	return data
+}
+
+func UnmarshalBinary(data []byte) ([]int, error) {
+	if len(data) == 0 || data[0] != version {
+		return nil, fmt.Errorf("%w: unknown version", ErrFormat)
+	}
+	data = data[1:]
+	count, n := binary.Uvarint(data)
+	// every value takes at least one byte, which bounds the allocation
+	if n <= 0 || count > uint64(len(data)-n) {
+		return nil, fmt.Errorf("%w: bad count", ErrFormat)
+	}
+	data = data[n:]
+
+	values := make([]int, count)
+	for i := range values {
+		value, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("%w: bad value %d", ErrFormat, i)
+		}
+		values[i] = int(value)
+		data = data[n:]
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", ErrFormat)
+	}
+	return values, nil
+}
+
+// MarshalJSON encodes values as a JSON array, [] if there are none.
+func MarshalJSON(values []int) ([]byte, error) {
+	if values == nil {
+		values = []int{}
+	}
+	return json.Marshal(values)
+}
+
+func UnmarshalJSON(data []byte) ([]int, error) {
+	var values []int
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,43 @@
+package snapshot
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestBinary(t *testing.T) {
+	for _, values := range [][]int{{}, {0}, {1, -1, math.MaxInt, math.MinInt}} {
+		got, err := UnmarshalBinary(MarshalBinary(values))
+		assert.NoError(t, err)
+		assert.Equal(t, values, got)
+	}
+
+	t.Run("Malformed", func(t *testing.T) {
+		valid := MarshalBinary([]int{1, 300})
+		for _, data := range [][]byte{
+			nil,
+			{0, 0},
+			{version},
+			{version, 200},
+			valid[:len(valid)-1],
+			append(valid, 0),
+		} {
+			_, err := UnmarshalBinary(data)
+			assert.ErrorIs(t, err, ErrFormat, "%v", data)
+		}
+	})
+}
+
+func TestJSON(t *testing.T) {
+	data, err := MarshalJSON(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]", string(data))
+
+	values, err := UnmarshalJSON([]byte("[3,1,2]"))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 1, 2}, values)
+
+	_, err = UnmarshalJSON([]byte(`{"a":1}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,23 @@
+//go:build !treiberstats
+
+package stats
+
+// Enabled reports whether the counters are compiled in.
+const Enabled = false
+
+// Counters counts nothing unless built with the treiberstats tag.
+type Counters struct{}
+
+func (*Counters) Push()       {}
+func (*Counters) Pop()        {}
+func (*Counters) EmptyPop()   {}
+func (*Counters) CASFailure() {}
+func (*Counters) Helping()    {}
+
+func (*Counters) Snapshot() Snapshot {
+	return Snapshot{}
+}
+
+// Publish does nothing unless built with the treiberstats tag,
+// so expvar is not linked in.
+func Publish(string, func() Snapshot) {}
@@ -0,0 +1,67 @@
+//go:build treiberstats
+
+package stats
+
+import (
+	"expvar"
+	"math/rand/v2"
+	"sync/atomic"
+
+	"github.com/peletor/treiber/internal/pad"
+)
+
+// Enabled reports whether the counters are compiled in.
+const Enabled = true
+
+// shardCount must be a power of two.
+const shardCount = 16
+
+// shard fills a cache line, so the counters of two shards never share one.
+type shard struct {
+	pushes       atomic.Uint64
+	pops         atomic.Uint64
+	emptyPops    atomic.Uint64
+	casFailures  atomic.Uint64
+	helpingSteps atomic.Uint64
+	_            [pad.CacheLineSize - 5*8]byte
+}
+
+// Counters spreads increments over several cache lines, so goroutines
+// running on different processors rarely write the same line.
+// The zero value is ready to use.
+type Counters struct {
+	shards [shardCount]shard
+}
+
+func (c *Counters) shard() *shard {
+	return &c.shards[rand.Uint32()&(shardCount-1)]
+}
+
+func (c *Counters) Push()       { c.shard().pushes.Add(1) }
+func (c *Counters) Pop()        { c.shard().pops.Add(1) }
+func (c *Counters) EmptyPop()   { c.shard().emptyPops.Add(1) }
+func (c *Counters) CASFailure() { c.shard().casFailures.Add(1) }
+func (c *Counters) Helping()    { c.shard().helpingSteps.Add(1) }
+
+// Snapshot sums the shards. Every counter is read atomically, but the
+// counters are not read at one instant with respect to each other.
+func (c *Counters) Snapshot() Snapshot {
+	var s Snapshot
+	for i := range c.shards {
+		sh := &c.shards[i]
+		s.Pushes += sh.pushes.Load()
+		s.Pops += sh.pops.Load()
+		s.EmptyPops += sh.emptyPops.Load()
+		s.CASFailures += sh.casFailures.Load()
+		s.HelpingSteps += sh.helpingSteps.Load()
+	}
+	return s
+}
+
+// Publish exports the snapshots returned by f as the expvar variable name.
+// Like expvar.Publish it panics if the name is already in use.
+func Publish(name string, f func() Snapshot) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return f()
+	}))
+}
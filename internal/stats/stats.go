@@ -0,0 +1,15 @@
+// Package stats counts what the CAS loops of the containers do.
+//
+// Counting is compiled in only with the treiberstats build tag. Without it
+// Counters is an empty struct and all of its methods are empty, so the
+// calls in the containers compile to nothing.
+package stats
+
+// Snapshot is a copy of the counters of one container.
+type Snapshot struct {
+	Pushes       uint64 // successful pushes
+	Pops         uint64 // pops that returned a value
+	EmptyPops    uint64 // pops that found the container empty
+	CASFailures  uint64 // failed CAS attempts that made an operation retry
+	HelpingSteps uint64 // CAS attempts fixing a pointer left behind by another goroutine
+}
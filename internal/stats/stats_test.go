@@ -0,0 +1,52 @@
+package stats
+
+import (
+	"expvar"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestCounters(t *testing.T) {
+	t.Run("Zero value", func(t *testing.T) {
+		var c Counters
+		assert.Equal(t, Snapshot{}, c.Snapshot())
+	})
+
+	t.Run("Concurrent increments", func(t *testing.T) {
+		const count = 1000
+		var c Counters
+
+		wg := sync.WaitGroup{}
+		wg.Add(count)
+		for i := 0; i < count; i++ {
+			go func() {
+				defer wg.Done()
+				c.Push()
+				c.Pop()
+				c.EmptyPop()
+				c.CASFailure()
+				c.Helping()
+			}()
+		}
+		wg.Wait()
+
+		want := Snapshot{}
+		if Enabled {
+			want = Snapshot{Pushes: count, Pops: count, EmptyPops: count, CASFailures: count, HelpingSteps: count}
+		}
+		assert.Equal(t, want, c.Snapshot())
+	})
+
+	t.Run("Publish", func(t *testing.T) {
+		var c Counters
+		c.Push()
+		Publish("stats_test", c.Snapshot)
+
+		if Enabled {
+			assert.JSONEq(t,
+				`{"Pushes":1,"Pops":0,"EmptyPops":0,"CASFailures":0,"HelpingSteps":0}`,
+				expvar.Get("stats_test").String())
+		}
+	})
+}
@@ -0,0 +1,182 @@
+// Package wire is the protocol between treiberd and package client.
+//
+// Every message is a frame: its length as a big endian uint32, then the
+// body. A request body is
+//
+//	op      byte
+//	kind    byte
+//	end     byte    Back or Front, for a Deque
+//	nameLen byte
+//	name    nameLen bytes
+//	arg     int64   the value of a Push, the timeout of a PopWait in ns
+//
+// and a response body is
+//
+//	status  byte
+//	value   int64
+//	message the rest, for StatusError
+//
+// A client sends one request at a time and reads its response before the
+// next.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type Op byte
+
+const (
+	OpPush Op = iota + 1
+	OpPop
+	// OpPopWait pops, waiting up to arg nanoseconds for a value.
+	OpPopWait
+	OpPeek
+	OpLen
+)
+
+// Kind selects the container type of a named container. A name is bound
+// to the kind it was first used with.
+type Kind byte
+
+const (
+	KindQueue Kind = iota + 1
+	KindStack
+	KindDeque
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindQueue:
+		return "queue"
+	case KindStack:
+		return "stack"
+	case KindDeque:
+		return "deque"
+	}
+	return fmt.Sprintf("Kind(%d)", byte(k))
+}
+
+// End is the end of a Deque an operation works on; other kinds ignore it.
+type End byte
+
+const (
+	Back End = iota
+	Front
+)
+
+type Status byte
+
+const (
+	StatusOK Status = iota
+	// StatusEmpty answers a pop or peek of an empty container, and a
+	// PopWait that timed out.
+	StatusEmpty
+	StatusError
+)
+
+// MaxNameLen is the longest container name.
+const MaxNameLen = 255
+
+// maxFrame bounds the frames a reader accepts.
+const maxFrame = 4 + MaxNameLen + 8 + 1<<10
+
+var ErrFrame = errors.New("wire: malformed frame")
+
+type Request struct {
+	Op   Op
+	Kind Kind
+	End  End
+	Name string
+	Arg  int64
+}
+
+type Response struct {
+	Status  Status
+	Value   int64
+	Message string
+}
+
+func WriteRequest(w io.Writer, req Request) error {
+	if len(req.Name) > MaxNameLen {
+		return fmt.Errorf("wire: name longer than %d bytes", MaxNameLen)
+	}
+	body := make([]byte, 0, 4+len(req.Name)+8)
+	body = append(body, byte(req.Op), byte(req.Kind), byte(req.End), byte(len(req.Name)))
+	body = append(body, req.Name...)
+	body = binary.BigEndian.AppendUint64(body, uint64(req.Arg))
+	return writeFrame(w, body)
+}
+
+func ReadRequest(r io.Reader) (Request, error) {
+	body, err := readFrame(r)
+	if err != nil {
+		return Request{}, err
+	}
+	if len(body) < 4 || len(body) != 4+int(body[3])+8 {
+		return Request{}, ErrFrame
+	}
+	nameLen := int(body[3])
+	return Request{
+		Op:   Op(body[0]),
+		Kind: Kind(body[1]),
+		End:  End(body[2]),
+		Name: string(body[4 : 4+nameLen]),
+		Arg:  int64(binary.BigEndian.Uint64(body[4+nameLen:])),
+	}, nil
+}
+
+func WriteResponse(w io.Writer, resp Response) error {
+	body := make([]byte, 0, 9+len(resp.Message))
+	body = append(body, byte(resp.Status))
+	body = binary.BigEndian.AppendUint64(body, uint64(resp.Value))
+	body = append(body, resp.Message...)
+	return writeFrame(w, body)
+}
+
+func ReadResponse(r io.Reader) (Response, error) {
+	body, err := readFrame(r)
+	if err != nil {
+		return Response{}, err
+	}
+	if len(body) < 9 {
+		return Response{}, ErrFrame
+	}
+	return Response{
+		Status:  Status(body[0]),
+		Value:   int64(binary.BigEndian.Uint64(body[1:])),
+		Message: string(body[9:]),
+	}, nil
+}
+
+func writeFrame(w io.Writer, body []byte) error {
+	if len(body) > maxFrame {
+		return fmt.Errorf("wire: frame of %d bytes", len(body))
+	}
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(body)), uint32(len(body)))
+	_, err := w.Write(append(frame, body...))
+	return err
+}
+
+// readFrame returns io.EOF only if r ended between frames.
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrame {
+		return nil, ErrFrame
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return body, nil
+}
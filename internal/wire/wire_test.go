@@ -0,0 +1,66 @@
+package wire
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestRequest(t *testing.T) {
+	requests := []Request{
+		{Op: OpPush, Kind: KindQueue, Name: "jobs", Arg: math.MinInt64},
+		{Op: OpPopWait, Kind: KindDeque, End: Front, Name: strings.Repeat("n", MaxNameLen), Arg: 1e9},
+		{Op: OpLen, Kind: KindStack},
+	}
+	buf := bytes.Buffer{}
+	for _, req := range requests {
+		require.NoError(t, WriteRequest(&buf, req))
+	}
+	for _, want := range requests {
+		got, err := ReadRequest(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := ReadRequest(&buf)
+	assert.Equal(t, io.EOF, err)
+
+	assert.Error(t, WriteRequest(&buf, Request{Name: strings.Repeat("n", MaxNameLen+1)}))
+}
+
+func TestResponse(t *testing.T) {
+	responses := []Response{
+		{Status: StatusOK, Value: 42},
+		{Status: StatusEmpty},
+		{Status: StatusError, Message: "unknown op"},
+	}
+	buf := bytes.Buffer{}
+	for _, resp := range responses {
+		require.NoError(t, WriteResponse(&buf, resp))
+	}
+	for _, want := range responses {
+		got, err := ReadResponse(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	for _, data := range [][]byte{
+		{0, 0, 0, 2, 1, 1}, // too short for a request
+		{0, 0, 0, 13, 1, 1, 0, 5, 'a', 0, 0, 0, 0, 0, 0, 0, 0}, // name longer than the frame
+		{0xff, 0, 0, 0}, // frame too large
+	} {
+		_, err := ReadRequest(bytes.NewReader(data))
+		assert.ErrorIs(t, err, ErrFrame, "%v", data)
+	}
+
+	_, err := ReadResponse(bytes.NewReader([]byte{0, 0, 0, 1, 0}))
+	assert.ErrorIs(t, err, ErrFrame)
+
+	_, err = ReadResponse(bytes.NewReader([]byte{0, 0, 0, 9, 0}))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
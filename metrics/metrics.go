@@ -0,0 +1,190 @@
+// Package metrics serves the counters and lengths of registered containers
+// in the Prometheus text exposition format.
+//
+// Pushes, pops and CAS retries are counted by the containers themselves,
+// which only happens when the program is built with the treiberstats tag.
+// Without it only treiber_length is exported per container, since the
+// counters would stay at zero; treiber_stats_enabled tells which build is
+// being scraped.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/peletor/treiber/deque"
+	"github.com/peletor/treiber/internal/stats"
+	"github.com/peletor/treiber/queue"
+	"github.com/peletor/treiber/stack"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var ErrDuplicate = errors.New("metrics: container already registered")
+
+type source interface {
+	Stats() stats.Snapshot
+	Len() int
+}
+
+type entry struct {
+	kind   string
+	name   string
+	source source
+}
+
+// Registry holds named containers. It implements http.Handler, so it can be
+// mounted on any mux, e.g. mux.Handle("/metrics", registry).
+type Registry struct {
+	mu      sync.RWMutex
+	entries map[string]entry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[string]entry)}
+}
+
+func (r *Registry) RegisterStack(name string, s *stack.Stack) error {
+	return r.register("stack", name, s)
+}
+
+func (r *Registry) RegisterQueue(name string, q *queue.Queue) error {
+	return r.register("queue", name, q)
+}
+
+func (r *Registry) RegisterDeque(name string, d *deque.Deque) error {
+	return r.register("deque", name, d)
+}
+
+func (r *Registry) register(kind, name string, src source) error {
+	key := kind + "/" + name
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.entries[key]; ok {
+		return fmt.Errorf("%w: %s %q", ErrDuplicate, kind, name)
+	}
+	r.entries[key] = entry{kind: kind, name: name, source: src}
+	return nil
+}
+
+// Unregister removes the container of the given kind ("stack", "queue" or
+// "deque") and name. It reports whether the container was registered.
+func (r *Registry) Unregister(kind, name string) bool {
+	key := kind + "/" + name
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.entries[key]
+	delete(r.entries, key)
+	return ok
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = r.WriteTo(w)
+}
+
+type sample struct {
+	labels string
+	snap   stats.Snapshot
+	length int
+}
+
+type family struct {
+	name  string
+	typ   string
+	help  string
+	value func(sample) uint64
+}
+
+var families = []family{
+	{"treiber_pushes_total", "counter", "Successful pushes.", func(s sample) uint64 { return s.snap.Pushes }},
+	{"treiber_pops_total", "counter", "Pops that returned an item.", func(s sample) uint64 { return s.snap.Pops }},
+	{"treiber_empty_pops_total", "counter", "Pops that found the container empty.", func(s sample) uint64 { return s.snap.EmptyPops }},
+	{"treiber_cas_retries_total", "counter", "Failed CAS attempts that made an operation retry.", func(s sample) uint64 { return s.snap.CASFailures }},
+	{"treiber_helping_steps_total", "counter", "CAS attempts fixing a pointer left behind by another goroutine.", func(s sample) uint64 { return s.snap.HelpingSteps }},
+	{"treiber_length", "gauge", "Number of items, counted by a weakly consistent walk.", func(s sample) uint64 { return uint64(s.length) }},
+}
+
+// WriteTo writes all metrics in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	samples := r.collect()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	enabled := 0
+	if stats.Enabled {
+		enabled = 1
+	}
+	fmt.Fprintf(bw, "# HELP treiber_stats_enabled Whether the binary was built with the treiberstats tag.\n")
+	fmt.Fprintf(bw, "# TYPE treiber_stats_enabled gauge\n")
+	fmt.Fprintf(bw, "treiber_stats_enabled %d\n", enabled)
+
+	for _, f := range families {
+		if f.typ == "counter" && !stats.Enabled {
+			continue
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+		for _, s := range samples {
+			fmt.Fprintf(bw, "%s{%s} %d\n", f.name, s.labels, f.value(s))
+		}
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (r *Registry) collect() []sample {
+	r.mu.RLock()
+	entries := make([]entry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].kind != entries[j].kind {
+			return entries[i].kind < entries[j].kind
+		}
+		return entries[i].name < entries[j].name
+	})
+
+	samples := make([]sample, len(entries))
+	for i, e := range entries {
+		samples[i] = sample{
+			labels: fmt.Sprintf(`kind="%s",name="%s"`, e.kind, escapeLabel(e.name)),
+			snap:   e.source.Stats(),
+			length: e.source.Len(),
+		}
+	}
+	return samples
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/peletor/treiber/deque"
+	"github.com/peletor/treiber/internal/stats"
+	"github.com/peletor/treiber/queue"
+	"github.com/peletor/treiber/stack"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Run("Register", func(t *testing.T) {
+		reg := NewRegistry()
+		st := stack.NewStack()
+		que := queue.NewQueue()
+
+		assert.NoError(t, reg.RegisterStack("free", st))
+		assert.NoError(t, reg.RegisterQueue("free", que))
+		assert.ErrorIs(t, reg.RegisterStack("free", st), ErrDuplicate)
+	})
+
+	t.Run("Unregister", func(t *testing.T) {
+		reg := NewRegistry()
+		deq := deque.NewDeque()
+
+		assert.NoError(t, reg.RegisterDeque("work", deq))
+		assert.True(t, reg.Unregister("deque", "work"))
+		assert.False(t, reg.Unregister("deque", "work"))
+		assert.NoError(t, reg.RegisterDeque("work", deq))
+	})
+}
+
+func TestServeHTTP(t *testing.T) {
+	reg := NewRegistry()
+
+	st := stack.NewStack()
+	que := queue.NewQueue()
+	deq := deque.NewDeque()
+	assert.NoError(t, reg.RegisterStack("free", st))
+	assert.NoError(t, reg.RegisterQueue("jobs", que))
+	assert.NoError(t, reg.RegisterDeque(`we"ird\name`, deq))
+
+	st.Push(1)
+	que.Push(1)
+	que.Push(2)
+	que.Pop()
+	deq.PopFront()
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Result().Body)
+	text := string(body)
+
+	assert.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+	assert.Contains(t, text, "# TYPE treiber_length gauge\n")
+	assert.Contains(t, text, `treiber_length{kind="stack",name="free"} 1`+"\n")
+	assert.Contains(t, text, `treiber_length{kind="queue",name="jobs"} 1`+"\n")
+	assert.Contains(t, text, `treiber_length{kind="deque",name="we\"ird\\name"} 0`+"\n")
+
+	if stats.Enabled {
+		assert.Contains(t, text, "treiber_stats_enabled 1\n")
+		assert.Contains(t, text, "# TYPE treiber_pushes_total counter\n")
+		assert.Contains(t, text, `treiber_pushes_total{kind="queue",name="jobs"} 2`+"\n")
+		assert.Contains(t, text, `treiber_pops_total{kind="queue",name="jobs"} 1`+"\n")
+		assert.Contains(t, text, `treiber_empty_pops_total{kind="deque",name="we\"ird\\name"} 1`+"\n")
+	} else {
+		assert.Contains(t, text, "treiber_stats_enabled 0\n")
+		assert.NotContains(t, text, "treiber_pushes_total")
+		assert.NotContains(t, text, "treiber_cas_retries_total")
+	}
+
+	// Every sample line is "name{labels} value" and every family is announced.
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		var value uint64
+		_, err := fmt.Sscanf(line[strings.LastIndexByte(line, ' ')+1:], "%d", &value)
+		assert.NoError(t, err, line)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	reg := NewRegistry()
+	sb := &strings.Builder{}
+
+	n, err := reg.WriteTo(sb)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(sb.Len()), n)
+}
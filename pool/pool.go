@@ -0,0 +1,336 @@
+// Package pool keeps idle objects for reuse, like sync.Pool, but does not
+// drop them at every garbage collection: an object stays in the pool until
+// a Get takes it, or it has been idle for longer than the max age.
+//
+// The idle objects live in Treiber stacks, one shard per P. Get and Put
+// usually start at the shard of the goroutine's P, and Get steals from the
+// other shards when that one is empty.
+package pool
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/peletor/treiber/internal/nocopy"
+	"github.com/peletor/treiber/internal/pad"
+	"github.com/peletor/treiber/internal/sched"
+)
+
+// DefaultMaxIdle is the number of idle objects a pool keeps unless created
+// WithMaxIdle.
+const DefaultMaxIdle = 1024
+
+// item is an idle object. Like boundedItem of stack.BoundedStack it knows
+// the depth of the shard it tops, so checking the capacity of the shard and
+// pushing are one CAS.
+type item[T any] struct {
+	value T
+	depth int
+	// since is when the object was put, in nanoseconds since the epoch of
+	// the pool; it is only set with a max age.
+	since int64
+	next  unsafe.Pointer
+}
+
+// shard is a Treiber stack of idle objects, with the counters of the Gets
+// and Puts that started at it.
+type shard[T any] struct {
+	head     unsafe.Pointer
+	capacity int
+
+	hits      atomic.Uint64
+	steals    atomic.Uint64
+	news      atomic.Uint64
+	puts      atomic.Uint64
+	drops     atomic.Uint64
+	evictions atomic.Uint64
+	_         pad.CacheLinePad
+}
+
+// push pushes it, and reports false if the shard is full.
+func (s *shard[T]) push(it *item[T]) bool {
+	for {
+		head := sched.LoadPointer(&s.head)
+		depth := 0
+		if head != nil {
+			depth = (*item[T])(head).depth
+		}
+		if depth == s.capacity {
+			return false
+		}
+
+		it.next = head
+		it.depth = depth + 1
+		if sched.CompareAndSwapPointer(&s.head, head, unsafe.Pointer(it)) {
+			return true
+		}
+	}
+}
+
+func (s *shard[T]) pop() *item[T] {
+	for {
+		head := sched.LoadPointer(&s.head)
+		if head == nil {
+			return nil
+		}
+
+		next := sched.LoadPointer(&(*item[T])(head).next)
+		if sched.CompareAndSwapPointer(&s.head, head, next) {
+			return (*item[T])(head)
+		}
+	}
+}
+
+// Stats is a snapshot of the counters of a pool. Unlike the Stats of the
+// containers they are always maintained. Gets is Hits + Steals + News.
+type Stats struct {
+	Hits      uint64 // Gets served by the shard of their P
+	Steals    uint64 // Gets served by another shard
+	News      uint64 // Gets that found the pool empty and made an object
+	Puts      uint64 // objects kept by Put
+	Drops     uint64 // objects Put to a full pool, and discarded
+	Evictions uint64 // objects discarded after the max age
+	Idle      int    // idle objects
+}
+
+// Pool is a lock-free pool of objects of type T, bounded in size and age.
+//
+// Create it with NewPool; it must not be copied after first use.
+type Pool[T any] struct {
+	_      nocopy.NoCopy
+	shards []shard[T]
+	// hints hands out shard indexes. sync.Pool caches them per P, so a
+	// goroutine usually uses the shard of its P, though a Get may steal the
+	// index of another P; losing them at a garbage collection only costs
+	// new indexes.
+	hints    sync.Pool
+	nextHint atomic.Uint32
+
+	newObject  func() T
+	reset      func(T)
+	discard    func(T)
+	maxIdle    int
+	maxAge     time.Duration
+	shardCount int
+	epoch      time.Time
+}
+
+// Option configures a Pool created by NewPool.
+type Option[T any] func(*Pool[T])
+
+// WithReset makes Put call reset on every object it keeps, so Get returns
+// it clean, e.g. WithReset((*bytes.Buffer).Reset).
+func WithReset[T any](reset func(T)) Option[T] {
+	return func(p *Pool[T]) {
+		p.reset = reset
+	}
+}
+
+// WithDiscard makes the pool call discard on every object it drops or
+// evicts, e.g. to close a connection.
+func WithDiscard[T any](discard func(T)) Option[T] {
+	return func(p *Pool[T]) {
+		p.discard = discard
+	}
+}
+
+// WithMaxIdle bounds the number of idle objects, DefaultMaxIdle otherwise.
+// It is split between the shards, and a Put tries the other shards when
+// the one of its P is full.
+func WithMaxIdle[T any](n int) Option[T] {
+	return func(p *Pool[T]) {
+		p.maxIdle = n
+	}
+}
+
+// WithMaxAge makes the pool discard objects idle for longer than d. Get
+// discards the expired objects it comes across; Evict looks for them in
+// every shard.
+func WithMaxAge[T any](d time.Duration) Option[T] {
+	return func(p *Pool[T]) {
+		p.maxAge = d
+	}
+}
+
+// WithShards sets the number of shards, GOMAXPROCS otherwise. There are
+// never more shards than idle objects.
+func WithShards[T any](n int) Option[T] {
+	return func(p *Pool[T]) {
+		p.shardCount = n
+	}
+}
+
+// NewPool returns an empty pool whose Get calls newObject when it finds no
+// idle object. It panics if newObject is nil, or the max idle size or the
+// number of shards is less than one.
+func NewPool[T any](newObject func() T, opts ...Option[T]) *Pool[T] {
+	if newObject == nil {
+		panic("pool: newObject must not be nil")
+	}
+	p := &Pool[T]{newObject: newObject, maxIdle: DefaultMaxIdle, shardCount: runtime.GOMAXPROCS(0), epoch: time.Now()}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.maxIdle < 1 {
+		panic("pool: max idle size must be at least one")
+	}
+	if p.shardCount < 1 {
+		panic("pool: number of shards must be at least one")
+	}
+	p.shards = make([]shard[T], min(p.shardCount, p.maxIdle))
+
+	// split maxIdle exactly: the first shards take the remainder
+	for i := range p.shards {
+		p.shards[i].capacity = p.maxIdle / len(p.shards)
+		if i < p.maxIdle%len(p.shards) {
+			p.shards[i].capacity++
+		}
+	}
+	p.hints.New = func() any {
+		hint := int(p.nextHint.Add(1)-1) % len(p.shards)
+		return &hint
+	}
+	return p
+}
+
+// home returns the index of the shard of the calling goroutine's P, most
+// of the time.
+func (p *Pool[T]) home() int {
+	hint := p.hints.Get().(*int)
+	home := *hint
+	p.hints.Put(hint)
+	return home
+}
+
+// Get takes an idle object from the shard of its P, or steals one from
+// another shard, or calls newObject if the pool is empty.
+func (p *Pool[T]) Get() T {
+	home := p.home()
+	for i := range p.shards {
+		s := &p.shards[(home+i)%len(p.shards)]
+		for it := s.pop(); it != nil; it = s.pop() {
+			if p.expired(it, p.now()) {
+				p.shards[home].evictions.Add(1)
+				p.drop(it.value)
+				continue
+			}
+			if i == 0 {
+				s.hits.Add(1)
+			} else {
+				p.shards[home].steals.Add(1)
+			}
+			return it.value
+		}
+	}
+	p.shards[home].news.Add(1)
+	return p.newObject()
+}
+
+// Put resets x and keeps it, in the shard of its P or the first one after
+// it with room. If the pool is full, it discards x.
+func (p *Pool[T]) Put(x T) {
+	if p.reset != nil {
+		p.reset(x)
+	}
+	home := p.home()
+	it := &item[T]{value: x, since: p.now()}
+	for i := range p.shards {
+		if p.shards[(home+i)%len(p.shards)].push(it) {
+			p.shards[home].puts.Add(1)
+			return
+		}
+	}
+	p.shards[home].drops.Add(1)
+	p.drop(x)
+}
+
+// Evict discards the objects idle for longer than the max age, and returns
+// how many it discarded. As Get only comes across the expired objects of
+// the shards it takes from, call Evict now and then, e.g. from a
+// time.Ticker, to release the objects of a pool that is hardly used.
+//
+// Evict takes every shard as a whole and puts the fresh objects back, so
+// meanwhile Gets may find them missing and make new objects.
+func (p *Pool[T]) Evict() int {
+	if p.maxAge <= 0 {
+		return 0
+	}
+	evicted := 0
+	for i := range p.shards {
+		s := &p.shards[i]
+		head := sched.LoadPointer(&s.head)
+		for !sched.CompareAndSwapPointer(&s.head, head, nil) {
+			head = sched.LoadPointer(&s.head)
+		}
+
+		now := p.now()
+		var fresh []*item[T]
+		for it := head; it != nil; it = sched.LoadPointer(&(*item[T])(it).next) {
+			if p.expired((*item[T])(it), now) {
+				s.evictions.Add(1)
+				p.drop((*item[T])(it).value)
+				evicted++
+			} else {
+				fresh = append(fresh, (*item[T])(it))
+			}
+		}
+		// push copies, oldest first: a pop that loaded an item before the
+		// shard was taken must not find it back on top
+		for j := len(fresh) - 1; j >= 0; j-- {
+			if !s.push(&item[T]{value: fresh[j].value, since: fresh[j].since}) {
+				s.drops.Add(1)
+				p.drop(fresh[j].value)
+			}
+		}
+	}
+	return evicted
+}
+
+func (p *Pool[T]) now() int64 {
+	if p.maxAge <= 0 {
+		return 0
+	}
+	return int64(time.Since(p.epoch))
+}
+
+func (p *Pool[T]) expired(it *item[T], now int64) bool {
+	return p.maxAge > 0 && now-it.since > int64(p.maxAge)
+}
+
+func (p *Pool[T]) drop(x T) {
+	if p.discard != nil {
+		p.discard(x)
+	}
+}
+
+// Idle returns the number of idle objects. It is O(shards), and under
+// concurrent Gets and Puts only an approximation.
+func (p *Pool[T]) Idle() int {
+	idle := 0
+	for i := range p.shards {
+		if head := sched.LoadPointer(&p.shards[i].head); head != nil {
+			idle += (*item[T])(head).depth
+		}
+	}
+	return idle
+}
+
+// Stats returns the counters of the pool. They are summed over the shards,
+// and not read at one instant.
+func (p *Pool[T]) Stats() Stats {
+	var st Stats
+	for i := range p.shards {
+		s := &p.shards[i]
+		st.Hits += s.hits.Load()
+		st.Steals += s.steals.Load()
+		st.News += s.news.Load()
+		st.Puts += s.puts.Load()
+		st.Drops += s.drops.Load()
+		st.Evictions += s.evictions.Load()
+	}
+	st.Idle = p.Idle()
+	return st
+}
@@ -0,0 +1,218 @@
+package pool
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type object struct {
+	id    int
+	dirty bool
+	// inUse is set while a goroutine holds the object, see TestPoolConcurrency
+	inUse atomic.Bool
+}
+
+// counter makes numbered objects and remembers the discarded ones.
+type counter struct {
+	made      atomic.Int64
+	mu        sync.Mutex
+	discarded []int
+}
+
+func (c *counter) new() *object {
+	return &object{id: int(c.made.Add(1))}
+}
+
+func (c *counter) discard(o *object) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.discarded = append(c.discarded, o.id)
+}
+
+func TestPool(t *testing.T) {
+	t.Run("Reuse", func(t *testing.T) {
+		c := &counter{}
+		p := NewPool(c.new, WithReset(func(o *object) { o.dirty = false }), WithShards[*object](1))
+
+		o := p.Get()
+		assert.Equal(t, 1, o.id)
+		o.dirty = true
+		p.Put(o)
+		assert.Equal(t, 1, p.Idle())
+
+		again := p.Get()
+		assert.Same(t, o, again)
+		assert.False(t, again.dirty, "Put must reset the object")
+		assert.Equal(t, 2, p.Get().id)
+		assert.Equal(t, Stats{Hits: 1, News: 2, Puts: 1}, p.Stats())
+	})
+
+	t.Run("Max idle", func(t *testing.T) {
+		c := &counter{}
+		p := NewPool(c.new, WithShards[*object](2), WithMaxIdle[*object](3), WithDiscard(c.discard))
+		objects := []*object{p.Get(), p.Get(), p.Get(), p.Get(), p.Get()}
+		for _, o := range objects {
+			p.Put(o)
+		}
+		assert.Equal(t, 3, p.Idle())
+		assert.Equal(t, []int{4, 5}, c.discarded)
+		stats := p.Stats()
+		assert.Equal(t, uint64(3), stats.Puts)
+		assert.Equal(t, uint64(2), stats.Drops)
+	})
+
+	t.Run("Fewer idle objects than shards", func(t *testing.T) {
+		p := NewPool((&counter{}).new, WithShards[*object](8), WithMaxIdle[*object](2))
+		assert.Len(t, p.shards, 2)
+		for i := 0; i < 3; i++ {
+			p.Put(&object{})
+		}
+		assert.Equal(t, 2, p.Idle())
+	})
+
+	t.Run("Steal", func(t *testing.T) {
+		c := &counter{}
+		p := NewPool(c.new, WithShards[*object](4))
+		for i := range p.shards {
+			require.True(t, p.shards[i].push(&item[*object]{value: &object{id: -i}}))
+		}
+		for range p.shards {
+			assert.LessOrEqual(t, p.Get().id, 0)
+		}
+		stats := p.Stats()
+		assert.Equal(t, uint64(len(p.shards)), stats.Hits+stats.Steals)
+		assert.Zero(t, stats.News)
+		assert.Equal(t, 0, p.Idle())
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		newObject := (&counter{}).new
+		assert.Panics(t, func() { NewPool[*object](nil) })
+		assert.Panics(t, func() { NewPool(newObject, WithMaxIdle[*object](0)) })
+		assert.PanicsWithValue(t, "pool: number of shards must be at least one", func() { NewPool(newObject, WithShards[*object](0)) })
+		assert.PanicsWithValue(t, "pool: number of shards must be at least one", func() { NewPool(newObject, WithShards[*object](-1)) })
+	})
+}
+
+func TestPoolMaxAge(t *testing.T) {
+	const maxAge = 20 * time.Millisecond
+
+	t.Run("Get", func(t *testing.T) {
+		c := &counter{}
+		p := NewPool(c.new, WithMaxAge[*object](maxAge), WithDiscard(c.discard), WithShards[*object](1))
+		p.Put(p.Get())
+		time.Sleep(2 * maxAge)
+
+		assert.Equal(t, 2, p.Get().id, "an expired object must not be reused")
+		assert.Equal(t, []int{1}, c.discarded)
+		assert.Equal(t, uint64(1), p.Stats().Evictions)
+	})
+
+	t.Run("Evict", func(t *testing.T) {
+		c := &counter{}
+		p := NewPool(c.new, WithMaxAge[*object](maxAge), WithDiscard(c.discard), WithShards[*object](2))
+		old := []*object{p.Get(), p.Get(), p.Get()}
+		for _, o := range old {
+			p.Put(o)
+		}
+		time.Sleep(2 * maxAge)
+		fresh := &object{id: 4}
+		p.Put(fresh)
+
+		assert.Equal(t, 3, p.Evict())
+		assert.ElementsMatch(t, []int{1, 2, 3}, c.discarded)
+		assert.Equal(t, 1, p.Idle())
+		assert.Same(t, fresh, p.Get())
+		assert.Equal(t, 0, p.Evict())
+	})
+
+	t.Run("Without max age", func(t *testing.T) {
+		p := NewPool((&counter{}).new)
+		p.Put(p.Get())
+		assert.Equal(t, 0, p.Evict())
+		assert.Equal(t, 1, p.Idle())
+	})
+}
+
+// goroutines get and put objects while Evict runs; an object is never held
+// by two goroutines at once, and the pool stays within its max idle size
+func TestPoolConcurrency(t *testing.T) {
+	const (
+		goroutines = 8
+		rounds     = 2000
+		maxIdle    = 16
+	)
+
+	c := &counter{}
+	p := NewPool(c.new, WithMaxIdle[*object](maxIdle), WithMaxAge[*object](time.Millisecond), WithDiscard(c.discard))
+	done := make(chan struct{})
+	evicting := sync.WaitGroup{}
+	evicting.Add(1)
+	go func() {
+		defer evicting.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				p.Evict()
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	wg.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func() {
+			defer wg.Done()
+			held := make([]*object, 0, 4)
+			for i := 0; i < rounds; i++ {
+				for len(held) < 1+i%4 {
+					o := p.Get()
+					assert.True(t, o.inUse.CompareAndSwap(false, true), "object %d handed out twice", o.id)
+					held = append(held, o)
+				}
+				for _, o := range held {
+					o.inUse.Store(false)
+					p.Put(o)
+				}
+				held = held[:0]
+				assert.LessOrEqual(t, p.Idle(), maxIdle)
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	evicting.Wait()
+
+	stats := p.Stats()
+	assert.Equal(t, uint64(c.made.Load()), stats.News)
+	assert.Equal(t, int(stats.Drops+stats.Evictions), len(c.discarded))
+	assert.Equal(t, stats.News, stats.Drops+stats.Evictions+uint64(stats.Idle), "objects must not be lost")
+}
+
+func BenchmarkPool(b *testing.B) {
+	b.Run("Pool", func(b *testing.B) {
+		p := NewPool(func() *[]byte { buf := make([]byte, 1024); return &buf })
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				p.Put(p.Get())
+			}
+		})
+	})
+
+	b.Run("sync.Pool", func(b *testing.B) {
+		p := sync.Pool{New: func() any { buf := make([]byte, 1024); return &buf }}
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				p.Put(p.Get())
+			}
+		})
+	})
+}
@@ -0,0 +1,343 @@
+package queue
+
+import (
+	"context"
+	"unsafe"
+
+	"github.com/peletor/treiber/backoff"
+	"github.com/peletor/treiber/internal/nocopy"
+	"github.com/peletor/treiber/internal/pad"
+	"github.com/peletor/treiber/internal/sched"
+	"github.com/peletor/treiber/internal/stats"
+	"github.com/peletor/treiber/tracing"
+)
+
+// dualItem is a value, or the reservation of a waiting PopWait. The value
+// for a reservation arrives in item, and ready is closed after it. A value
+// of TransferQueue.Transfer has a ready too, closed when a consumer took
+// it.
+type dualItem struct {
+	isData bool
+	value  int
+	item   unsafe.Pointer
+	next   unsafe.Pointer
+	ready  chan struct{}
+}
+
+// cancelled is the item of a reservation whose PopWait gave up, or of a
+// value whose Transfer gave up; taken is the item of a transferred value.
+var (
+	cancelled = unsafe.Pointer(new(int))
+	taken     = unsafe.Pointer(new(int))
+)
+
+// DualQueue is the dual queue of Scherer and Scott (DISC 2004): a
+// Michael-Scott queue that holds either values or reservations. A PopWait
+// on a queue without values appends a reservation and waits on it, and a
+// Push hands its value to the first reservation instead of appending it.
+// Waiting consumers are served in the order they came, which makes it the
+// fair counterpart of stack.DualStack; there is no unfair mode, the choice
+// of mode is the choice between the two.
+//
+// The zero DualQueue is empty and ready to use; it must not be copied
+// after first use.
+type DualQueue struct {
+	_    nocopy.NoCopy
+	_    pad.CacheLinePad
+	head unsafe.Pointer
+	_    pad.CacheLinePad
+	tail unsafe.Pointer
+	_    pad.CacheLinePad
+
+	stats   stats.Counters
+	backoff backoff.Backoff
+	tracer  tracing.Tracer
+}
+
+// NewDualQueue takes the options of NewQueue: WithBackoff and WithTracer
+// configure the DualQueue as they do a Queue. It panics on WithPaddedNodes,
+// which a DualQueue does not support.
+func NewDualQueue(opts ...Option) *DualQueue {
+	q := &DualQueue{}
+	q.configure(opts)
+	q.init()
+	return q
+}
+
+// configure applies opts to a Queue and takes over its settings.
+func (q *DualQueue) configure(opts []Option) {
+	var settings Queue
+	for _, opt := range opts {
+		opt(&settings)
+	}
+	if settings.paddedNodes {
+		panic("queue: DualQueue does not support WithPaddedNodes")
+	}
+	q.backoff = settings.backoff
+	q.tracer = settings.tracer
+}
+
+// init installs the sentinel item of a zero DualQueue, as Queue.init.
+func (q *DualQueue) init() {
+	if sched.LoadPointer(&q.tail) != nil {
+		return
+	}
+	sched.CompareAndSwapPointer(&q.head, nil, unsafe.Pointer(&dualItem{}))
+	sched.CompareAndSwapPointer(&q.tail, nil, sched.LoadPointer(&q.head))
+}
+
+// Push gives value to the longest waiting PopWait, or appends it if none
+// is waiting.
+func (q *DualQueue) Push(value int) {
+	span := tracing.Start(q.tracer, "DualQueue.Push")
+	defer tracing.End(span)
+
+	q.put(span, &dualItem{isData: true, value: value}, true)
+}
+
+// put hands the value of item to the first reservation, or appends item if
+// the queue holds no reservations and enqueue is set. It reports whether it
+// handed the value over.
+func (q *DualQueue) put(span tracing.Span, item *dualItem, enqueue bool) bool {
+	q.init()
+	for attempt := 0; ; attempt++ {
+		tail := sched.LoadPointer(&q.tail)
+		head := sched.LoadPointer(&q.head)
+
+		if head == tail || (*dualItem)(tail).isData {
+			// empty or values only: append like Queue.Push
+			if !enqueue {
+				if q.isLast(tail) {
+					return false
+				}
+			} else if item.ready == nil || head == tail || !q.skipCancelled(head) {
+				if q.append(tail, item) {
+					q.stats.Push()
+					return false
+				}
+			}
+			q.retry(span, attempt)
+			continue
+		}
+
+		// reservations only: fulfil the first
+		next := sched.LoadPointer(&(*dualItem)(head).next)
+		if tail != sched.LoadPointer(&q.tail) || next == nil || head != sched.LoadPointer(&q.head) {
+			q.retry(span, attempt)
+			continue
+		}
+		r := (*dualItem)(next)
+		fulfilled := sched.CompareAndSwapPointer(&r.item, nil, unsafe.Pointer(&item.value))
+		// the reservation is done with, fulfilled by this push or another
+		// one, or cancelled
+		sched.CompareAndSwapPointer(&q.head, head, next)
+		if fulfilled {
+			q.stats.Push()
+			close(r.ready)
+			return true
+		}
+		q.stats.CASFailure()
+		q.retry(span, attempt)
+	}
+}
+
+// isLast reports whether tail is the last item, and helps a lagging tail
+// otherwise.
+func (q *DualQueue) isLast(tail unsafe.Pointer) bool {
+	next := sched.LoadPointer(&(*dualItem)(tail).next)
+	if tail != sched.LoadPointer(&q.tail) {
+		return false
+	}
+	if next != nil {
+		// try to fix queue tail
+		sched.CompareAndSwapPointer(&q.tail, tail, next)
+		q.stats.Helping()
+		return false
+	}
+	return true
+}
+
+// append links newItem after tail if tail is the last item, and helps a
+// lagging tail otherwise. It reports whether it linked newItem.
+func (q *DualQueue) append(tail unsafe.Pointer, newItem *dualItem) bool {
+	if !q.isLast(tail) {
+		return false
+	}
+	if !sched.CompareAndSwapPointer(&(*dualItem)(tail).next, nil, unsafe.Pointer(newItem)) {
+		q.stats.CASFailure()
+		return false
+	}
+	// try to move queue tail
+	sched.CompareAndSwapPointer(&q.tail, tail, unsafe.Pointer(newItem))
+	return true
+}
+
+// Pop removes the value at the head without waiting. ok is false if the
+// queue holds no values.
+func (q *DualQueue) Pop() (value int, ok bool) {
+	span := tracing.Start(q.tracer, "DualQueue.Pop")
+	defer tracing.End(span)
+
+	q.init()
+	for attempt := 0; ; attempt++ {
+		tail := sched.LoadPointer(&q.tail)
+		head := sched.LoadPointer(&q.head)
+		next := sched.LoadPointer(&(*dualItem)(head).next)
+
+		if tail == sched.LoadPointer(&q.tail) && head == sched.LoadPointer(&q.head) {
+			if head == tail {
+				if next == nil {
+					q.stats.EmptyPop()
+					return 0, false
+				}
+				// fix queue tail
+				sched.CompareAndSwapPointer(&q.tail, tail, next)
+				q.stats.Helping()
+			} else if !(*dualItem)(tail).isData {
+				// consumers are waiting already
+				q.stats.EmptyPop()
+				return 0, false
+			} else if q.take(head, next) {
+				return (*dualItem)(next).value, true
+			}
+		}
+		q.retry(span, attempt)
+	}
+}
+
+// PopWait removes the value at the head, waiting for a Push if the queue
+// holds no values. It returns ctx.Err() if ctx is done first.
+func (q *DualQueue) PopWait(ctx context.Context) (value int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	span := tracing.Start(q.tracer, "DualQueue.PopWait")
+	defer tracing.End(span)
+
+	q.init()
+	var r *dualItem
+	for attempt := 0; ; attempt++ {
+		tail := sched.LoadPointer(&q.tail)
+		head := sched.LoadPointer(&q.head)
+
+		if head == tail || !(*dualItem)(tail).isData {
+			if head == tail || !q.skipCancelled(head) {
+				// empty or reservations only: append a reservation and wait
+				if r == nil {
+					r = &dualItem{ready: make(chan struct{})}
+				}
+				if q.append(tail, r) {
+					value, err := r.wait(ctx)
+					if err == nil {
+						q.stats.Pop()
+					}
+					return value, err
+				}
+			}
+			q.retry(span, attempt)
+			continue
+		}
+
+		next := sched.LoadPointer(&(*dualItem)(head).next)
+		if tail == sched.LoadPointer(&q.tail) && next != nil && head == sched.LoadPointer(&q.head) && q.take(head, next) {
+			return (*dualItem)(next).value, nil
+		}
+		q.retry(span, attempt)
+	}
+}
+
+// take moves head to the value next and claims it.
+func (q *DualQueue) take(head, next unsafe.Pointer) bool {
+	if !sched.CompareAndSwapPointer(&q.head, head, next) {
+		q.stats.CASFailure()
+		return false
+	}
+	if !(*dualItem)(next).claim() {
+		return false
+	}
+	q.stats.Pop()
+	return true
+}
+
+func (q *DualQueue) retry(span tracing.Span, attempt int) {
+	tracing.Retry(span, attempt)
+	if q.backoff != nil {
+		q.backoff.Wait(attempt)
+	}
+}
+
+// skipCancelled moves head past the first item if it is cancelled, so the
+// reservations of PopWaits, or values of Transfers, that time out while the
+// other side does not come do not pile up. It reports whether it found one.
+func (q *DualQueue) skipCancelled(head unsafe.Pointer) bool {
+	next := sched.LoadPointer(&(*dualItem)(head).next)
+	if next == nil || sched.LoadPointer(&(*dualItem)(next).item) != cancelled {
+		return false
+	}
+	sched.CompareAndSwapPointer(&q.head, head, next)
+	q.stats.Helping()
+	return true
+}
+
+// claim takes the value of item for the consumer that moved head to it.
+// It fails for the value of a cancelled TransferQueue.Transfer, and
+// releases a waiting one.
+func (item *dualItem) claim() bool {
+	if item.ready == nil {
+		// pushed, nobody waits
+		return true
+	}
+	if !sched.CompareAndSwapPointer(&item.item, nil, taken) {
+		return false
+	}
+	close(item.ready)
+	return true
+}
+
+// wait waits for a Push to fulfil the reservation r, or cancels it when
+// ctx is done. A cancelled reservation stays in the queue until a Push or
+// a PopWait skips it.
+func (r *dualItem) wait(ctx context.Context) (int, error) {
+	select {
+	case <-r.ready:
+	case <-ctx.Done():
+		if sched.CompareAndSwapPointer(&r.item, nil, cancelled) {
+			return 0, ctx.Err()
+		}
+		// fulfilled meanwhile, the value is ours
+	}
+	return *(*int)(sched.LoadPointer(&r.item)), nil
+}
+
+// Len counts the values by walking the queue. It is O(n), and under
+// concurrent pushes and pops the result is only an approximation.
+func (q *DualQueue) Len() int {
+	return q.count(true)
+}
+
+// Waiting counts the PopWaits waiting for a value, with the same caveats
+// as Len.
+func (q *DualQueue) Waiting() int {
+	return q.count(false)
+}
+
+func (q *DualQueue) count(isData bool) int {
+	length := 0
+	head := sched.LoadPointer(&q.head)
+	if head == nil {
+		return 0
+	}
+	for item := sched.LoadPointer(&(*dualItem)(head).next); item != nil; item = sched.LoadPointer(&(*dualItem)(item).next) {
+		i := (*dualItem)(item)
+		if i.isData == isData && sched.LoadPointer(&i.item) == nil {
+			length++
+		}
+	}
+	return length
+}
+
+// Stats returns a snapshot of the counters of the queue.
+// The counters are only maintained when built with the treiberstats tag.
+func (q *DualQueue) Stats() Stats {
+	return q.stats.Snapshot()
+}
@@ -0,0 +1,41 @@
+package queue
+
+import "github.com/peletor/treiber/internal/snapshot"
+
+// MarshalBinary encodes Snapshot, head first. gob uses it too, as it uses
+// UnmarshalBinary, which pushes the values so they pop in the same order
+// again. Decode into a new Queue: the values of a non-empty one are kept, and
+// mixed with the decoded ones.
+func (q *Queue) MarshalBinary() ([]byte, error) {
+	return snapshot.MarshalBinary(q.Snapshot()), nil
+}
+
+func (q *Queue) UnmarshalBinary(data []byte) error {
+	values, err := snapshot.UnmarshalBinary(data)
+	if err != nil {
+		return err
+	}
+	q.restore(values)
+	return nil
+}
+
+// MarshalJSON encodes Snapshot as a JSON array, head first.
+func (q *Queue) MarshalJSON() ([]byte, error) {
+	return snapshot.MarshalJSON(q.Snapshot())
+}
+
+func (q *Queue) UnmarshalJSON(data []byte) error {
+	values, err := snapshot.UnmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+	q.restore(values)
+	return nil
+}
+
+// restore pushes values, given head first.
+func (q *Queue) restore(values []int) {
+	for _, value := range values {
+		q.Push(value)
+	}
+}
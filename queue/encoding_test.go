@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestQueueSnapshot(t *testing.T) {
+	que := NewQueue()
+	assert.Empty(t, que.Snapshot())
+	assert.Empty(t, (&Queue{}).Snapshot())
+
+	que.Push(1)
+	que.Push(2)
+	que.Push(3)
+	que.Pop()
+	assert.Equal(t, []int{2, 3}, que.Snapshot())
+	assert.Equal(t, 2, que.Len())
+	assert.Equal(t, []int{2}, que.Preview(1))
+	assert.Equal(t, []int{2, 3}, que.Preview(10))
+	assert.Empty(t, (&Queue{}).Preview(10))
+}
+
+func TestQueueEncoding(t *testing.T) {
+	newQueue := func() *Queue {
+		que := NewQueue()
+		for i := 0; i < 10; i++ {
+			que.Push(i)
+		}
+		return que
+	}
+	popAll := func(que *Queue) []int {
+		var values []int
+		for value, ok := que.Pop(); ok; value, ok = que.Pop() {
+			values = append(values, value)
+		}
+		return values
+	}
+	want := popAll(newQueue())
+
+	t.Run("Binary", func(t *testing.T) {
+		data, err := newQueue().MarshalBinary()
+		require.NoError(t, err)
+		restored := NewQueue()
+		require.NoError(t, restored.UnmarshalBinary(data))
+		assert.Equal(t, want, popAll(restored))
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		data, err := json.Marshal(newQueue())
+		require.NoError(t, err)
+		assert.JSONEq(t, "[0,1,2,3,4,5,6,7,8,9]", string(data))
+		restored := &Queue{}
+		require.NoError(t, json.Unmarshal(data, restored))
+		assert.Equal(t, want, popAll(restored))
+	})
+
+	t.Run("gob", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		require.NoError(t, gob.NewEncoder(&buf).Encode(newQueue()))
+		restored := NewQueue()
+		require.NoError(t, gob.NewDecoder(&buf).Decode(restored))
+		assert.Equal(t, want, popAll(restored))
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		data, err := json.Marshal(NewQueue())
+		require.NoError(t, err)
+		assert.Equal(t, "[]", string(data))
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		que := NewQueue()
+		assert.Error(t, que.UnmarshalBinary([]byte{1, 5}))
+		assert.Error(t, que.UnmarshalJSON([]byte(`"x"`)))
+		assert.Zero(t, que.Len())
+	})
+}
+
+// a producer pushes 0, 1, 2, ... and a consumer pops, so every state of the
+// queue is an ascending run
+func TestQueueSnapshotConcurrency(t *testing.T) {
+	const count = 2000
+
+	que := NewQueue()
+	done := atomic.Bool{}
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < count; i++ {
+			que.Push(i)
+		}
+		done.Store(true)
+	}()
+	go func() {
+		defer wg.Done()
+		for !done.Load() || que.Len() > 0 {
+			que.Pop()
+		}
+	}()
+
+	for !done.Load() {
+		values := que.Snapshot()
+		for i := 1; i < len(values); i++ {
+			if !assert.Equal(t, values[0]+i, values[i], "%v", values) {
+				return
+			}
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,162 @@
+package queue
+
+import (
+	"fmt"
+	"github.com/peletor/treiber/internal/linearize"
+	"slices"
+	"sync"
+	"testing"
+)
+
+const (
+	opPush = iota
+	opPop
+	opCount
+)
+
+type fuzzOp struct {
+	kind  byte
+	value int
+}
+
+func (op fuzzOp) String() string {
+	if op.kind == opPush {
+		return fmt.Sprintf("Push(%d)", op.value)
+	}
+	return "Pop()"
+}
+
+type result struct {
+	value int
+	ok    bool
+}
+
+// decodeOps reads one operation per byte; a Push takes its value from the
+// byte that follows.
+func decodeOps(data []byte) []fuzzOp {
+	var ops []fuzzOp
+	for i := 0; i < len(data); i++ {
+		op := fuzzOp{kind: data[i] % opCount}
+		if op.kind == opPush && i+1 < len(data) {
+			i++
+			op.value = int(data[i])
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+func encodeOps(ops ...fuzzOp) []byte {
+	var data []byte
+	for _, op := range ops {
+		data = append(data, op.kind)
+		if op.kind == opPush {
+			data = append(data, byte(op.value))
+		}
+	}
+	return data
+}
+
+// addSeeds adds the scenarios of TestQueue to the corpus.
+func addSeeds(f *testing.F) {
+	const count = 50
+	var pushes, pops, pairs []fuzzOp
+	for i := 0; i < count; i++ {
+		push := fuzzOp{kind: opPush, value: i}
+		pushes = append(pushes, push)
+		pops = append(pops, fuzzOp{kind: opPop})
+		pairs = append(pairs, push, fuzzOp{kind: opPop})
+	}
+
+	f.Add(encodeOps(fuzzOp{kind: opPush, value: 5}, fuzzOp{kind: opPop}))
+	f.Add(encodeOps(fuzzOp{kind: opPop}))
+	f.Add(encodeOps(slices.Concat(pushes, pops)...))
+	f.Add(encodeOps(slices.Concat(pushes, pops, pops[:1])...))
+	f.Add(encodeOps(slices.Concat(pushes, pops[:1])...))
+	f.Add(encodeOps(slices.Concat(pairs, pops[:1])...))
+}
+
+// model is the sequential reference: a slice with its head at index 0.
+type model []int
+
+func (m model) apply(op fuzzOp) (model, result) {
+	if op.kind == opPush {
+		return append(slices.Clip(m), op.value), result{}
+	}
+	if len(m) == 0 {
+		return m, result{}
+	}
+	return m[1:], result{m[0], true}
+}
+
+func apply(que *Queue, op fuzzOp) result {
+	if op.kind == opPush {
+		que.Push(op.value)
+		return result{}
+	}
+	value, ok := que.Pop()
+	return result{value, ok}
+}
+
+func FuzzQueue(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		que := NewQueue()
+		var m model
+		for i, op := range decodeOps(data) {
+			var want result
+			m, want = m.apply(op)
+			if got := apply(que, op); got != want {
+				t.Fatalf("op %d %v: got %v, model %v", i, op, got, want)
+			}
+			if que.Len() != len(m) {
+				t.Fatalf("op %d %v: Len %d, model %d", i, op, que.Len(), len(m))
+			}
+		}
+	})
+}
+
+var queueModel = linearize.Model[model, fuzzOp, result]{
+	Init: func() model { return nil },
+	Step: func(m model, op fuzzOp, got result) (bool, model) {
+		next, want := m.apply(op)
+		return got == want, next
+	},
+	Key: func(m model) string { return fmt.Sprint([]int(m)) },
+}
+
+// maxConcurrentOps keeps the linearizability check, which is exponential in
+// the worst case, fast.
+const maxConcurrentOps = 64
+
+// FuzzQueueConcurrent deals the operations round robin to 2 to 4 goroutines,
+// depending on the length of the input.
+func FuzzQueueConcurrent(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		goroutines := 2 + len(data)%3
+		ops := decodeOps(data)
+		ops = ops[:min(len(ops), maxConcurrentOps)]
+
+		que := NewQueue()
+		clock := linearize.Clock{}
+		history := make([]linearize.Operation[fuzzOp, result], len(ops))
+		wg := sync.WaitGroup{}
+		wg.Add(goroutines)
+		for g := 0; g < goroutines; g++ {
+			go func(g int) {
+				defer wg.Done()
+				for i := g; i < len(ops); i += goroutines {
+					call := clock.Now()
+					got := apply(que, ops[i])
+					history[i] = linearize.Operation[fuzzOp, result]{Input: ops[i], Output: got, Call: call, Return: clock.Now()}
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		if !linearize.Check(queueModel, history) {
+			t.Fatalf("history is not linearizable: %v", history)
+		}
+	})
+}
@@ -1,8 +1,15 @@
 package queue
 
 import (
-	"sync/atomic"
 	"unsafe"
+
+	"github.com/peletor/treiber/backoff"
+	"github.com/peletor/treiber/internal/kcas"
+	"github.com/peletor/treiber/internal/nocopy"
+	"github.com/peletor/treiber/internal/pad"
+	"github.com/peletor/treiber/internal/sched"
+	"github.com/peletor/treiber/internal/stats"
+	"github.com/peletor/treiber/tracing"
 )
 
 type queueItem struct {
@@ -10,65 +17,259 @@ type queueItem struct {
 	next  unsafe.Pointer
 }
 
+// paddedQueueItem fills a whole cache line, so neighbouring nodes are never
+// written through the same line.
+type paddedQueueItem struct {
+	queueItem
+	_ [pad.CacheLineSize - unsafe.Sizeof(queueItem{})]byte
+}
+
+// Queue keeps head and tail on separate cache lines: consumers only write
+// head and producers only write tail. The zero Queue is empty and ready to
+// use, its sentinel item is installed by the first operation; it must not
+// be copied after first use.
 type Queue struct {
+	_    nocopy.NoCopy
+	_    pad.CacheLinePad
 	head unsafe.Pointer
+	_    pad.CacheLinePad
 	tail unsafe.Pointer
+	_    pad.CacheLinePad
+
+	stats       stats.Counters
+	paddedNodes bool
+	backoff     backoff.Backoff
+	tracer      tracing.Tracer
+}
+
+// Stats is a snapshot of the counters of a container.
+// The counters are only maintained when built with the treiberstats tag.
+type Stats = stats.Snapshot
+
+// Option configures a Queue created by NewQueue.
+type Option func(*Queue)
+
+// WithPaddedNodes allocates every item on its own cache line.
+// It costs memory, but adjacent items are no longer falsely shared.
+func WithPaddedNodes() Option {
+	return func(q *Queue) {
+		q.paddedNodes = true
+	}
+}
+
+// WithBackoff makes every CAS loop of the queue wait with b before retrying.
+func WithBackoff(b backoff.Backoff) Option {
+	return func(q *Queue) {
+		q.backoff = b
+	}
+}
+
+// WithTracer reports the start, retries and end of every operation to t.
+func WithTracer(t tracing.Tracer) Option {
+	return func(q *Queue) {
+		q.tracer = t
+	}
+}
+
+func NewQueue(opts ...Option) *Queue {
+	q := &Queue{}
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	firstItem := q.newItem(0)
+	q.head = firstItem
+	q.tail = firstItem
+	return q
+}
+
+// init installs the sentinel item of a zero Queue. Operations call it
+// before anything else, so head cannot move while tail is still nil, and
+// tail is set from nil exactly once.
+func (q *Queue) init() {
+	if sched.LoadPointer(&q.tail) != nil {
+		return
+	}
+	sched.CompareAndSwapPointer(&q.head, nil, q.newItem(0))
+	sched.CompareAndSwapPointer(&q.tail, nil, kcas.Load(&q.head))
 }
 
-func NewQueue() Queue {
-	firstItem := &queueItem{}
-	return Queue{
-		head: unsafe.Pointer(firstItem),
-		tail: unsafe.Pointer(firstItem),
+func (q *Queue) newItem(value int) unsafe.Pointer {
+	if q.paddedNodes {
+		item := &paddedQueueItem{queueItem: queueItem{value: value}}
+		return unsafe.Pointer(&item.queueItem)
 	}
+	return unsafe.Pointer(&queueItem{value: value})
 }
 
 func (q *Queue) Push(value int) {
-	newItem := unsafe.Pointer(&queueItem{value: value})
+	span := tracing.Start(q.tracer, "Queue.Push")
+	defer tracing.End(span)
+
+	q.init()
+	q.push(span, q.newItem(value))
+}
 
-	for {
-		tail := atomic.LoadPointer(&q.tail)
-		next := atomic.LoadPointer(&(*queueItem)(tail).next)
+// push links newItem after the last item. The queue must be initialized.
+func (q *Queue) push(span tracing.Span, newItem unsafe.Pointer) {
+	for attempt := 0; ; attempt++ {
+		tail := sched.LoadPointer(&q.tail)
+		next := kcas.Load(&(*queueItem)(tail).next)
 
 		// if queue tail is not changed in other goroutine
-		if tail == atomic.LoadPointer(&q.tail) {
+		if tail == sched.LoadPointer(&q.tail) {
 			if next == nil {
-				if atomic.CompareAndSwapPointer(&(*queueItem)(tail).next, next, newItem) {
+				if sched.CompareAndSwapPointer(&(*queueItem)(tail).next, next, newItem) {
 					// try to move queue tail
-					atomic.CompareAndSwapPointer(&q.tail, tail, newItem)
+					sched.CompareAndSwapPointer(&q.tail, tail, newItem)
+					q.stats.Push()
 					return
 				}
+				q.stats.CASFailure()
 			} else {
 				// try to fix queue tail
-				atomic.CompareAndSwapPointer(&q.tail, tail, next)
+				sched.CompareAndSwapPointer(&q.tail, tail, next)
+				q.stats.Helping()
 			}
 		}
+		q.retry(span, attempt)
 	}
 }
 
 func (q *Queue) Pop() (value int, ok bool) {
-	for {
-		head := atomic.LoadPointer(&q.head)
-		tail := atomic.LoadPointer(&q.tail)
-		next := atomic.LoadPointer(&(*queueItem)(head).next)
+	span := tracing.Start(q.tracer, "Queue.Pop")
+	defer tracing.End(span)
+
+	q.init()
+	for attempt := 0; ; attempt++ {
+		head := kcas.Load(&q.head)
+		tail := sched.LoadPointer(&q.tail)
+		next := kcas.Load(&(*queueItem)(head).next)
 
 		// if queue head is not changed in other goroutine
-		if head == atomic.LoadPointer(&q.head) {
+		if head == kcas.Load(&q.head) {
 			if head == tail {
 				if next == nil {
 					// queue is empty
+					q.stats.EmptyPop()
 					return 0, false
 				} else {
 					// fix queue tail
-					atomic.CompareAndSwapPointer(&q.tail, tail, next)
+					sched.CompareAndSwapPointer(&q.tail, tail, next)
+					q.stats.Helping()
 				}
 			} else {
 				value := (*queueItem)(next).value
-				if atomic.CompareAndSwapPointer(&q.head, head, next) {
+				if sched.CompareAndSwapPointer(&q.head, head, next) {
 					// head has been changed successfully
+					q.stats.Pop()
 					return value, true
 				}
+				q.stats.CASFailure()
+			}
+		}
+		q.retry(span, attempt)
+	}
+}
+
+// Peek returns the value at the head of the queue without removing it.
+func (q *Queue) Peek() (value int, ok bool) {
+	span := tracing.Start(q.tracer, "Queue.Peek")
+	defer tracing.End(span)
+
+	for attempt := 0; ; attempt++ {
+		head := kcas.Load(&q.head)
+		if head == nil {
+			// zero Queue, not used yet
+			return 0, false
+		}
+		next := kcas.Load(&(*queueItem)(head).next)
+
+		// if queue head is not changed in other goroutine
+		if head == kcas.Load(&q.head) {
+			if next == nil {
+				return 0, false
+			}
+			return (*queueItem)(next).value, true
+		}
+		q.retry(span, attempt)
+	}
+}
+
+// Len counts the items by walking the queue. It is O(n), and under
+// concurrent pushes and pops the result is only an approximation.
+func (q *Queue) Len() int {
+	length := 0
+	head := kcas.Load(&q.head)
+	if head == nil {
+		return 0
+	}
+	for item := kcas.Load(&(*queueItem)(head).next); item != nil; item = kcas.Load(&(*queueItem)(item).next) {
+		length++
+	}
+	return length
+}
+
+// Snapshot returns the values of the queue, head first. It is linearizable:
+// a walk from head to tail is only returned if head did not move between
+// loading it and finding tail to be the last item, so at that moment the
+// queue held exactly the items walked. It retries while pops keep moving
+// head.
+func (q *Queue) Snapshot() []int {
+	span := tracing.Start(q.tracer, "Queue.Snapshot")
+	defer tracing.End(span)
+
+	q.init()
+	for attempt := 0; ; attempt++ {
+		head := kcas.Load(&q.head)
+		tail := sched.LoadPointer(&q.tail)
+		next := kcas.Load(&(*queueItem)(tail).next)
+		if next != nil {
+			// fix queue tail
+			sched.CompareAndSwapPointer(&q.tail, tail, next)
+			q.stats.Helping()
+		} else if head == kcas.Load(&q.head) {
+			var values []int
+			for item := head; item != tail; {
+				item = kcas.Load(&(*queueItem)(item).next)
+				values = append(values, (*queueItem)(item).value)
 			}
+			return values
 		}
+		q.retry(span, attempt)
+	}
+}
+
+// Preview returns up to n values from the head of the queue, head first.
+// Like Len it walks the items without checks, so under concurrent pops it
+// may include values popped during the walk.
+func (q *Queue) Preview(n int) []int {
+	head := kcas.Load(&q.head)
+	if head == nil {
+		return nil
+	}
+	var values []int
+	for item := kcas.Load(&(*queueItem)(head).next); item != nil && len(values) < n; item = kcas.Load(&(*queueItem)(item).next) {
+		values = append(values, (*queueItem)(item).value)
+	}
+	return values
+}
+
+// Stats returns the counters of the queue.
+func (q *Queue) Stats() Stats {
+	return q.stats.Snapshot()
+}
+
+// PublishExpvar exports Stats as the expvar variable name.
+// It does nothing unless built with the treiberstats tag.
+func (q *Queue) PublishExpvar(name string) {
+	stats.Publish(name, q.Stats)
+}
+
+// retry is called at the end of every failed iteration of a CAS loop.
+func (q *Queue) retry(span tracing.Span, attempt int) {
+	tracing.Retry(span, attempt)
+	if q.backoff != nil {
+		q.backoff.Wait(attempt)
 	}
 }
@@ -1,9 +1,18 @@
 package queue
 
 import (
+	"fmt"
+	"github.com/peletor/treiber"
+	"github.com/peletor/treiber/backoff"
+	"github.com/peletor/treiber/containertest"
+	"github.com/peletor/treiber/internal/sched"
+	"github.com/peletor/treiber/internal/stats"
+	"github.com/peletor/treiber/tracing"
 	"github.com/stretchr/testify/assert"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"unsafe"
 )
 
 func TestQueue(t *testing.T) {
@@ -51,6 +60,48 @@ func TestQueue(t *testing.T) {
 		assert.Equal(t, null, result)
 	})
 
+	t.Run("Push Pop several times with padded nodes", func(t *testing.T) {
+		que := NewQueue(WithPaddedNodes())
+		for i := 0; i < count; i++ {
+			que.Push(i)
+		}
+		for i := 0; i < count; i++ {
+			result, ok := que.Pop()
+			assert.True(t, ok)
+			assert.Equal(t, i, result)
+		}
+	})
+
+	t.Run("Len", func(t *testing.T) {
+		que := NewQueue()
+		assert.Equal(t, 0, que.Len())
+		for i := 0; i < count; i++ {
+			que.Push(i)
+		}
+		assert.Equal(t, count, que.Len())
+		que.Pop()
+		assert.Equal(t, count-1, que.Len())
+	})
+
+	t.Run("Peek", func(t *testing.T) {
+		que := NewQueue()
+		_, ok := que.Peek()
+		assert.False(t, ok)
+		_, ok = (&Queue{}).Peek()
+		assert.False(t, ok)
+
+		que.Push(1)
+		que.Push(2)
+		result, ok := que.Peek()
+		assert.True(t, ok)
+		assert.Equal(t, 1, result)
+		que.Pop()
+		result, ok = que.Peek()
+		assert.True(t, ok)
+		assert.Equal(t, 2, result)
+		assert.Equal(t, 1, que.Len())
+	})
+
 	t.Run("Pop after several Push/Pop", func(t *testing.T) {
 		que := NewQueue()
 		for i := 0; i < count; i++ {
@@ -63,6 +114,114 @@ func TestQueue(t *testing.T) {
 	})
 }
 
+func TestQueueConformance(t *testing.T) {
+	t.Run("NewQueue", func(t *testing.T) {
+		containertest.TestContainer(t, containertest.FIFO, func() treiber.Container[int] { return NewQueue() }, containertest.Int)
+	})
+
+	t.Run("Zero value", func(t *testing.T) {
+		containertest.TestContainer(t, containertest.FIFO, func() treiber.Container[int] { return &Queue{} }, containertest.Int)
+	})
+}
+
+func TestQueueZeroValue(t *testing.T) {
+	t.Run("Push-Pop", func(t *testing.T) {
+		var que Queue
+		assert.Equal(t, 0, que.Len())
+		que.Push(1)
+		result, ok := que.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, 1, result)
+	})
+
+	t.Run("Empty Pop", func(t *testing.T) {
+		var que Queue
+		_, ok := que.Pop()
+		assert.False(t, ok)
+	})
+
+	// every goroutine may be the one installing the sentinel
+	t.Run("Concurrent first use", func(t *testing.T) {
+		const goroutines = 8
+		for run := 0; run < 200; run++ {
+			que := &Queue{}
+			popped := atomic.Int64{}
+			start := make(chan struct{})
+			wg := sync.WaitGroup{}
+			wg.Add(goroutines)
+			for g := 0; g < goroutines; g++ {
+				go func(g int) {
+					defer wg.Done()
+					<-start
+					if g%2 == 0 {
+						if _, ok := que.Pop(); ok {
+							popped.Add(1)
+						}
+					}
+					que.Push(g)
+				}(g)
+			}
+			close(start)
+			wg.Wait()
+
+			cnt := int(popped.Load())
+			for _, ok := que.Pop(); ok; _, ok = que.Pop() {
+				cnt++
+			}
+			if !assert.Equal(t, goroutines, cnt) {
+				return
+			}
+		}
+	})
+}
+
+type recordingTracer struct {
+	mu     sync.Mutex
+	starts []string
+	ends   int
+}
+
+func (r *recordingTracer) Start(op string) tracing.Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.starts = append(r.starts, op)
+	return r
+}
+
+func (r *recordingTracer) Retry(int) {}
+
+func (r *recordingTracer) End() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ends++
+}
+
+func TestQueueTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	que := NewQueue(WithTracer(tracer))
+	que.Push(1)
+	que.Pop()
+	que.Pop()
+
+	assert.Equal(t, []string{"Queue.Push", "Queue.Pop", "Queue.Pop"}, tracer.starts)
+	assert.Equal(t, 3, tracer.ends)
+}
+
+func TestQueueStats(t *testing.T) {
+	que := NewQueue()
+	que.Push(1)
+	que.Push(2)
+	que.Pop()
+	que.Pop()
+	que.Pop()
+
+	want := Stats{}
+	if stats.Enabled {
+		want = Stats{Pushes: 2, Pops: 2, EmptyPops: 1}
+	}
+	assert.Equal(t, want, que.Stats())
+}
+
 func TestQueueConcurrency(t *testing.T) {
 	const count = 50
 
@@ -89,6 +248,29 @@ func TestQueueConcurrency(t *testing.T) {
 		assert.Equal(t, cnt, count*3)
 	})
 
+	t.Run("Push with backoff", func(t *testing.T) {
+		que := NewQueue(WithBackoff(backoff.Exponential{}))
+
+		wg := sync.WaitGroup{}
+		wg.Add(count)
+
+		for i := 0; i < count; i++ {
+			go func(value int) {
+				defer wg.Done()
+				que.Push(value)
+				que.Push(value)
+				que.Push(value)
+			}(i)
+		}
+		wg.Wait()
+
+		cnt := 0
+		for _, ok := que.Pop(); ok; _, ok = que.Pop() {
+			cnt++
+		}
+		assert.Equal(t, cnt, count*3)
+	})
+
 	t.Run("Pop", func(t *testing.T) {
 		que := NewQueue()
 
@@ -111,3 +293,170 @@ func TestQueueConcurrency(t *testing.T) {
 		assert.False(t, ok) // Queue must be empty
 	})
 }
+
+func BenchmarkQueue(b *testing.B) {
+	b.Run("Push", func(b *testing.B) {
+		que := NewQueue()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			que.Push(i)
+		}
+	})
+
+	b.Run("Pop", func(b *testing.B) {
+		que := NewQueue()
+		for i := 0; i < b.N; i++ {
+			que.Push(i)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			que.Pop()
+		}
+	})
+
+	b.Run("Empty Pop", func(b *testing.B) {
+		que := NewQueue()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			que.Pop()
+		}
+	})
+
+	b.Run("PushPop parallel", func(b *testing.B) {
+		que := NewQueue()
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				que.Push(i)
+				que.Pop()
+			}
+		})
+	})
+}
+
+// sharedEndsQueue is the layout Queue had before its ends were padded:
+// head and tail share a cache line, so producers and consumers invalidate
+// each other's line. It runs the same Michael-Scott loops as Queue and only
+// serves as a baseline for BenchmarkQueueProducerConsumer.
+type sharedEndsQueue struct {
+	head unsafe.Pointer
+	tail unsafe.Pointer
+}
+
+func newSharedEndsQueue() *sharedEndsQueue {
+	item := unsafe.Pointer(&queueItem{})
+	return &sharedEndsQueue{head: item, tail: item}
+}
+
+func (q *sharedEndsQueue) Push(value int) {
+	newItem := unsafe.Pointer(&queueItem{value: value})
+	for {
+		tail := sched.LoadPointer(&q.tail)
+		next := sched.LoadPointer(&(*queueItem)(tail).next)
+		if tail != sched.LoadPointer(&q.tail) {
+			continue
+		}
+		if next != nil {
+			sched.CompareAndSwapPointer(&q.tail, tail, next)
+			continue
+		}
+		if sched.CompareAndSwapPointer(&(*queueItem)(tail).next, nil, newItem) {
+			sched.CompareAndSwapPointer(&q.tail, tail, newItem)
+			return
+		}
+	}
+}
+
+func (q *sharedEndsQueue) Pop() (int, bool) {
+	for {
+		head := sched.LoadPointer(&q.head)
+		tail := sched.LoadPointer(&q.tail)
+		next := sched.LoadPointer(&(*queueItem)(head).next)
+		if head != sched.LoadPointer(&q.head) {
+			continue
+		}
+		if head == tail {
+			if next == nil {
+				return 0, false
+			}
+			sched.CompareAndSwapPointer(&q.tail, tail, next)
+			continue
+		}
+		value := (*queueItem)(next).value
+		if sched.CompareAndSwapPointer(&q.head, head, next) {
+			return value, true
+		}
+	}
+}
+
+func BenchmarkQueueProducerConsumer(b *testing.B) {
+	layouts := []struct {
+		name string
+		new  func() (func(int), func() (int, bool))
+	}{
+		{"ends=shared/nodes=compact", func() (func(int), func() (int, bool)) {
+			q := newSharedEndsQueue()
+			return q.Push, q.Pop
+		}},
+		{"ends=padded/nodes=compact", func() (func(int), func() (int, bool)) {
+			q := NewQueue()
+			return q.Push, q.Pop
+		}},
+		{"ends=padded/nodes=padded", func() (func(int), func() (int, bool)) {
+			q := NewQueue(WithPaddedNodes())
+			return q.Push, q.Pop
+		}},
+	}
+
+	for _, layout := range layouts {
+		for _, pairs := range []int{1, 2, 4, 8} {
+			b.Run(fmt.Sprintf("%s/pairs=%d", layout.name, pairs), func(b *testing.B) {
+				push, pop := layout.new()
+				containertest.BenchmarkProducerConsumer(b, pairs, push, pop, containertest.Int)
+			})
+		}
+	}
+}
+
+func BenchmarkQueueBackoff(b *testing.B) {
+	strategies := []struct {
+		name    string
+		backoff backoff.Backoff
+	}{
+		{"none", nil},
+		{"exponential", backoff.Exponential{}},
+		{"yield", backoff.Yield{}},
+		{"proportional", backoff.Proportional{}},
+	}
+
+	for _, strategy := range strategies {
+		for _, goroutines := range []int{1, 4, 16, 64} {
+			b.Run(fmt.Sprintf("%s/goroutines=%d", strategy.name, goroutines), func(b *testing.B) {
+				que := NewQueue(WithBackoff(strategy.backoff))
+				b.ReportAllocs()
+				b.ResetTimer()
+				benchmarkContention(b.N, goroutines, func(value int) {
+					que.Push(value)
+					que.Pop()
+				})
+			})
+		}
+	}
+}
+
+// benchmarkContention runs op n times spread over the given number of goroutines.
+func benchmarkContention(n, goroutines int, op func(int)) {
+	wg := sync.WaitGroup{}
+	wg.Add(goroutines)
+
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < n; i += goroutines {
+				op(i)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,84 @@
+//go:build treibersched
+
+package queue
+
+import (
+	"fmt"
+	"github.com/peletor/treiber/internal/sched"
+	"github.com/stretchr/testify/assert"
+	"slices"
+	"testing"
+)
+
+// twoProducersOneConsumer starts from a queue holding 1. Two goroutines push
+// two values each while a third pops twice. Nothing may be lost or doubled,
+// and every producer's values come out in the order it pushed them.
+func twoProducersOneConsumer() ([]func(), func() error) {
+	que := NewQueue()
+	que.Push(1)
+
+	var popped []int
+	pop := func() {
+		for i := 0; i < 2; i++ {
+			if value, ok := que.Pop(); ok {
+				popped = append(popped, value)
+			}
+		}
+	}
+	producer := func(first int) func() {
+		return func() {
+			que.Push(first)
+			que.Push(first + 1)
+		}
+	}
+	return []func(){producer(10), producer(20), pop}, func() error {
+		for value, ok := que.Pop(); ok; value, ok = que.Pop() {
+			popped = append(popped, value)
+		}
+		for _, first := range []int{10, 20} {
+			if slices.Index(popped, first) > slices.Index(popped, first+1) {
+				return fmt.Errorf("popped %v, %d after %d", popped, first, first+1)
+			}
+		}
+		sorted := slices.Sorted(slices.Values(popped))
+		if !slices.Equal(sorted, []int{1, 10, 11, 20, 21}) {
+			return fmt.Errorf("pushed [1 10 11 20 21], popped %v", popped)
+		}
+		return nil
+	}
+}
+
+func TestQueueSchedules(t *testing.T) {
+	t.Run("Enumerate", func(t *testing.T) {
+		failure, runs, _ := sched.Enumerate(20_000, twoProducersOneConsumer)
+		assert.Nil(t, failure)
+		assert.Positive(t, runs)
+	})
+
+	t.Run("PCT", func(t *testing.T) {
+		failure := sched.Explore(2_000, func(seed uint64) sched.Strategy { return sched.PCT(seed, 3, 60) }, twoProducersOneConsumer)
+		assert.Nil(t, failure)
+	})
+}
+
+// firstPushAndSnapshot snapshots a zero Queue while its first Push installs
+// the sentinel, so some schedules find head set and tail still nil.
+func firstPushAndSnapshot() ([]func(), func() error) {
+	var que Queue
+	var snapshot []int
+	return []func(){
+		func() { que.Push(1) },
+		func() { snapshot = que.Snapshot() },
+	}, func() error {
+		if len(snapshot) > 1 || len(snapshot) == 1 && snapshot[0] != 1 {
+			return fmt.Errorf("snapshot %v of a queue pushed only 1", snapshot)
+		}
+		return nil
+	}
+}
+
+func TestQueueZeroValueSchedules(t *testing.T) {
+	failure, runs, _ := sched.Enumerate(20_000, firstPushAndSnapshot)
+	assert.Nil(t, failure)
+	assert.Positive(t, runs)
+}
@@ -0,0 +1,218 @@
+package queue
+
+import (
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/peletor/treiber/internal/kcas"
+	"github.com/peletor/treiber/internal/nocopy"
+	"github.com/peletor/treiber/internal/sched"
+)
+
+// stampedItem is an item of a shard of a strict ShardedQueue. It embeds
+// queueItem first, as paddedQueueItem does, so the shard handles it as
+// any other item.
+type stampedItem struct {
+	queueItem
+	stamp uint64
+}
+
+// ShardedQueue spreads its values over several Queues, so producers and
+// consumers contend on the tail and head of one shard rather than of the
+// whole queue. It is a relaxed FIFO: values pushed to the same shard, e.g.
+// with the same hint, are popped in order, but values of different shards
+// may overtake each other. WithStrict restores the global order.
+//
+// Pop returns false when it found every shard empty, although a value may
+// have been pushed to a shard it checked before. Create a ShardedQueue with
+// NewShardedQueue; it must not be copied after first use.
+type ShardedQueue struct {
+	_      nocopy.NoCopy
+	shards []*Queue
+	// cursors are the round-robin positions of Push. sync.Pool caches
+	// them per P, so producers on different Ps do not share one.
+	cursors    sync.Pool
+	nextCursor atomic.Uint32
+
+	strict bool
+	// stamps numbers the pushes of a strict queue
+	stamps atomic.Uint64
+}
+
+// ShardedOption configures a ShardedQueue created by NewShardedQueue.
+type ShardedOption func(*ShardedQueue)
+
+// WithStrict makes the queue FIFO again: every push takes a stamp from a
+// shared counter as it starts, and every Pop looks at the heads of all the
+// shards, twice, and takes the oldest one. A value whose Push returned
+// before another Push started is popped first; only values whose Pushes
+// overlap may come out of the order of their stamps. It trades most of the
+// scaling for the order.
+func WithStrict() ShardedOption {
+	return func(q *ShardedQueue) {
+		q.strict = true
+	}
+}
+
+// NewShardedQueue returns a queue of the given number of shards. It panics
+// if shards is less than one.
+func NewShardedQueue(shards int, opts ...ShardedOption) *ShardedQueue {
+	if shards < 1 {
+		panic("queue: ShardedQueue needs at least one shard")
+	}
+	q := &ShardedQueue{shards: make([]*Queue, shards)}
+	for i := range q.shards {
+		q.shards[i] = NewQueue()
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	q.cursors.New = func() any {
+		// spread the cursors, so they do not start at the same shard
+		cursor := uint(q.nextCursor.Add(1) - 1)
+		return &cursor
+	}
+	return q
+}
+
+// Push pushes value to the next shard in round-robin order.
+func (q *ShardedQueue) Push(value int) {
+	cursor := q.cursors.Get().(*uint)
+	shard := *cursor % uint(len(q.shards))
+	*cursor++
+	q.cursors.Put(cursor)
+	q.push(q.shards[shard], value)
+}
+
+// PushHint pushes value to the shard of hint, e.g. the index of a worker.
+// Values pushed with the same hint are popped in the order they were
+// pushed, even without WithStrict.
+func (q *ShardedQueue) PushHint(hint int, value int) {
+	q.push(q.shards[uint(hint)%uint(len(q.shards))], value)
+}
+
+func (q *ShardedQueue) push(shard *Queue, value int) {
+	if !q.strict {
+		shard.push(nil, shard.newItem(value))
+		return
+	}
+	item := &stampedItem{queueItem: queueItem{value: value}, stamp: q.stamps.Add(1)}
+	shard.push(nil, unsafe.Pointer(&item.queueItem))
+}
+
+// Pop removes a value from the first shard that has one, starting from a
+// random shard. With WithStrict it removes the value with the oldest
+// stamp.
+func (q *ShardedQueue) Pop() (value int, ok bool) {
+	if q.strict {
+		return q.popStrict()
+	}
+	start := rand.IntN(len(q.shards))
+	for i := range q.shards {
+		if value, ok := q.shards[(start+i)%len(q.shards)].Pop(); ok {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+func (q *ShardedQueue) popStrict() (value int, ok bool) {
+	oldest, from := q.oldest()
+	for oldest != nil {
+		// look again: a value whose Push returned before the Push of oldest
+		// started may be in a shard looked at before it arrived, but it is
+		// in its shard by now
+		again, shard := q.oldest()
+		if again == oldest && from.popFirst(unsafe.Pointer(oldest)) {
+			return oldest.value, true
+		}
+		oldest, from = again, shard
+	}
+	return 0, false
+}
+
+// oldest returns the first item with the oldest stamp of the shards of a
+// strict queue, and its shard.
+func (q *ShardedQueue) oldest() (*stampedItem, *Queue) {
+	var oldest *stampedItem
+	var from *Queue
+	for _, shard := range q.shards {
+		first := shard.first()
+		if first != nil && (oldest == nil || (*stampedItem)(first).stamp < oldest.stamp) {
+			oldest = (*stampedItem)(first)
+			from = shard
+		}
+	}
+	return oldest, from
+}
+
+// first returns the first item of q, or nil if q is empty.
+func (q *Queue) first() unsafe.Pointer {
+	for {
+		head := kcas.Load(&q.head)
+		next := kcas.Load(&(*queueItem)(head).next)
+		if head == kcas.Load(&q.head) {
+			return next
+		}
+	}
+}
+
+// popFirst pops the first item of q if it is first, and reports whether it
+// did.
+func (q *Queue) popFirst(first unsafe.Pointer) bool {
+	for attempt := 0; ; attempt++ {
+		head := kcas.Load(&q.head)
+		tail := sched.LoadPointer(&q.tail)
+		next := kcas.Load(&(*queueItem)(head).next)
+
+		if head != kcas.Load(&q.head) {
+			continue
+		}
+		if next != first {
+			return false
+		}
+		if head == tail {
+			// fix queue tail, it must not fall behind head
+			sched.CompareAndSwapPointer(&q.tail, tail, next)
+			q.stats.Helping()
+			continue
+		}
+		if sched.CompareAndSwapPointer(&q.head, head, next) {
+			q.stats.Pop()
+			return true
+		}
+		q.stats.CASFailure()
+		q.retry(nil, attempt)
+	}
+}
+
+// Len sums the lengths of the shards, with the caveats of Queue.Len.
+func (q *ShardedQueue) Len() int {
+	length := 0
+	for _, shard := range q.shards {
+		length += shard.Len()
+	}
+	return length
+}
+
+// Shards returns the number of shards.
+func (q *ShardedQueue) Shards() int {
+	return len(q.shards)
+}
+
+// Stats sums the counters of the shards.
+// The counters are only maintained when built with the treiberstats tag.
+func (q *ShardedQueue) Stats() Stats {
+	var total Stats
+	for _, shard := range q.shards {
+		s := shard.Stats()
+		total.Pushes += s.Pushes
+		total.Pops += s.Pops
+		total.EmptyPops += s.EmptyPops
+		total.CASFailures += s.CASFailures
+		total.HelpingSteps += s.HelpingSteps
+	}
+	return total
+}
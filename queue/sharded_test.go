@@ -0,0 +1,180 @@
+package queue
+
+import (
+	"fmt"
+	"github.com/peletor/treiber"
+	"github.com/peletor/treiber/containertest"
+	"github.com/stretchr/testify/assert"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestShardedQueueConformance(t *testing.T) {
+	t.Run("One shard", func(t *testing.T) {
+		containertest.TestContainer(t, containertest.FIFO, func() treiber.Container[int] { return NewShardedQueue(1) }, containertest.Int)
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		containertest.TestContainer(t, containertest.FIFO, func() treiber.Container[int] { return NewShardedQueue(4, WithStrict()) }, containertest.Int)
+	})
+
+	// relaxed, the values of several shards may come out in any order
+	t.Run("Relaxed", func(t *testing.T) {
+		containertest.TestContainer(t, containertest.Unordered, func() treiber.Container[int] { return NewShardedQueue(4) }, containertest.Int)
+	})
+}
+
+func TestShardedQueue(t *testing.T) {
+	const shards = 4
+
+	t.Run("Round robin", func(t *testing.T) {
+		q := NewShardedQueue(shards)
+		for i := 0; i < 4*shards; i++ {
+			q.Push(i)
+		}
+		assert.Equal(t, 4*shards, q.Len())
+		// the cursors live in a sync.Pool, which may drop them, so the spread
+		// is only round robin most of the time
+		used := 0
+		for _, shard := range q.shards {
+			if shard.Len() > 0 {
+				used++
+			}
+		}
+		assert.Greater(t, used, 1)
+
+		var values []int
+		for value, ok := q.Pop(); ok; value, ok = q.Pop() {
+			values = append(values, value)
+		}
+		sort.Ints(values)
+		assert.Len(t, values, 4*shards)
+		for i, value := range values {
+			assert.Equal(t, i, value)
+		}
+		assert.Equal(t, 0, q.Len())
+	})
+
+	t.Run("Same hint keeps the order", func(t *testing.T) {
+		q := NewShardedQueue(shards)
+		for i := 0; i < 99; i++ {
+			q.PushHint(i%3, i)
+		}
+		q.PushHint(-1, 99)
+
+		byHint := map[int][]int{}
+		for value, ok := q.Pop(); ok; value, ok = q.Pop() {
+			if value == 99 {
+				continue
+			}
+			byHint[value%3] = append(byHint[value%3], value)
+		}
+		for hint := 0; hint < 3; hint++ {
+			assert.Len(t, byHint[hint], 33)
+			assert.True(t, sort.IntsAreSorted(byHint[hint]), "%v", byHint[hint])
+		}
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		q := NewShardedQueue(shards, WithStrict())
+		for i := 0; i < 10; i++ {
+			q.PushHint(i*7, i)
+		}
+		for i := 0; i < 10; i++ {
+			value, ok := q.Pop()
+			assert.True(t, ok)
+			assert.Equal(t, i, value)
+		}
+		_, ok := q.Pop()
+		assert.False(t, ok)
+	})
+
+	t.Run("Shards", func(t *testing.T) {
+		assert.Equal(t, shards, NewShardedQueue(shards).Shards())
+		assert.Panics(t, func() { NewShardedQueue(0) })
+	})
+}
+
+// producers push with their own hint while consumers pop; every value comes
+// out once, and the values of one producer in order
+func TestShardedQueueConcurrency(t *testing.T) {
+	const (
+		producers = 8
+		count     = 2000
+	)
+
+	q := NewShardedQueue(4)
+	var mu sync.Mutex
+	var popped []int
+	wg := sync.WaitGroup{}
+	wg.Add(2 * producers)
+	for p := 0; p < producers; p++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				q.PushHint(p, p*count+i)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			// last is the lowest value each producer may still come up with
+			last := make([]int, producers)
+			for n := 0; n < count; {
+				value, ok := q.Pop()
+				if !ok {
+					runtime.Gosched()
+					continue
+				}
+				assert.LessOrEqual(t, last[value/count], value, "values of one producer out of order")
+				last[value/count] = value + 1
+				mu.Lock()
+				popped = append(popped, value)
+				mu.Unlock()
+				n++
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Ints(popped)
+	assert.Len(t, popped, producers*count)
+	for i, value := range popped {
+		if !assert.Equal(t, i, value) {
+			break
+		}
+	}
+}
+
+// BenchmarkShardedQueue shows how the queues scale with the number of
+// producer-consumer pairs; run it with -cpu 1,2,4,8,... for the curve over
+// the number of cores.
+func BenchmarkShardedQueue(b *testing.B) {
+	queues := []struct {
+		name string
+		new  func() (push func(int), pop func() (int, bool))
+	}{
+		{"queue", func() (func(int), func() (int, bool)) {
+			q := NewQueue()
+			return q.Push, q.Pop
+		}},
+		{"sharded", func() (func(int), func() (int, bool)) {
+			q := NewShardedQueue(runtime.GOMAXPROCS(0))
+			return q.Push, q.Pop
+		}},
+		{"strict", func() (func(int), func() (int, bool)) {
+			q := NewShardedQueue(runtime.GOMAXPROCS(0), WithStrict())
+			return q.Push, q.Pop
+		}},
+	}
+
+	for _, queue := range queues {
+		for _, pairs := range []int{1, 2, 4, 8, 16, 32} {
+			b.Run(fmt.Sprintf("%s/pairs=%d", queue.name, pairs), func(b *testing.B) {
+				push, pop := queue.new()
+				containertest.BenchmarkProducerConsumer(b, pairs, push, pop, containertest.Int)
+			})
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package queue
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/peletor/treiber/internal/nocopy"
+)
+
+// DefaultMaxItems is the memory budget of a SpillQueue, in items, unless
+// WithMaxItems or WithMaxBytes sets another.
+const DefaultMaxItems = 1 << 20
+
+// spillRecordSize is the size of one value in the spill file.
+const spillRecordSize = 8
+
+// spillPage is the number of values read back from the spill file at once.
+const spillPage = 512
+
+var ErrClosed = errors.New("queue: spill queue is closed")
+
+// SpillQueue is a Queue with a memory budget. While fewer items than the
+// budget are in memory, pushes go straight to the lock-free Queue. Beyond
+// it, and as long as anything is spilled, pushes are appended to a temp
+// file instead, and pops page them back into memory as it drains, so the
+// values leave in FIFO order across the memory/disk boundary.
+//
+// Spilled values do not survive Close or a crash; see package durable for
+// that. A SpillQueue must not be copied; use the pointer returned by
+// NewSpillQueue.
+type SpillQueue struct {
+	_ nocopy.NoCopy
+
+	memory   *Queue
+	maxItems int64
+	maxBytes int64
+	dir      string
+	opts     []Option
+
+	// inMemory counts the values pushed to memory and not popped; pushes
+	// reserve a slot before linking their value.
+	inMemory atomic.Int64
+	// inFlight counts the pushes that passed the spilling check and did not
+	// link their value yet. Paging waits for them, so no value linked after
+	// the check of a push overtakes the spilled ones. Pushes that see
+	// spilling set go to the spill file without counting themselves, so the
+	// wait only covers the pushes already past the check when paging began.
+	inFlight atomic.Int64
+	spilling atomic.Bool
+	spilled  atomic.Int64
+	closed   atomic.Bool
+
+	// mu guards the spill file: appends, page-ins and Close.
+	mu     sync.Mutex
+	file   *os.File
+	read   int64
+	write  int64
+	record []byte
+}
+
+// SpillOption configures a SpillQueue created by NewSpillQueue.
+type SpillOption func(*SpillQueue)
+
+// WithMaxItems sets the memory budget to n items.
+func WithMaxItems(n int) SpillOption {
+	return func(q *SpillQueue) {
+		q.maxItems = int64(max(n, 1))
+	}
+}
+
+// WithMaxBytes sets the memory budget to an estimate of bytes: the number of
+// items whose nodes fit in it. With WithMaxItems too, the smaller budget
+// applies.
+func WithMaxBytes(bytes int64) SpillOption {
+	return func(q *SpillQueue) {
+		q.maxBytes = bytes
+	}
+}
+
+// WithSpillDir creates the spill file in dir instead of os.TempDir.
+func WithSpillDir(dir string) SpillOption {
+	return func(q *SpillQueue) {
+		q.dir = dir
+	}
+}
+
+// WithQueueOptions configures the in-memory Queue.
+func WithQueueOptions(opts ...Option) SpillOption {
+	return func(q *SpillQueue) {
+		q.opts = append(q.opts, opts...)
+	}
+}
+
+func NewSpillQueue(opts ...SpillOption) *SpillQueue {
+	q := &SpillQueue{
+		maxItems: DefaultMaxItems,
+		record:   make([]byte, spillRecordSize),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	q.memory = NewQueue(q.opts...)
+	if q.maxBytes > 0 {
+		itemSize := int64(unsafe.Sizeof(queueItem{}))
+		if q.memory.paddedNodes {
+			itemSize = int64(unsafe.Sizeof(paddedQueueItem{}))
+		}
+		q.maxItems = min(q.maxItems, max(q.maxBytes/itemSize, 1))
+	}
+	return q
+}
+
+// Push links value into memory if it is within the budget and nothing is
+// spilled, and appends it to the spill file otherwise. It fails only if
+// the spill file cannot be written, and the value is not queued then.
+func (q *SpillQueue) Push(value int) error {
+	if q.closed.Load() {
+		return ErrClosed
+	}
+
+	if !q.spilling.Load() {
+		// count the push before checking again, so paging either waits
+		// for it or is seen by it
+		q.inFlight.Add(1)
+		if !q.spilling.Load() {
+			if q.inMemory.Add(1) <= q.maxItems {
+				q.memory.Push(value)
+				q.inFlight.Add(-1)
+				return nil
+			}
+			q.inMemory.Add(-1)
+		}
+		q.inFlight.Add(-1)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed.Load() {
+		return ErrClosed
+	}
+	return q.spill(value)
+}
+
+// spill appends value to the spill file, creating it on first use.
+func (q *SpillQueue) spill(value int) error {
+	if q.file == nil {
+		file, err := os.CreateTemp(q.dir, "treiber-spill-*")
+		if err != nil {
+			return err
+		}
+		q.file = file
+	}
+
+	// set before the write, so pushes that see it clear linked their value
+	// before the spilled one
+	q.spilling.Store(true)
+	binary.BigEndian.PutUint64(q.record, uint64(value))
+	if _, err := q.file.WriteAt(q.record, q.write); err != nil {
+		if q.read == q.write {
+			q.spilling.Store(false)
+		}
+		return err
+	}
+	q.write += spillRecordSize
+	q.spilled.Add(1)
+	return nil
+}
+
+// Pop removes the oldest value. Values are always popped from memory; when
+// memory runs low, pops page spilled values back in. A pop that finds
+// memory empty takes the spill lock, to tell an empty queue from one whose
+// values are all spilled. An error means a page could not be read back;
+// the values stay spilled and a later Pop retries.
+func (q *SpillQueue) Pop() (value int, ok bool, err error) {
+	if q.closed.Load() {
+		return 0, false, ErrClosed
+	}
+
+	if value, ok := q.memory.Pop(); ok {
+		q.inMemory.Add(-1)
+		// refill early, but never make a consumer wait for it; an error
+		// here is met again by the pop that finds memory empty
+		if q.spilling.Load() && q.inMemory.Load() <= q.maxItems/2 && q.mu.TryLock() {
+			q.pageIn()
+			q.mu.Unlock()
+		}
+		return value, true, nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.closed.Load() {
+			return 0, false, ErrClosed
+		}
+		if value, ok := q.memory.Pop(); ok {
+			q.inMemory.Add(-1)
+			return value, true, nil
+		}
+		if q.read == q.write {
+			// nothing can be spilled while mu is held
+			return 0, false, nil
+		}
+		if err := q.pageIn(); err != nil {
+			return 0, false, err
+		}
+	}
+}
+
+// pageIn moves the oldest spilled values into memory, as many as the budget
+// has room for and at least one. Once the file is drained, it is truncated
+// and pushes go to memory again.
+func (q *SpillQueue) pageIn() error {
+	if q.read == q.write {
+		return nil
+	}
+	// spilling is set while anything is spilled, so no push counts itself
+	// in after this; the wait is bounded by the pushes already linking
+	for q.inFlight.Load() != 0 {
+		runtime.Gosched()
+	}
+
+	n := min(spillPage, max(q.maxItems-q.inMemory.Load(), 1), (q.write-q.read)/spillRecordSize)
+	page := make([]byte, n*spillRecordSize)
+	if _, err := q.file.ReadAt(page, q.read); err != nil {
+		return err
+	}
+	for i := int64(0); i < n; i++ {
+		q.inMemory.Add(1)
+		q.memory.Push(int(binary.BigEndian.Uint64(page[i*spillRecordSize:])))
+	}
+	q.read += n * spillRecordSize
+	q.spilled.Add(-n)
+
+	if q.read == q.write {
+		q.read, q.write = 0, 0
+		if err := q.file.Truncate(0); err != nil {
+			return err
+		}
+		q.spilling.Store(false)
+	}
+	return nil
+}
+
+// Len returns the number of values in memory and spilled. Under concurrent
+// pushes and pops it is only an approximation.
+func (q *SpillQueue) Len() int {
+	return int(q.inMemory.Load() + q.spilled.Load())
+}
+
+// Spilled returns the number of values in the spill file.
+func (q *SpillQueue) Spilled() int {
+	return int(q.spilled.Load())
+}
+
+// Close removes the spill file. The spilled values are lost with it, and
+// later pushes and pops fail with ErrClosed.
+func (q *SpillQueue) Close() error {
+	if q.closed.Swap(true) {
+		return ErrClosed
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.file == nil {
+		return nil
+	}
+	return errors.Join(q.file.Close(), os.Remove(q.file.Name()))
+}
@@ -0,0 +1,265 @@
+package queue
+
+import (
+	"github.com/peletor/treiber"
+	"github.com/peletor/treiber/containertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestSpillQueue(t *testing.T) {
+	const count = 50
+
+	t.Run("Push-Pop", func(t *testing.T) {
+		que := NewSpillQueue(WithSpillDir(t.TempDir()))
+		defer que.Close()
+		require.NoError(t, que.Push(5))
+		result, ok, err := que.Pop()
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 5, result)
+	})
+
+	t.Run("Empty Pop", func(t *testing.T) {
+		que := NewSpillQueue(WithSpillDir(t.TempDir()))
+		defer que.Close()
+		result, ok, err := que.Pop()
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Zero(t, result)
+	})
+
+	t.Run("FIFO across the spill", func(t *testing.T) {
+		que := NewSpillQueue(WithMaxItems(4), WithSpillDir(t.TempDir()))
+		defer que.Close()
+		for i := 0; i < count; i++ {
+			require.NoError(t, que.Push(i))
+		}
+		assert.Equal(t, count, que.Len())
+		assert.Equal(t, count-4, que.Spilled())
+
+		for i := 0; i < count; i++ {
+			result, ok, err := que.Pop()
+			require.NoError(t, err)
+			require.True(t, ok)
+			assert.Equal(t, i, result)
+		}
+		_, ok, err := que.Pop()
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Zero(t, que.Spilled())
+	})
+
+	// pushes keep spilling until the file is drained, even with room in memory
+	t.Run("Interleaved", func(t *testing.T) {
+		que := NewSpillQueue(WithMaxItems(3), WithSpillDir(t.TempDir()))
+		defer que.Close()
+		next, want := 0, 0
+		for round := 0; round < count; round++ {
+			for i := 0; i < 3; i++ {
+				require.NoError(t, que.Push(next))
+				next++
+			}
+			for i := 0; i < 2; i++ {
+				result, ok, err := que.Pop()
+				require.NoError(t, err)
+				require.True(t, ok)
+				require.Equal(t, want, result)
+				want++
+			}
+		}
+		for want < next {
+			result, ok, err := que.Pop()
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, want, result)
+			want++
+		}
+	})
+
+	t.Run("WithMaxBytes", func(t *testing.T) {
+		itemSize := int(unsafe.Sizeof(queueItem{}))
+		que := NewSpillQueue(WithMaxBytes(int64(10*itemSize)), WithSpillDir(t.TempDir()))
+		defer que.Close()
+		for i := 0; i < count; i++ {
+			require.NoError(t, que.Push(i))
+		}
+		assert.Equal(t, count-10, que.Spilled())
+
+		padded := NewSpillQueue(WithMaxBytes(int64(10*itemSize)), WithQueueOptions(WithPaddedNodes()), WithSpillDir(t.TempDir()))
+		defer padded.Close()
+		for i := 0; i < count; i++ {
+			require.NoError(t, padded.Push(i))
+		}
+		assert.Equal(t, count-10*itemSize/int(unsafe.Sizeof(paddedQueueItem{})), padded.Spilled())
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		dir := t.TempDir()
+		que := NewSpillQueue(WithMaxItems(1), WithSpillDir(dir))
+		require.NoError(t, que.Push(1))
+		require.NoError(t, que.Push(2))
+		files, err := filepath.Glob(filepath.Join(dir, "*"))
+		require.NoError(t, err)
+		assert.Len(t, files, 1)
+
+		require.NoError(t, que.Close())
+		files, err = filepath.Glob(filepath.Join(dir, "*"))
+		require.NoError(t, err)
+		assert.Empty(t, files)
+
+		assert.ErrorIs(t, que.Push(3), ErrClosed)
+		_, _, err = que.Pop()
+		assert.ErrorIs(t, err, ErrClosed)
+		assert.ErrorIs(t, que.Close(), ErrClosed)
+	})
+
+	t.Run("Spill failure", func(t *testing.T) {
+		que := NewSpillQueue(WithMaxItems(1), WithSpillDir(filepath.Join(t.TempDir(), "missing")))
+		defer que.Close()
+		require.NoError(t, que.Push(1))
+		assert.ErrorIs(t, que.Push(2), os.ErrNotExist)
+		assert.Equal(t, 1, que.Len())
+
+		// nothing was spilled, so memory takes pushes again once there is room
+		result, ok, err := que.Pop()
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 1, result)
+		assert.NoError(t, que.Push(3))
+	})
+}
+
+// spillContainer fails the test on the errors of Push and Pop.
+type spillContainer struct {
+	*SpillQueue
+	t *testing.T
+}
+
+func (q spillContainer) Push(value int) {
+	if err := q.SpillQueue.Push(value); err != nil {
+		q.t.Errorf("Push(%d): %v", value, err)
+	}
+}
+
+func (q spillContainer) Pop() (int, bool) {
+	value, ok, err := q.SpillQueue.Pop()
+	if err != nil {
+		q.t.Errorf("Pop: %v", err)
+	}
+	return value, ok
+}
+
+// The conformance tests hold up to a hundred values, so most of them are
+// spilled past the first sixteen.
+func TestSpillQueueConformance(t *testing.T) {
+	containertest.TestContainer(t, containertest.FIFO, func() treiber.Container[int] {
+		q := NewSpillQueue(WithMaxItems(16), WithSpillDir(t.TempDir()))
+		t.Cleanup(func() { assert.NoError(t, q.Close()) })
+		return spillContainer{q, t}
+	}, containertest.Int)
+}
+
+func TestSpillQueueConcurrency(t *testing.T) {
+	const (
+		producers = 4
+		consumers = 4
+		count     = 2000
+	)
+
+	que := NewSpillQueue(WithMaxItems(16), WithSpillDir(t.TempDir()))
+	defer que.Close()
+
+	var mu sync.Mutex
+	popped := make([][]int, producers)
+	wg := sync.WaitGroup{}
+	wg.Add(producers + consumers)
+	for p := 0; p < producers; p++ {
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				assert.NoError(t, que.Push(p*count+i))
+			}
+		}(p)
+	}
+	remaining := make(chan struct{}, producers*count)
+	for i := 0; i < producers*count; i++ {
+		remaining <- struct{}{}
+	}
+	close(remaining)
+	for c := 0; c < consumers; c++ {
+		go func() {
+			defer wg.Done()
+			for range remaining {
+				for {
+					value, ok, err := que.Pop()
+					if !assert.NoError(t, err) {
+						return
+					}
+					if ok {
+						mu.Lock()
+						popped[value/count] = append(popped[value/count], value%count)
+						mu.Unlock()
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// one consumer alone would see every producer in order; with several,
+	// each value still arrives exactly once
+	for p := 0; p < producers; p++ {
+		assert.ElementsMatch(t, seq(count), popped[p], "producer %d", p)
+	}
+	assert.Zero(t, que.Len())
+}
+
+func TestSpillQueueProducerOrder(t *testing.T) {
+	const (
+		producers = 4
+		count     = 2000
+	)
+
+	que := NewSpillQueue(WithMaxItems(16), WithSpillDir(t.TempDir()))
+	defer que.Close()
+
+	wg := sync.WaitGroup{}
+	wg.Add(producers)
+	for p := 0; p < producers; p++ {
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				assert.NoError(t, que.Push(p*count+i))
+			}
+		}(p)
+	}
+
+	next := make([]int, producers)
+	for received := 0; received < producers*count; {
+		value, ok, err := que.Pop()
+		require.NoError(t, err)
+		if !ok {
+			continue
+		}
+		p := value / count
+		require.Equal(t, next[p], value%count, "producer %d", p)
+		next[p]++
+		received++
+	}
+	wg.Wait()
+}
+
+func seq(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"github.com/peletor/treiber/internal/kcas"
+	"github.com/peletor/treiber/internal/sched"
+)
+
+// TakeEntry and PutEntry implement transfer.Source and transfer.Sink; use
+// transfer.Transfer rather than calling them.
+//
+// TakeEntry returns the KCAS entry that moves head past the first value,
+// and done to call once the KCAS succeeded. ok is false if the queue is
+// empty.
+func (q *Queue) TakeEntry() (e kcas.Entry, value int, ok bool, done func()) {
+	q.init()
+	for attempt := 0; ; attempt++ {
+		head := kcas.Load(&q.head)
+		tail := sched.LoadPointer(&q.tail)
+		next := kcas.Load(&(*queueItem)(head).next)
+
+		// if queue head is not changed in other goroutine
+		if head == kcas.Load(&q.head) {
+			if head != tail {
+				return kcas.Entry{Addr: &q.head, Old: head, New: next}, (*queueItem)(next).value, true, q.stats.Pop
+			}
+			if next == nil {
+				q.stats.EmptyPop()
+				return kcas.Entry{}, 0, false, nil
+			}
+			// fix queue tail
+			sched.CompareAndSwapPointer(&q.tail, tail, next)
+			q.stats.Helping()
+		}
+		q.retry(nil, attempt)
+	}
+}
+
+// PutEntry returns the KCAS entry that links value after the last item;
+// done moves tail to it.
+func (q *Queue) PutEntry(value int) (e kcas.Entry, done func()) {
+	q.init()
+	newItem := q.newItem(value)
+
+	for attempt := 0; ; attempt++ {
+		tail := sched.LoadPointer(&q.tail)
+		next := kcas.Load(&(*queueItem)(tail).next)
+
+		// if queue tail is not changed in other goroutine
+		if tail == sched.LoadPointer(&q.tail) {
+			if next == nil {
+				return kcas.Entry{Addr: &(*queueItem)(tail).next, New: newItem}, func() {
+					sched.CompareAndSwapPointer(&q.tail, tail, newItem)
+					q.stats.Push()
+				}
+			}
+			// try to fix queue tail
+			sched.CompareAndSwapPointer(&q.tail, tail, next)
+			q.stats.Helping()
+		}
+		q.retry(nil, attempt)
+	}
+}
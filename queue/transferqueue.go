@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/peletor/treiber/internal/sched"
+	"github.com/peletor/treiber/tracing"
+)
+
+// TransferQueue is a DualQueue whose producers can also wait for a
+// consumer. Push appends and returns at once; Transfer returns only once a
+// consumer received the value, so the producer knows the work was picked
+// up; TryTransfer hands the value over only if a consumer waits already.
+//
+// Values of Push and Transfer are received in the order they came, by Pop
+// and PopWait in the order those came. The zero TransferQueue is empty and
+// ready to use; it must not be copied after first use.
+type TransferQueue struct {
+	DualQueue
+}
+
+// NewTransferQueue takes the options of NewQueue, as NewDualQueue, and
+// panics on WithPaddedNodes too.
+func NewTransferQueue(opts ...Option) *TransferQueue {
+	q := &TransferQueue{}
+	q.configure(opts)
+	q.init()
+	return q
+}
+
+// Transfer hands value to a consumer and waits until one received it. It
+// returns ctx.Err() if ctx is done first, and then no consumer receives
+// value.
+func (q *TransferQueue) Transfer(ctx context.Context, value int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	span := tracing.Start(q.tracer, "TransferQueue.Transfer")
+	defer tracing.End(span)
+
+	item := &dualItem{isData: true, value: value, ready: make(chan struct{})}
+	if q.put(span, item, true) {
+		return nil
+	}
+
+	select {
+	case <-item.ready:
+		return nil
+	case <-ctx.Done():
+		if sched.CompareAndSwapPointer(&item.item, nil, cancelled) {
+			return ctx.Err()
+		}
+		// taken meanwhile
+		return nil
+	}
+}
+
+// TryTransfer hands value to a waiting PopWait. It reports false, and
+// drops value, if no consumer is waiting.
+func (q *TransferQueue) TryTransfer(value int) bool {
+	span := tracing.Start(q.tracer, "TransferQueue.TryTransfer")
+	defer tracing.End(span)
+
+	return q.put(span, &dualItem{isData: true, value: value}, false)
+}
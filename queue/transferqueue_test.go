@@ -0,0 +1,143 @@
+package queue
+
+import (
+	"context"
+	"github.com/peletor/treiber"
+	"github.com/peletor/treiber/containertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransferQueueConformance(t *testing.T) {
+	containertest.TestContainer(t, containertest.FIFO, func() treiber.Container[int] { return NewTransferQueue() }, containertest.Int)
+}
+
+func TestTransferQueue(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Transfer waits for a consumer", func(t *testing.T) {
+		q := NewTransferQueue()
+		transferred := make(chan error)
+		go func() { transferred <- q.Transfer(ctx, 1) }()
+
+		require.Eventually(t, func() bool { return q.Len() == 1 }, time.Second, time.Millisecond)
+		select {
+		case <-transferred:
+			t.Fatal("Transfer returned before a consumer received the value")
+		case <-time.After(10 * time.Millisecond):
+		}
+		value, ok := q.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, 1, value)
+		assert.NoError(t, <-transferred)
+	})
+
+	t.Run("Transfer to a waiting consumer", func(t *testing.T) {
+		q := &TransferQueue{}
+		popped := make(chan int)
+		go func() {
+			value, _ := q.PopWait(ctx)
+			popped <- value
+		}()
+		require.Eventually(t, func() bool { return q.Waiting() == 1 }, time.Second, time.Millisecond)
+		assert.NoError(t, q.Transfer(ctx, 2))
+		assert.Equal(t, 2, <-popped)
+	})
+
+	t.Run("TryTransfer", func(t *testing.T) {
+		q := NewTransferQueue()
+		assert.False(t, q.TryTransfer(1))
+		assert.Equal(t, 0, q.Len())
+
+		popped := make(chan int)
+		go func() {
+			value, _ := q.PopWait(ctx)
+			popped <- value
+		}()
+		require.Eventually(t, func() bool { return q.Waiting() == 1 }, time.Second, time.Millisecond)
+		assert.True(t, q.TryTransfer(2))
+		assert.Equal(t, 2, <-popped)
+	})
+
+	t.Run("Cancelled Transfer", func(t *testing.T) {
+		q := NewTransferQueue()
+		for i := 0; i < 10; i++ {
+			timeout, cancel := context.WithTimeout(ctx, time.Millisecond)
+			assert.ErrorIs(t, q.Transfer(timeout, i), context.DeadlineExceeded)
+			cancel()
+		}
+		assert.Equal(t, 0, q.Len())
+		_, ok := q.Pop()
+		assert.False(t, ok, "a cancelled Transfer must not be received")
+
+		// the cancelled values do not pile up
+		items := 0
+		for item := q.head; item != nil; item = (*dualItem)(item).next {
+			items++
+		}
+		assert.LessOrEqual(t, items, 2)
+	})
+
+	t.Run("Pushes and Transfers keep their order", func(t *testing.T) {
+		q := NewTransferQueue()
+		q.Push(1)
+		go q.Transfer(ctx, 2)
+		require.Eventually(t, func() bool { return q.Len() == 2 }, time.Second, time.Millisecond)
+		q.Push(3)
+		for _, want := range []int{1, 2, 3} {
+			value, err := q.PopWait(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, want, value)
+		}
+	})
+}
+
+func TestTransferQueueConcurrency(t *testing.T) {
+	const (
+		producers = 4
+		consumers = 4
+		count     = 1000
+	)
+	ctx := context.Background()
+	q := NewTransferQueue()
+
+	var mu sync.Mutex
+	var received []int
+	wg := sync.WaitGroup{}
+	wg.Add(producers + consumers)
+	for p := 0; p < producers; p++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				if i%2 == 0 {
+					q.Push(p*count + i)
+				} else {
+					assert.NoError(t, q.Transfer(ctx, p*count+i))
+				}
+			}
+		}()
+	}
+	for c := 0; c < consumers; c++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < producers*count/consumers; i++ {
+				value, err := q.PopWait(ctx)
+				assert.NoError(t, err)
+				mu.Lock()
+				received = append(received, value)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Ints(received)
+	require.Len(t, received, producers*count)
+	for i, value := range received {
+		require.Equal(t, i, value)
+	}
+}
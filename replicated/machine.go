@@ -0,0 +1,65 @@
+package replicated
+
+import "github.com/peletor/treiber/queue"
+
+type Op uint8
+
+const (
+	// OpNoop is appended by every new leader, so entries of earlier terms
+	// commit without waiting for the next command.
+	OpNoop Op = iota
+	OpPush
+	OpPop
+)
+
+// Command is what the log replicates. Client and Seq identify it: a
+// command whose Seq is not above the last one applied for its Client is a
+// retry, and gets the result of the first application instead of running
+// again.
+type Command struct {
+	Op     Op
+	Value  int
+	Client uint64
+	Seq    uint64
+}
+
+type result struct {
+	value int
+	ok    bool
+}
+
+type session struct {
+	seq    uint64
+	result result
+}
+
+// machine is the deterministic state machine: the same commands in the same
+// order leave every node with the same queue and sessions.
+type machine struct {
+	queue    *queue.Queue
+	sessions map[uint64]session
+}
+
+func newMachine() *machine {
+	return &machine{queue: queue.NewQueue(), sessions: make(map[uint64]session)}
+}
+
+func (m *machine) apply(c Command) result {
+	if c.Op == OpNoop {
+		return result{}
+	}
+	if s, ok := m.sessions[c.Client]; ok && c.Seq <= s.seq {
+		// a retry; an older Seq has no waiting client anymore
+		return s.result
+	}
+
+	var res result
+	switch c.Op {
+	case OpPush:
+		m.queue.Push(c.Value)
+	case OpPop:
+		res.value, res.ok = m.queue.Pop()
+	}
+	m.sessions[c.Client] = session{seq: c.Seq, result: res}
+	return res
+}
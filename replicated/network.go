@@ -0,0 +1,47 @@
+package replicated
+
+import "sync"
+
+// Network is an in-memory Transport connecting the nodes added to it. It
+// delivers messages in order, unless a node is disconnected: then the
+// messages from and to it are lost, as in a network partition.
+type Network struct {
+	mu           sync.RWMutex
+	nodes        map[int]*Node
+	disconnected map[int]bool
+}
+
+func NewNetwork() *Network {
+	return &Network{nodes: make(map[int]*Node), disconnected: make(map[int]bool)}
+}
+
+// Add connects n. Create n with the Network as its Transport.
+func (nw *Network) Add(n *Node) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.nodes[n.id] = n
+}
+
+// Disconnect cuts node id off from all others.
+func (nw *Network) Disconnect(id int) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.disconnected[id] = true
+}
+
+func (nw *Network) Reconnect(id int) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	delete(nw.disconnected, id)
+}
+
+func (nw *Network) Send(msg Message) {
+	nw.mu.RLock()
+	defer nw.mu.RUnlock()
+	if nw.disconnected[msg.From] || nw.disconnected[msg.To] {
+		return
+	}
+	if n, ok := nw.nodes[msg.To]; ok {
+		n.Step(msg)
+	}
+}
@@ -0,0 +1,579 @@
+// Package replicated is a FIFO queue replicated over a cluster with Raft.
+//
+// Every Node keeps a queue.Queue as a deterministic state machine and
+// applies Push and Pop commands in the order of a log that a small Raft
+// implementation replicates: leader election, log replication and commit
+// on a majority, as in the paper of Ongaro and Ousterhout, without
+// membership changes, log compaction or persistence. A stopped Node is
+// gone; the cluster keeps working while a majority runs.
+//
+// Nodes exchange Messages through a Transport. Network is an in-memory one,
+// which can also cut nodes off, for running a cluster inside a process or
+// a test.
+//
+// Clients use a Session. It numbers its commands and retries them on
+// another node when a leader fails, and the state machine applies every
+// command once, so a Pop that was retried never removes two values.
+package replicated
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/peletor/treiber/internal/nocopy"
+)
+
+var (
+	ErrStopped = errors.New("replicated: node stopped")
+	// errNotLeader makes a Session try another node.
+	errNotLeader = errors.New("replicated: not the leader")
+	// errDropped means the entry of a proposal was replaced by one of a
+	// later leader.
+	errDropped = errors.New("replicated: proposal dropped")
+)
+
+// maxBatch bounds the entries of one append message.
+const maxBatch = 64
+
+// inboxSize is the number of messages a Node buffers; Raft copes with the
+// messages dropped beyond it.
+const inboxSize = 1024
+
+type MessageType uint8
+
+const (
+	MsgVote MessageType = iota + 1
+	MsgVoteResp
+	MsgApp
+	MsgAppResp
+)
+
+// Message is the unit of the Raft protocol. A Transport delivers it to the
+// Step method of node To; it may drop, delay or reorder messages.
+type Message struct {
+	Type     MessageType
+	From, To int
+	Term     uint64
+	// LogIndex and LogTerm are the last entry of the candidate for MsgVote,
+	// and the entry before Entries for MsgApp.
+	LogIndex uint64
+	LogTerm  uint64
+	Entries  []Entry
+	Commit   uint64
+	// Reject answers MsgVote and MsgApp.
+	Reject bool
+	// Index is, for MsgAppResp, the last index the follower matches, or a
+	// hint where to continue when it rejects.
+	Index uint64
+}
+
+type Entry struct {
+	Term    uint64
+	Command Command
+}
+
+// Transport sends messages to other nodes, best effort. Send must not
+// block for long; it is called by the goroutine that runs the Node.
+type Transport interface {
+	Send(msg Message)
+}
+
+type Role uint8
+
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+)
+
+func (r Role) String() string {
+	switch r {
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+	return "follower"
+}
+
+// Status is what a Node last published about itself.
+type Status struct {
+	ID      int
+	Role    Role
+	Term    uint64
+	Leader  int // 0 if unknown
+	Commit  uint64
+	Applied uint64
+}
+
+type proposal struct {
+	cmd   Command
+	reply chan reply
+}
+
+type reply struct {
+	result
+	err    error
+	leader int
+}
+
+type waiter struct {
+	term  uint64
+	reply chan reply
+}
+
+// Node is one member of a cluster. It must not be copied; use the pointer
+// returned by NewNode.
+type Node struct {
+	_ nocopy.NoCopy
+
+	id             int
+	peers          []int
+	transport      Transport
+	tick           time.Duration
+	electionTicks  int
+	heartbeatTicks int
+	rand           *rand.Rand
+
+	inbox     chan Message
+	proposals chan proposal
+	stop      chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+	status    atomic.Pointer[Status]
+
+	// the rest is owned by the goroutine of run
+	term     uint64
+	votedFor int
+	log      []Entry
+	commit   uint64
+	applied  uint64
+	role     Role
+	leader   int
+	votes    map[int]bool
+	next     map[int]uint64
+	match    map[int]uint64
+	elapsed  int
+	timeout  int
+	waiters  map[uint64]waiter
+	machine  *machine
+}
+
+// Option configures a Node created by NewNode.
+type Option func(*Node)
+
+// WithTick sets the interval of the Raft clock, 10ms by default.
+func WithTick(d time.Duration) Option {
+	return func(n *Node) {
+		n.tick = d
+	}
+}
+
+// WithElectionTicks sets the ticks without a leader after which a follower
+// starts an election. Each node picks its timeout at random between n and
+// 2n ticks, 10 by default.
+func WithElectionTicks(ticks int) Option {
+	return func(n *Node) {
+		n.electionTicks = max(ticks, 2)
+	}
+}
+
+// WithHeartbeatTicks sets the ticks between the heartbeats of a leader, 1
+// by default. It must be well below the election ticks.
+func WithHeartbeatTicks(ticks int) Option {
+	return func(n *Node) {
+		n.heartbeatTicks = max(ticks, 1)
+	}
+}
+
+// NewNode creates the node id of the cluster of peers, which lists the ids
+// of all nodes, id included. Ids must be positive.
+func NewNode(id int, peers []int, transport Transport, opts ...Option) *Node {
+	n := &Node{
+		id:             id,
+		peers:          slices.Clone(peers),
+		transport:      transport,
+		tick:           10 * time.Millisecond,
+		electionTicks:  10,
+		heartbeatTicks: 1,
+		rand:           rand.New(rand.NewPCG(uint64(id), uint64(time.Now().UnixNano()))),
+		inbox:          make(chan Message, inboxSize),
+		proposals:      make(chan proposal),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+		log:            []Entry{{}},
+		waiters:        make(map[uint64]waiter),
+		machine:        newMachine(),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	n.resetTimeout()
+	n.publish()
+	return n
+}
+
+func (n *Node) ID() int {
+	return n.id
+}
+
+// Start runs the node on a new goroutine.
+func (n *Node) Start() {
+	n.startOnce.Do(func() {
+		go n.run()
+	})
+}
+
+// Stop ends the node and waits for it. Pending proposals fail with
+// ErrStopped.
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		close(n.stop)
+	})
+	n.startOnce.Do(func() {
+		close(n.done)
+	})
+	<-n.done
+}
+
+// Step delivers msg to the node. It never blocks; a full inbox drops msg.
+func (n *Node) Step(msg Message) {
+	select {
+	case n.inbox <- msg:
+	default:
+	}
+}
+
+func (n *Node) Status() Status {
+	return *n.status.Load()
+}
+
+// Len returns the length of the queue as applied on this node, which lags
+// behind the leader on a follower.
+func (n *Node) Len() int {
+	return n.machine.queue.Len()
+}
+
+// propose replicates cmd through this node and returns its result once
+// committed and applied.
+func (n *Node) propose(ctx context.Context, cmd Command) reply {
+	p := proposal{cmd: cmd, reply: make(chan reply, 1)}
+	select {
+	case n.proposals <- p:
+	case <-n.done:
+		return reply{err: ErrStopped}
+	case <-ctx.Done():
+		return reply{err: ctx.Err()}
+	}
+
+	select {
+	case r := <-p.reply:
+		return r
+	case <-n.done:
+		return reply{err: ErrStopped}
+	case <-ctx.Done():
+		return reply{err: ctx.Err()}
+	}
+}
+
+func (n *Node) run() {
+	defer close(n.done)
+	ticker := time.NewTicker(n.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			n.failWaiters(ErrStopped)
+			return
+		case <-ticker.C:
+			n.onTick()
+		case msg := <-n.inbox:
+			n.step(msg)
+		case p := <-n.proposals:
+			n.onProposal(p)
+		}
+		n.applyCommitted()
+		n.publish()
+	}
+}
+
+func (n *Node) publish() {
+	n.status.Store(&Status{
+		ID:      n.id,
+		Role:    n.role,
+		Term:    n.term,
+		Leader:  n.leader,
+		Commit:  n.commit,
+		Applied: n.applied,
+	})
+}
+
+func (n *Node) lastIndex() uint64 {
+	return uint64(len(n.log) - 1)
+}
+
+func (n *Node) quorum() int {
+	return len(n.peers)/2 + 1
+}
+
+func (n *Node) resetTimeout() {
+	n.elapsed = 0
+	n.timeout = n.electionTicks + n.rand.IntN(n.electionTicks)
+}
+
+func (n *Node) send(msg Message) {
+	msg.From = n.id
+	msg.Term = n.term
+	n.transport.Send(msg)
+}
+
+func (n *Node) onTick() {
+	n.elapsed++
+	if n.role == Leader {
+		if n.elapsed >= n.heartbeatTicks {
+			n.elapsed = 0
+			n.broadcastAppend()
+		}
+		return
+	}
+	if n.elapsed >= n.timeout {
+		n.becomeCandidate()
+	}
+}
+
+// becomeFollower leaves the election timer alone: a higher term alone
+// is no sign of a live leader, and a candidate whose vote this node
+// rejects for a stale log must not hold off its elections. The timer is
+// reset by a granted vote or an append of the current leader.
+func (n *Node) becomeFollower(term uint64, leader int) {
+	wasLeader := n.role == Leader
+	if term > n.term {
+		n.term = term
+		n.votedFor = 0
+	}
+	n.role = Follower
+	n.leader = leader
+	if wasLeader {
+		n.failWaiters(errNotLeader)
+	}
+}
+
+func (n *Node) becomeCandidate() {
+	n.term++
+	n.votedFor = n.id
+	n.role = Candidate
+	n.leader = 0
+	n.votes = map[int]bool{n.id: true}
+	n.resetTimeout()
+	if len(n.votes) >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+
+	last := n.lastIndex()
+	for _, peer := range n.peers {
+		if peer != n.id {
+			n.send(Message{Type: MsgVote, To: peer, LogIndex: last, LogTerm: n.log[last].Term})
+		}
+	}
+}
+
+func (n *Node) becomeLeader() {
+	n.role = Leader
+	n.leader = n.id
+	n.elapsed = 0
+	n.next = make(map[int]uint64)
+	n.match = make(map[int]uint64)
+	for _, peer := range n.peers {
+		n.next[peer] = n.lastIndex() + 1
+	}
+	n.appendEntry(Command{Op: OpNoop})
+	n.broadcastAppend()
+}
+
+func (n *Node) appendEntry(cmd Command) uint64 {
+	n.log = append(n.log, Entry{Term: n.term, Command: cmd})
+	n.match[n.id] = n.lastIndex()
+	n.maybeCommit()
+	return n.lastIndex()
+}
+
+func (n *Node) onProposal(p proposal) {
+	if n.role != Leader {
+		p.reply <- reply{err: errNotLeader, leader: n.leader}
+		return
+	}
+	index := n.appendEntry(p.cmd)
+	n.waiters[index] = waiter{term: n.term, reply: p.reply}
+	n.broadcastAppend()
+}
+
+func (n *Node) broadcastAppend() {
+	for _, peer := range n.peers {
+		if peer != n.id {
+			n.sendAppend(peer)
+		}
+	}
+}
+
+func (n *Node) sendAppend(to int) {
+	prev := n.next[to] - 1
+	end := min(n.lastIndex()+1, n.next[to]+maxBatch)
+	n.send(Message{
+		Type:     MsgApp,
+		To:       to,
+		LogIndex: prev,
+		LogTerm:  n.log[prev].Term,
+		// cloned, a later truncation must not change a message in flight
+		Entries: slices.Clone(n.log[prev+1 : end]),
+		Commit:  n.commit,
+	})
+}
+
+func (n *Node) step(msg Message) {
+	switch {
+	case msg.Term > n.term:
+		leader := 0
+		if msg.Type == MsgApp {
+			leader = msg.From
+		}
+		n.becomeFollower(msg.Term, leader)
+	case msg.Term < n.term:
+		// tell a stale leader or candidate about the newer term
+		switch msg.Type {
+		case MsgVote:
+			n.send(Message{Type: MsgVoteResp, To: msg.From, Reject: true})
+		case MsgApp:
+			n.send(Message{Type: MsgAppResp, To: msg.From, Reject: true})
+		}
+		return
+	}
+
+	switch msg.Type {
+	case MsgVote:
+		last := n.lastIndex()
+		upToDate := msg.LogTerm > n.log[last].Term || msg.LogTerm == n.log[last].Term && msg.LogIndex >= last
+		grant := (n.votedFor == 0 || n.votedFor == msg.From) && upToDate
+		if grant {
+			n.votedFor = msg.From
+			n.elapsed = 0
+		}
+		n.send(Message{Type: MsgVoteResp, To: msg.From, Reject: !grant})
+
+	case MsgVoteResp:
+		if n.role != Candidate || msg.Reject {
+			return
+		}
+		n.votes[msg.From] = true
+		if len(n.votes) >= n.quorum() {
+			n.becomeLeader()
+		}
+
+	case MsgApp:
+		if n.role != Follower || n.leader != msg.From {
+			n.becomeFollower(msg.Term, msg.From)
+		}
+		n.elapsed = 0
+		n.onAppend(msg)
+
+	case MsgAppResp:
+		if n.role != Leader {
+			return
+		}
+		if msg.Reject {
+			n.next[msg.From] = max(1, min(n.next[msg.From]-1, msg.Index+1))
+			n.sendAppend(msg.From)
+			return
+		}
+		if msg.Index > n.match[msg.From] {
+			n.match[msg.From] = msg.Index
+			n.next[msg.From] = msg.Index + 1
+			n.maybeCommit()
+		}
+		if n.next[msg.From] <= n.lastIndex() {
+			n.sendAppend(msg.From)
+		}
+	}
+}
+
+// onAppend appends the entries of a leader after checking that the logs
+// agree up to them.
+func (n *Node) onAppend(msg Message) {
+	last := n.lastIndex()
+	if msg.LogIndex > last || n.log[msg.LogIndex].Term != msg.LogTerm {
+		n.send(Message{Type: MsgAppResp, To: msg.From, Reject: true, Index: min(last, msg.LogIndex-1)})
+		return
+	}
+
+	for i, entry := range msg.Entries {
+		index := msg.LogIndex + 1 + uint64(i)
+		if index <= n.lastIndex() {
+			if n.log[index].Term == entry.Term {
+				continue
+			}
+			// a conflict: the rest of this log was never committed
+			n.log = n.log[:index]
+		}
+		n.log = append(n.log, msg.Entries[i:]...)
+		break
+	}
+
+	// a stale or short append matches less than is committed already, and
+	// the commit index never moves back
+	matched := msg.LogIndex + uint64(len(msg.Entries))
+	n.commit = max(n.commit, min(msg.Commit, matched))
+	n.send(Message{Type: MsgAppResp, To: msg.From, Index: matched})
+}
+
+// maybeCommit commits the last entry of the current term a majority has.
+// Entries of earlier terms commit with it.
+func (n *Node) maybeCommit() {
+	for index := n.lastIndex(); index > n.commit && n.log[index].Term == n.term; index-- {
+		count := 0
+		for _, peer := range n.peers {
+			if n.match[peer] >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commit = index
+			return
+		}
+	}
+}
+
+func (n *Node) applyCommitted() {
+	for n.applied < n.commit {
+		n.applied++
+		entry := n.log[n.applied]
+		res := n.machine.apply(entry.Command)
+
+		w, ok := n.waiters[n.applied]
+		if !ok {
+			continue
+		}
+		delete(n.waiters, n.applied)
+		if w.term == entry.Term {
+			w.reply <- reply{result: res}
+		} else {
+			w.reply <- reply{err: errDropped}
+		}
+	}
+}
+
+// failWaiters answers every pending proposal with err. Their entries may
+// still commit; the Session retries them, and the state machine applies
+// them once.
+func (n *Node) failWaiters(err error) {
+	for index, w := range n.waiters {
+		w.reply <- reply{err: err, leader: n.leader}
+		delete(n.waiters, index)
+	}
+}
@@ -0,0 +1,309 @@
+package replicated
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+const tick = 2 * time.Millisecond
+
+// cluster starts size nodes on a Network and stops them with the test.
+func cluster(t *testing.T, size int) (*Network, []*Node) {
+	t.Helper()
+	nw := NewNetwork()
+	peers := make([]int, size)
+	for i := range peers {
+		peers[i] = i + 1
+	}
+	nodes := make([]*Node, size)
+	for i := range nodes {
+		nodes[i] = NewNode(peers[i], peers, nw, WithTick(tick))
+		nw.Add(nodes[i])
+	}
+	for _, n := range nodes {
+		n.Start()
+	}
+	t.Cleanup(func() {
+		for _, n := range nodes {
+			n.Stop()
+		}
+	})
+	return nw, nodes
+}
+
+// waitLeader waits until exactly one of nodes leads, and all others that
+// are not excluded follow it in its term.
+func waitLeader(t *testing.T, nodes []*Node, excluded ...*Node) *Node {
+	t.Helper()
+	var leader *Node
+	require.Eventually(t, func() bool {
+		leader = nil
+		var term uint64
+		for _, n := range nodes {
+			if n.Status().Role == Leader && !contains(excluded, n) {
+				if leader != nil {
+					return false
+				}
+				leader, term = n, n.Status().Term
+			}
+		}
+		if leader == nil {
+			return false
+		}
+		for _, n := range nodes {
+			if !contains(excluded, n) && (n.Status().Leader != leader.id || n.Status().Term != term) {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, tick)
+	return leader
+}
+
+func contains(nodes []*Node, n *Node) bool {
+	for _, m := range nodes {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMachine(t *testing.T) {
+	m := newMachine()
+	m.apply(Command{Op: OpPush, Value: 1, Client: 1, Seq: 1})
+	m.apply(Command{Op: OpPush, Value: 2, Client: 1, Seq: 2})
+	// a retried push is not applied again
+	m.apply(Command{Op: OpPush, Value: 2, Client: 1, Seq: 2})
+	assert.Equal(t, 2, m.queue.Len())
+
+	pop := Command{Op: OpPop, Client: 2, Seq: 1}
+	assert.Equal(t, result{1, true}, m.apply(pop))
+	// a retried pop returns the value of the first one
+	assert.Equal(t, result{1, true}, m.apply(pop))
+	assert.Equal(t, result{2, true}, m.apply(Command{Op: OpPop, Client: 2, Seq: 2}))
+	assert.Equal(t, result{}, m.apply(Command{Op: OpPop, Client: 2, Seq: 3}))
+	assert.Equal(t, result{}, m.apply(Command{Op: OpNoop}))
+}
+
+func TestSingleNode(t *testing.T) {
+	_, nodes := cluster(t, 1)
+	waitLeader(t, nodes)
+
+	s := NewSession(nodes)
+	ctx := context.Background()
+	require.NoError(t, s.Push(ctx, 1))
+	value, ok, err := s.Pop(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+}
+
+func TestCluster(t *testing.T) {
+	const count = 50
+
+	_, nodes := cluster(t, 3)
+	waitLeader(t, nodes)
+
+	ctx := context.Background()
+	s := NewSession(nodes)
+	for i := 0; i < count; i++ {
+		require.NoError(t, s.Push(ctx, i))
+	}
+	for i := 0; i < count/2; i++ {
+		value, ok, err := s.Pop(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, i, value)
+	}
+
+	// every node applies the same commands
+	require.Eventually(t, func() bool {
+		for _, n := range nodes {
+			if n.Len() != count/2 || n.Status().Applied != nodes[0].Status().Applied {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, tick)
+}
+
+func TestFailover(t *testing.T) {
+	const count = 30
+
+	nw, nodes := cluster(t, 3)
+	old := waitLeader(t, nodes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	s := NewSession(nodes, WithAttemptTimeout(50*time.Millisecond))
+	for i := 0; i < count; i++ {
+		require.NoError(t, s.Push(ctx, i))
+	}
+
+	nw.Disconnect(old.id)
+	leader := waitLeader(t, nodes, old)
+	assert.NotEqual(t, old.id, leader.id)
+
+	// the queue survives with the majority
+	for i := 0; i < count; i++ {
+		value, ok, err := s.Pop(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, i, value)
+	}
+	_, ok, err := s.Pop(ctx)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	require.NoError(t, s.Push(ctx, count))
+
+	// the old leader steps down and catches up once it is back
+	nw.Reconnect(old.id)
+	require.Eventually(t, func() bool {
+		return old.Status().Role == Follower && old.Status().Applied == leader.Status().Applied && old.Len() == 1
+	}, 5*time.Second, tick)
+}
+
+func TestStoppedLeader(t *testing.T) {
+	_, nodes := cluster(t, 3)
+	old := waitLeader(t, nodes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	s := NewSession(nodes, WithAttemptTimeout(50*time.Millisecond))
+	require.NoError(t, s.Push(ctx, 1))
+	old.Stop()
+
+	require.NoError(t, s.Push(ctx, 2))
+	for _, want := range []int{1, 2} {
+		value, ok, err := s.Pop(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, want, value)
+	}
+
+	r := old.propose(ctx, Command{Op: OpPush})
+	assert.ErrorIs(t, r.err, ErrStopped)
+}
+
+// sessions pop concurrently while the leader keeps being cut off; retried
+// pops must neither lose nor duplicate a value
+func TestExactlyOncePops(t *testing.T) {
+	const (
+		count    = 100
+		sessions = 4
+	)
+
+	nw, nodes := cluster(t, 3)
+	waitLeader(t, nodes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	producer := NewSession(nodes)
+	for i := 0; i < count; i++ {
+		require.NoError(t, producer.Push(ctx, i))
+	}
+
+	stop := make(chan struct{})
+	chaos := sync.WaitGroup{}
+	chaos.Add(1)
+	go func() {
+		defer chaos.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(40 * time.Millisecond):
+			}
+			for _, n := range nodes {
+				if n.Status().Role == Leader {
+					nw.Disconnect(n.id)
+					time.Sleep(30 * time.Millisecond)
+					nw.Reconnect(n.id)
+					break
+				}
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	popped := map[int]int{}
+	wg := sync.WaitGroup{}
+	wg.Add(sessions)
+	for i := 0; i < sessions; i++ {
+		go func() {
+			defer wg.Done()
+			s := NewSession(nodes, WithAttemptTimeout(20*time.Millisecond))
+			for {
+				value, ok, err := s.Pop(ctx)
+				if !assert.NoError(t, err) || !ok {
+					return
+				}
+				mu.Lock()
+				popped[value]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	chaos.Wait()
+
+	assert.Len(t, popped, count)
+	for value, n := range popped {
+		assert.Equal(t, 1, n, "value %d", value)
+	}
+}
+
+type discard struct{}
+
+func (discard) Send(Message) {}
+
+// an append delivered late matches fewer entries than the follower has
+// committed meanwhile; its commit index must not move back
+func TestStaleAppend(t *testing.T) {
+	n := NewNode(2, []int{1, 2, 3}, discard{})
+	entries := []Entry{{Term: 1}, {Term: 1}, {Term: 1}}
+	n.step(Message{Type: MsgApp, From: 1, To: 2, Term: 1, Entries: entries, Commit: 2})
+	assert.Equal(t, uint64(2), n.commit)
+
+	// sent after the leader committed 3, but only up to entry 1
+	n.step(Message{Type: MsgApp, From: 1, To: 2, Term: 1, Entries: entries[:1], Commit: 3})
+	assert.Equal(t, uint64(2), n.commit)
+	n.step(Message{Type: MsgApp, From: 1, To: 2, Term: 1, LogIndex: 3, LogTerm: 1, Commit: 3})
+	assert.Equal(t, uint64(3), n.commit)
+	assert.Equal(t, uint64(3), n.lastIndex())
+}
+
+type recording struct {
+	sent []Message
+}
+
+func (r *recording) Send(msg Message) {
+	r.sent = append(r.sent, msg)
+}
+
+// a vote of a higher term moves a node to that term, but only a granted
+// vote resets its election timer
+func TestVoteResetsTimer(t *testing.T) {
+	transport := &recording{}
+	n := NewNode(2, []int{1, 2, 3}, transport)
+	n.step(Message{Type: MsgApp, From: 1, To: 2, Term: 1, Entries: []Entry{{Term: 1}, {Term: 1}}})
+	n.onTick()
+	n.onTick()
+
+	// node 3 missed the entries of term 1
+	n.step(Message{Type: MsgVote, From: 3, To: 2, Term: 2})
+	assert.Equal(t, uint64(2), n.term)
+	assert.Equal(t, Follower, n.role)
+	assert.True(t, transport.sent[len(transport.sent)-1].Reject)
+	assert.Equal(t, 2, n.elapsed, "a rejected vote must not reset the timer")
+
+	n.step(Message{Type: MsgVote, From: 1, To: 2, Term: 3, LogIndex: 2, LogTerm: 1})
+	assert.False(t, transport.sent[len(transport.sent)-1].Reject)
+	assert.Equal(t, 0, n.elapsed)
+}
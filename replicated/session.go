@@ -0,0 +1,104 @@
+package replicated
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Session sends the commands of one client to the cluster. Each command
+// gets the next sequence number of the session and keeps it across
+// retries, so it takes effect once even when a leader fails after
+// committing it but before answering. Calls of a Session are serialized;
+// use a Session per goroutine for concurrent clients.
+type Session struct {
+	mu      sync.Mutex
+	nodes   []*Node
+	id      uint64
+	seq     uint64
+	current int
+	attempt time.Duration
+}
+
+// SessionOption configures a Session created by NewSession.
+type SessionOption func(*Session)
+
+// WithAttemptTimeout bounds every try of a command on one node, 500ms by
+// default. A node that does not answer in time, for instance a leader cut
+// off from the majority, is given up for the next one.
+func WithAttemptTimeout(d time.Duration) SessionOption {
+	return func(s *Session) {
+		s.attempt = d
+	}
+}
+
+// NewSession returns a session with a random id, talking to nodes.
+func NewSession(nodes []*Node, opts ...SessionOption) *Session {
+	s := &Session{
+		nodes:   nodes,
+		id:      rand.Uint64() | 1,
+		attempt: 500 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Push appends value to the replicated queue once a majority has it.
+func (s *Session) Push(ctx context.Context, value int) error {
+	_, err := s.do(ctx, Command{Op: OpPush, Value: value})
+	return err
+}
+
+// Pop removes the oldest value. ok is false if the queue was empty.
+func (s *Session) Pop(ctx context.Context) (value int, ok bool, err error) {
+	res, err := s.do(ctx, Command{Op: OpPop})
+	return res.value, res.ok, err
+}
+
+// do retries cmd on the nodes until one of them applied it as leader, or
+// ctx ends. A command cut short by ctx may still take effect.
+func (s *Session) do(ctx context.Context, cmd Command) (result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	cmd.Client, cmd.Seq = s.id, s.seq
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, s.attempt)
+		r := s.nodes[s.current].propose(attemptCtx, cmd)
+		cancel()
+		if r.err == nil {
+			return r.result, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return result{}, err
+		}
+
+		if next, ok := s.indexOf(r.leader); ok && next != s.current {
+			s.current = next
+			continue
+		}
+		s.current = (s.current + 1) % len(s.nodes)
+		if errors.Is(r.err, errNotLeader) || errors.Is(r.err, ErrStopped) {
+			// no leader known, give the election a moment
+			select {
+			case <-ctx.Done():
+				return result{}, ctx.Err()
+			case <-time.After(s.nodes[s.current].tick):
+			}
+		}
+	}
+}
+
+func (s *Session) indexOf(id int) (int, bool) {
+	for i, n := range s.nodes {
+		if n.id == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
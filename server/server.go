@@ -0,0 +1,295 @@
+// Package server hosts named Queue, Stack and Deque instances for other
+// processes, speaking the protocol of internal/wire over any net.Listener,
+// TCP and Unix sockets alike. Command treiberd runs it; package client
+// talks to it.
+//
+// A container is created by the first request naming it, with the kind of
+// that request; requests of another kind for the same name fail.
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/peletor/treiber/deque"
+	"github.com/peletor/treiber/internal/wire"
+	"github.com/peletor/treiber/queue"
+	"github.com/peletor/treiber/stack"
+)
+
+var ErrServerClosed = errors.New("server: closed")
+
+// Server is safe for concurrent use. The zero Server is not; use NewServer.
+type Server struct {
+	mu         sync.Mutex
+	containers map[string]*container
+	listeners  map[net.Listener]struct{}
+	conns      map[net.Conn]struct{}
+	closed     bool
+	done       chan struct{}
+	wg         sync.WaitGroup
+}
+
+func NewServer() *Server {
+	return &Server{
+		containers: map[string]*container{},
+		listeners:  map[net.Listener]struct{}{},
+		conns:      map[net.Conn]struct{}{},
+		done:       make(chan struct{}),
+	}
+}
+
+// Serve accepts connections on l and serves each on its own goroutine. It
+// returns ErrServerClosed after Close, and the error of Accept otherwise.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Close stops the listeners, ends waiting PopWaits, closes the connections
+// and waits for their goroutines. The containers are dropped with the
+// Server.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.closed = true
+	close(s.done)
+	var err error
+	for l := range s.listeners {
+		err = errors.Join(err, l.Close())
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		req, err := wire.ReadRequest(r)
+		if err != nil {
+			// EOF, a closed connection or a malformed frame: the stream is
+			// of no further use
+			return
+		}
+		if err := wire.WriteResponse(w, s.handle(req)); err != nil {
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) handle(req wire.Request) wire.Response {
+	c, err := s.container(req.Name, req.Kind)
+	if err != nil {
+		return wire.Response{Status: wire.StatusError, Message: err.Error()}
+	}
+	if req.End != wire.Back && req.End != wire.Front {
+		return wire.Response{Status: wire.StatusError, Message: fmt.Sprintf("unknown end %d", req.End)}
+	}
+
+	var value int
+	ok := true
+	switch req.Op {
+	case wire.OpPush:
+		c.push(req.End, int(req.Arg))
+	case wire.OpPop:
+		value, ok = c.pop(req.End)
+	case wire.OpPopWait:
+		value, ok = c.popWait(req.End, time.Duration(req.Arg), s.done)
+	case wire.OpPeek:
+		value, ok = c.peek(req.End)
+	case wire.OpLen:
+		value = c.len()
+	default:
+		return wire.Response{Status: wire.StatusError, Message: fmt.Sprintf("unknown op %d", req.Op)}
+	}
+	if !ok {
+		return wire.Response{Status: wire.StatusEmpty}
+	}
+	return wire.Response{Status: wire.StatusOK, Value: int64(value)}
+}
+
+// container returns the container called name, creating it with kind if
+// there is none yet.
+func (s *Server) container(name string, kind wire.Kind) (*container, error) {
+	if name == "" {
+		return nil, errors.New("empty name")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.containers[name]; ok {
+		if c.kind != kind {
+			return nil, fmt.Errorf("%q is a %v, not a %v", name, c.kind, kind)
+		}
+		return c, nil
+	}
+	c, err := newContainer(kind)
+	if err != nil {
+		return nil, err
+	}
+	s.containers[name] = c
+	return c, nil
+}
+
+// container adapts the three container types to the operations of the
+// protocol, and lets PopWait sleep until the next push.
+type container struct {
+	kind wire.Kind
+	push func(end wire.End, value int)
+	pop  func(end wire.End) (int, bool)
+	peek func(end wire.End) (int, bool)
+	len  func() int
+
+	// waiters counts the PopWaits sleeping on pushed, so pushes only take
+	// mu when someone waits.
+	waiters atomic.Int32
+	mu      sync.Mutex
+	pushed  chan struct{}
+}
+
+func newContainer(kind wire.Kind) (*container, error) {
+	c := &container{kind: kind, pushed: make(chan struct{})}
+	var push func(wire.End, int)
+	switch kind {
+	case wire.KindQueue:
+		que := queue.NewQueue()
+		push = func(_ wire.End, value int) { que.Push(value) }
+		c.pop = func(wire.End) (int, bool) { return que.Pop() }
+		c.peek = func(wire.End) (int, bool) { return que.Peek() }
+		c.len = que.Len
+	case wire.KindStack:
+		stk := stack.NewStack()
+		push = func(_ wire.End, value int) { stk.Push(value) }
+		c.pop = func(wire.End) (int, bool) { return stk.Pop() }
+		c.peek = func(wire.End) (int, bool) { return stk.Top() }
+		c.len = stk.Len
+	case wire.KindDeque:
+		deq := deque.NewDeque()
+		push = func(end wire.End, value int) {
+			if end == wire.Front {
+				deq.PushFront(value)
+			} else {
+				deq.PushBack(value)
+			}
+		}
+		c.pop = func(end wire.End) (int, bool) {
+			if end == wire.Front {
+				return deq.PopFront()
+			}
+			return deq.PopBack()
+		}
+		c.peek = func(end wire.End) (int, bool) {
+			if end == wire.Front {
+				return deq.PeekFront()
+			}
+			return deq.PeekBack()
+		}
+		c.len = deq.Len
+	default:
+		return nil, fmt.Errorf("unknown kind %d", kind)
+	}
+
+	c.push = func(end wire.End, value int) {
+		push(end, value)
+		if c.waiters.Load() > 0 {
+			c.wake()
+		}
+	}
+	return c, nil
+}
+
+// wake releases every sleeping PopWait; they race for the new values.
+func (c *container) wake() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	close(c.pushed)
+	c.pushed = make(chan struct{})
+}
+
+// popWait pops, sleeping until a push, the timeout or done when the
+// container is empty.
+func (c *container) popWait(end wire.End, timeout time.Duration, done <-chan struct{}) (int, bool) {
+	if value, ok := c.pop(end); ok || timeout <= 0 {
+		return value, ok
+	}
+
+	c.waiters.Add(1)
+	defer c.waiters.Add(-1)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		c.mu.Lock()
+		pushed := c.pushed
+		c.mu.Unlock()
+		// a push between the last pop and taking pushed would not wake us
+		if value, ok := c.pop(end); ok {
+			return value, true
+		}
+
+		select {
+		case <-pushed:
+		case <-timer.C:
+			return c.pop(end)
+		case <-done:
+			return 0, false
+		}
+	}
+}
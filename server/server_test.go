@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bufio"
+	"github.com/peletor/treiber/internal/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T) (*Server, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := NewServer()
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return srv, l.Addr().String()
+}
+
+type conn struct {
+	t *testing.T
+	net.Conn
+	r *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *conn {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return &conn{t: t, Conn: c, r: bufio.NewReader(c)}
+}
+
+func (c *conn) do(req wire.Request) wire.Response {
+	c.t.Helper()
+	require.NoError(c.t, wire.WriteRequest(c, req))
+	resp, err := wire.ReadResponse(c.r)
+	require.NoError(c.t, err)
+	return resp
+}
+
+func TestServer(t *testing.T) {
+	_, addr := serve(t)
+	c := dial(t, addr)
+
+	t.Run("Queue", func(t *testing.T) {
+		assert.Equal(t, wire.StatusOK, c.do(wire.Request{Op: wire.OpPush, Kind: wire.KindQueue, Name: "q", Arg: 1}).Status)
+		assert.Equal(t, wire.StatusOK, c.do(wire.Request{Op: wire.OpPush, Kind: wire.KindQueue, Name: "q", Arg: 2}).Status)
+		assert.Equal(t, wire.Response{Value: 2}, c.do(wire.Request{Op: wire.OpLen, Kind: wire.KindQueue, Name: "q"}))
+		assert.Equal(t, wire.Response{Value: 1}, c.do(wire.Request{Op: wire.OpPeek, Kind: wire.KindQueue, Name: "q"}))
+		assert.Equal(t, wire.Response{Value: 1}, c.do(wire.Request{Op: wire.OpPop, Kind: wire.KindQueue, Name: "q"}))
+		assert.Equal(t, wire.Response{Value: 2}, c.do(wire.Request{Op: wire.OpPop, Kind: wire.KindQueue, Name: "q"}))
+		assert.Equal(t, wire.StatusEmpty, c.do(wire.Request{Op: wire.OpPop, Kind: wire.KindQueue, Name: "q"}).Status)
+	})
+
+	t.Run("Deque ends", func(t *testing.T) {
+		c.do(wire.Request{Op: wire.OpPush, Kind: wire.KindDeque, End: wire.Back, Name: "d", Arg: 2})
+		c.do(wire.Request{Op: wire.OpPush, Kind: wire.KindDeque, End: wire.Front, Name: "d", Arg: 1})
+		assert.Equal(t, wire.Response{Value: 1}, c.do(wire.Request{Op: wire.OpPeek, Kind: wire.KindDeque, End: wire.Front, Name: "d"}))
+		assert.Equal(t, wire.Response{Value: 2}, c.do(wire.Request{Op: wire.OpPop, Kind: wire.KindDeque, End: wire.Back, Name: "d"}))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		resp := c.do(wire.Request{Op: wire.OpPop, Kind: wire.KindStack, Name: "q"})
+		assert.Equal(t, wire.StatusError, resp.Status)
+		assert.Equal(t, `"q" is a queue, not a stack`, resp.Message)
+
+		assert.Equal(t, wire.StatusError, c.do(wire.Request{Op: wire.OpPop, Kind: wire.KindQueue}).Status)
+		assert.Equal(t, wire.StatusError, c.do(wire.Request{Op: wire.OpPop, Kind: 9, Name: "x"}).Status)
+		assert.Equal(t, wire.StatusError, c.do(wire.Request{Op: 9, Kind: wire.KindQueue, Name: "q"}).Status)
+		assert.Equal(t, wire.StatusError, c.do(wire.Request{Op: wire.OpPop, Kind: wire.KindDeque, End: 7, Name: "d"}).Status)
+
+		// the connection is still usable
+		assert.Equal(t, wire.StatusEmpty, c.do(wire.Request{Op: wire.OpPop, Kind: wire.KindQueue, Name: "q"}).Status)
+	})
+
+	t.Run("Malformed frame", func(t *testing.T) {
+		bad := dial(t, addr)
+		_, err := bad.Write([]byte{0, 0, 0, 1, 0})
+		require.NoError(t, err)
+		_, err = wire.ReadResponse(bad.r)
+		assert.Error(t, err)
+	})
+}
+
+func TestPopWait(t *testing.T) {
+	_, addr := serve(t)
+	waiter, pusher := dial(t, addr), dial(t, addr)
+
+	t.Run("Timeout", func(t *testing.T) {
+		start := time.Now()
+		resp := waiter.do(wire.Request{Op: wire.OpPopWait, Kind: wire.KindStack, Name: "s", Arg: int64(50 * time.Millisecond)})
+		assert.Equal(t, wire.StatusEmpty, resp.Status)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("Woken by a push", func(t *testing.T) {
+		result := make(chan wire.Response)
+		go func() {
+			result <- waiter.do(wire.Request{Op: wire.OpPopWait, Kind: wire.KindStack, Name: "s", Arg: int64(time.Minute)})
+		}()
+		time.Sleep(20 * time.Millisecond)
+		pusher.do(wire.Request{Op: wire.OpPush, Kind: wire.KindStack, Name: "s", Arg: 7})
+		assert.Equal(t, wire.Response{Value: 7}, <-result)
+	})
+}
+
+func TestClose(t *testing.T) {
+	srv, addr := serve(t)
+	waiter := dial(t, addr)
+
+	done := make(chan error)
+	go func() {
+		require.NoError(t, wire.WriteRequest(waiter, wire.Request{Op: wire.OpPopWait, Kind: wire.KindQueue, Name: "q", Arg: int64(time.Minute)}))
+		_, err := wire.ReadResponse(waiter.r)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	require.NoError(t, srv.Close())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("PopWait outlived Close")
+	}
+	assert.ErrorIs(t, srv.Close(), ErrServerClosed)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	assert.ErrorIs(t, srv.Serve(l), ErrServerClosed)
+}
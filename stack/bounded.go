@@ -0,0 +1,251 @@
+package stack
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/peletor/treiber/backoff"
+	"github.com/peletor/treiber/internal/nocopy"
+	"github.com/peletor/treiber/internal/sched"
+	"github.com/peletor/treiber/internal/stats"
+	"github.com/peletor/treiber/tracing"
+)
+
+// boundedItem knows the depth of the stack it tops, so the capacity check
+// and the push are one CAS on head.
+type boundedItem struct {
+	value int
+	// depth is the number of items from this one down, itself included.
+	// Below an item pushed with WithDropOldest onto a full stack, the
+	// items further down than depth are dropped.
+	depth int
+	next  unsafe.Pointer
+}
+
+// BoundedStack is a Treiber stack with a capacity. TryPush fails on a full
+// stack and PushWait waits for a Pop to make room, unless the stack was
+// created WithDropOldest.
+//
+// Create it with NewBoundedStack; it must not be copied after first use.
+type BoundedStack struct {
+	_          nocopy.NoCopy
+	head       unsafe.Pointer
+	capacity   int
+	dropOldest bool
+	// drops counts the items dropped, see truncate
+	drops atomic.Uint64
+
+	// waiters counts the PushWaits sleeping on popped, so pops only take
+	// mu when someone waits.
+	waiters atomic.Int32
+	mu      sync.Mutex
+	popped  chan struct{}
+
+	stats   stats.Counters
+	backoff backoff.Backoff
+	tracer  tracing.Tracer
+}
+
+// BoundedOption configures a BoundedStack created by NewBoundedStack.
+type BoundedOption func(*BoundedStack)
+
+// WithDropOldest makes a push to a full stack drop the oldest item, at the
+// bottom, instead of failing: TryPush always succeeds and PushWait never
+// waits. The first pops after a drop allocate, as they install copies of
+// the items below with their new depths.
+func WithDropOldest() BoundedOption {
+	return func(s *BoundedStack) {
+		s.dropOldest = true
+	}
+}
+
+// WithStackOptions takes the options of NewStack: WithBackoff and
+// WithTracer configure the BoundedStack as they do a Stack.
+func WithStackOptions(opts ...Option) BoundedOption {
+	return func(s *BoundedStack) {
+		var settings Stack
+		settings.backoff, settings.tracer = s.backoff, s.tracer
+		for _, opt := range opts {
+			opt(&settings)
+		}
+		s.backoff, s.tracer = settings.backoff, settings.tracer
+	}
+}
+
+// NewBoundedStack returns a stack that holds up to capacity items. It
+// panics if capacity is less than one.
+func NewBoundedStack(capacity int, opts ...BoundedOption) *BoundedStack {
+	if capacity < 1 {
+		panic("stack: capacity of BoundedStack must be at least one")
+	}
+	s := &BoundedStack{capacity: capacity, popped: make(chan struct{})}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// TryPush pushes value, and reports false if the stack is full.
+func (s *BoundedStack) TryPush(value int) bool {
+	span := tracing.Start(s.tracer, "BoundedStack.TryPush")
+	defer tracing.End(span)
+
+	return s.tryPush(span, value)
+}
+
+func (s *BoundedStack) tryPush(span tracing.Span, value int) bool {
+	newNode := &boundedItem{value: value}
+
+	for attempt := 0; ; attempt++ {
+		head := sched.LoadPointer(&s.head)
+		depth := 0
+		if head != nil {
+			depth = (*boundedItem)(head).depth
+		}
+		dropping := depth == s.capacity
+		if dropping && !s.dropOldest {
+			return false
+		}
+
+		newNode.next = head
+		newNode.depth = min(depth+1, s.capacity)
+		if sched.CompareAndSwapPointer(&s.head, head, unsafe.Pointer(newNode)) {
+			if dropping {
+				s.truncate(newNode)
+			}
+			s.stats.Push()
+			return true
+		}
+		s.stats.CASFailure()
+		s.retry(span, attempt)
+	}
+}
+
+// PushWait pushes value, waiting for a Pop to make room if the stack is
+// full. It returns ctx.Err() if ctx is done first.
+func (s *BoundedStack) PushWait(ctx context.Context, value int) error {
+	span := tracing.Start(s.tracer, "BoundedStack.PushWait")
+	defer tracing.End(span)
+
+	if s.tryPush(span, value) {
+		return nil
+	}
+
+	s.waiters.Add(1)
+	defer s.waiters.Add(-1)
+	for {
+		s.mu.Lock()
+		popped := s.popped
+		s.mu.Unlock()
+		// a pop between the last push and taking popped would not wake us
+		if s.tryPush(span, value) {
+			return nil
+		}
+
+		select {
+		case <-popped:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// truncate unlinks the items dropped below newNode, which was pushed onto
+// a full stack. Walking down to them costs capacity steps, so it only does
+// so once every capacity drops, and up to twice the capacity of items are
+// kept alive in between.
+func (s *BoundedStack) truncate(newNode *boundedItem) {
+	if s.drops.Add(1)%uint64(s.capacity) != 0 {
+		return
+	}
+	// the bottom item of newNode: no pop below newNode reads its next, as
+	// that pop leaves the stack empty
+	bottom := unsafe.Pointer(newNode)
+	for i := 1; i < s.capacity && bottom != nil; i++ {
+		bottom = sched.LoadPointer(&(*boundedItem)(bottom).next)
+	}
+	if bottom == nil {
+		return
+	}
+	if next := sched.LoadPointer(&(*boundedItem)(bottom).next); next != nil {
+		sched.CompareAndSwapPointer(&(*boundedItem)(bottom).next, next, nil)
+	}
+}
+
+func (s *BoundedStack) Pop() (value int, ok bool) {
+	span := tracing.Start(s.tracer, "BoundedStack.Pop")
+	defer tracing.End(span)
+
+	for attempt := 0; ; attempt++ {
+		head := sched.LoadPointer(&s.head)
+		if head == nil {
+			s.stats.EmptyPop()
+			return 0, false
+		}
+
+		next, ok := below((*boundedItem)(head))
+		if ok && sched.CompareAndSwapPointer(&s.head, head, next) {
+			s.stats.Pop()
+			if s.waiters.Load() > 0 {
+				s.wake()
+			}
+			return (*boundedItem)(head).value, true
+		}
+		s.stats.CASFailure()
+		s.retry(span, attempt)
+	}
+}
+
+func (s *BoundedStack) retry(span tracing.Span, attempt int) {
+	tracing.Retry(span, attempt)
+	if s.backoff != nil {
+		s.backoff.Wait(attempt)
+	}
+}
+
+// below returns the new head for a pop of item: its next, or a copy of it
+// with the right depth if items were dropped below item. ok is false if
+// item is not the head anymore, and was truncated.
+func below(item *boundedItem) (next unsafe.Pointer, ok bool) {
+	if item.depth == 1 {
+		return nil, true
+	}
+	next = sched.LoadPointer(&item.next)
+	if next == nil {
+		return nil, false
+	}
+	n := (*boundedItem)(next)
+	if n.depth == item.depth-1 {
+		return next, true
+	}
+	return unsafe.Pointer(&boundedItem{value: n.value, depth: item.depth - 1, next: sched.LoadPointer(&n.next)}), true
+}
+
+// wake releases every sleeping PushWait; they race for the room.
+func (s *BoundedStack) wake() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.popped)
+	s.popped = make(chan struct{})
+}
+
+// Len returns the number of items. Unlike Stack.Len it is exact, and O(1).
+func (s *BoundedStack) Len() int {
+	head := sched.LoadPointer(&s.head)
+	if head == nil {
+		return 0
+	}
+	return (*boundedItem)(head).depth
+}
+
+func (s *BoundedStack) Cap() int {
+	return s.capacity
+}
+
+// Stats returns a snapshot of the counters of the stack.
+// The counters are only maintained when built with the treiberstats tag.
+func (s *BoundedStack) Stats() Stats {
+	return s.stats.Snapshot()
+}
@@ -0,0 +1,239 @@
+package stack
+
+import (
+	"context"
+	"github.com/peletor/treiber"
+	"github.com/peletor/treiber/backoff"
+	"github.com/peletor/treiber/containertest"
+	"github.com/peletor/treiber/internal/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBoundedStack(t *testing.T) {
+	const capacity = 3
+
+	t.Run("TryPush fails when full", func(t *testing.T) {
+		s := NewBoundedStack(capacity)
+		for i := 0; i < capacity; i++ {
+			assert.True(t, s.TryPush(i))
+		}
+		assert.False(t, s.TryPush(capacity))
+		assert.Equal(t, capacity, s.Len())
+		assert.Equal(t, capacity, s.Cap())
+
+		for i := capacity - 1; i >= 0; i-- {
+			value, ok := s.Pop()
+			assert.True(t, ok)
+			assert.Equal(t, i, value)
+		}
+		_, ok := s.Pop()
+		assert.False(t, ok)
+		assert.Equal(t, 0, s.Len())
+	})
+
+	t.Run("PushWait waits for a Pop", func(t *testing.T) {
+		s := NewBoundedStack(1)
+		ctx := context.Background()
+		require.NoError(t, s.PushWait(ctx, 1))
+
+		pushed := make(chan error)
+		go func() { pushed <- s.PushWait(ctx, 2) }()
+		select {
+		case <-pushed:
+			t.Fatal("PushWait returned on a full stack")
+		case <-time.After(10 * time.Millisecond):
+		}
+		value, _ := s.Pop()
+		assert.Equal(t, 1, value)
+		assert.NoError(t, <-pushed)
+		value, _ = s.Pop()
+		assert.Equal(t, 2, value)
+
+		s.TryPush(3)
+		timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, s.PushWait(timeout, 4), context.DeadlineExceeded)
+		assert.Equal(t, 1, s.Len())
+	})
+
+	t.Run("Drop oldest", func(t *testing.T) {
+		s := NewBoundedStack(capacity, WithDropOldest())
+		for i := 0; i < 5; i++ {
+			assert.True(t, s.TryPush(i))
+			assert.Equal(t, min(i+1, capacity), s.Len())
+		}
+		assert.NoError(t, s.PushWait(context.Background(), 5))
+
+		for _, want := range []int{5, 4, 3} {
+			value, ok := s.Pop()
+			assert.True(t, ok)
+			assert.Equal(t, want, value)
+		}
+		_, ok := s.Pop()
+		assert.False(t, ok, "dropped items must not come back")
+
+		// a push after pops sees the new depth
+		s.TryPush(6)
+		s.TryPush(7)
+		assert.Equal(t, 2, s.Len())
+	})
+
+	t.Run("Dropped items are released", func(t *testing.T) {
+		s := NewBoundedStack(capacity, WithDropOldest())
+		for i := 0; i < 100*capacity; i++ {
+			s.TryPush(i)
+		}
+		items := 0
+		for item := s.head; item != nil; item = (*boundedItem)(item).next {
+			items++
+		}
+		assert.LessOrEqual(t, items, 2*capacity)
+	})
+
+	t.Run("Capacity", func(t *testing.T) {
+		assert.Panics(t, func() { NewBoundedStack(0) })
+	})
+}
+
+// boundedContainer pushes with TryPush; the conformance tests never hold
+// more values than its capacity, so a full stack fails the test.
+type boundedContainer struct {
+	*BoundedStack
+	t *testing.T
+}
+
+func (b boundedContainer) Push(value int) {
+	if !b.TryPush(value) {
+		b.t.Errorf("TryPush(%d) failed with %d of %d values", value, b.Len(), b.Cap())
+	}
+}
+
+func TestBoundedStackConformance(t *testing.T) {
+	const capacity = 1024
+
+	t.Run("Reject", func(t *testing.T) {
+		containertest.TestContainer(t, containertest.LIFO, func() treiber.Container[int] {
+			return boundedContainer{NewBoundedStack(capacity), t}
+		}, containertest.Int)
+	})
+
+	t.Run("Drop oldest", func(t *testing.T) {
+		containertest.TestContainer(t, containertest.LIFO, func() treiber.Container[int] {
+			return boundedContainer{NewBoundedStack(capacity, WithDropOldest()), t}
+		}, containertest.Int)
+	})
+}
+
+func TestBoundedStackOptions(t *testing.T) {
+	t.Run("Backoff", func(t *testing.T) {
+		containertest.TestContainer(t, containertest.LIFO, func() treiber.Container[int] {
+			return boundedContainer{NewBoundedStack(1024, WithStackOptions(WithBackoff(backoff.Exponential{}))), t}
+		}, containertest.Int)
+	})
+
+	t.Run("Tracer and stats", func(t *testing.T) {
+		tracer := &recordingTracer{}
+		s := NewBoundedStack(1, WithStackOptions(WithTracer(tracer)))
+		s.TryPush(1)
+		s.TryPush(2)
+		s.Pop()
+		s.Pop()
+		assert.NoError(t, s.PushWait(context.Background(), 3))
+
+		assert.Equal(t, []string{"BoundedStack.TryPush", "BoundedStack.TryPush", "BoundedStack.Pop", "BoundedStack.Pop", "BoundedStack.PushWait"}, tracer.starts)
+
+		want := Stats{}
+		if stats.Enabled {
+			want = Stats{Pushes: 2, Pops: 1, EmptyPops: 1}
+		}
+		assert.Equal(t, want, s.Stats())
+	})
+}
+
+func TestBoundedStackConcurrency(t *testing.T) {
+	const (
+		capacity   = 16
+		goroutines = 8
+		count      = 2000
+	)
+
+	t.Run("PushWait", func(t *testing.T) {
+		s := NewBoundedStack(capacity)
+		var popped []int
+		var mu sync.Mutex
+		wg := sync.WaitGroup{}
+		wg.Add(2 * goroutines)
+		for g := 0; g < goroutines; g++ {
+			go func() {
+				defer wg.Done()
+				for i := 0; i < count; i++ {
+					assert.NoError(t, s.PushWait(context.Background(), g*count+i))
+					assert.LessOrEqual(t, s.Len(), capacity)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for n := 0; n < count; {
+					value, ok := s.Pop()
+					if !ok {
+						runtime.Gosched()
+						continue
+					}
+					mu.Lock()
+					popped = append(popped, value)
+					mu.Unlock()
+					n++
+				}
+			}()
+		}
+		wg.Wait()
+
+		sort.Ints(popped)
+		require.Len(t, popped, goroutines*count)
+		for i, value := range popped {
+			require.Equal(t, i, value)
+		}
+	})
+
+	t.Run("Drop oldest", func(t *testing.T) {
+		s := NewBoundedStack(capacity, WithDropOldest())
+		var pops atomic.Int64
+		seen := sync.Map{}
+		wg := sync.WaitGroup{}
+		wg.Add(2 * goroutines)
+		for g := 0; g < goroutines; g++ {
+			go func() {
+				defer wg.Done()
+				for i := 0; i < count; i++ {
+					s.TryPush(g*count + i)
+					assert.LessOrEqual(t, s.Len(), capacity)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for i := 0; i < count/2; i++ {
+					if value, ok := s.Pop(); ok {
+						_, dup := seen.LoadOrStore(value, true)
+						assert.False(t, dup, "value %d popped twice", value)
+						pops.Add(1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		remaining := s.Len()
+		for value, ok := s.Pop(); ok; value, ok = s.Pop() {
+			_, dup := seen.LoadOrStore(value, true)
+			assert.False(t, dup, "value %d popped twice", value)
+		}
+		assert.Equal(t, int64(goroutines*count), pops.Load()+int64(s.drops.Load())+int64(remaining))
+	})
+}
@@ -0,0 +1,264 @@
+package stack
+
+import (
+	"context"
+	"unsafe"
+
+	"github.com/peletor/treiber/backoff"
+	"github.com/peletor/treiber/internal/nocopy"
+	"github.com/peletor/treiber/internal/sched"
+	"github.com/peletor/treiber/internal/stats"
+	"github.com/peletor/treiber/tracing"
+)
+
+// dualItem is a value, or the reservation of a waiting PopWait. The value
+// for a reservation arrives in item, and ready is closed after it. A value
+// of TransferStack.Transfer has a ready too, closed when a consumer took
+// it.
+type dualItem struct {
+	isData bool
+	value  int
+	item   unsafe.Pointer
+	next   unsafe.Pointer
+	ready  chan struct{}
+}
+
+// cancelled is the item of a reservation whose PopWait gave up, or of a
+// value whose Transfer gave up; taken is the item of a transferred value.
+var (
+	cancelled = unsafe.Pointer(new(int))
+	taken     = unsafe.Pointer(new(int))
+)
+
+// DualStack is the dual stack of Scherer and Scott (DISC 2004): a Treiber
+// stack that holds either values or reservations. A PopWait on a stack
+// without values pushes a reservation and waits on it, and a Push hands its
+// value to the top reservation instead of pushing it. The consumer that
+// came last is served first, which makes it the unfair counterpart of
+// queue.DualQueue: under a steady load the same few consumers, whose
+// caches are warm, keep getting the work. There is no fair mode, the choice
+// of mode is the choice between the two.
+//
+// The zero DualStack is empty and ready to use; it must not be copied
+// after first use.
+type DualStack struct {
+	_    nocopy.NoCopy
+	head unsafe.Pointer
+
+	stats   stats.Counters
+	backoff backoff.Backoff
+	tracer  tracing.Tracer
+}
+
+// NewDualStack takes the options of NewStack.
+func NewDualStack(opts ...Option) *DualStack {
+	s := &DualStack{}
+	s.configure(opts)
+	return s
+}
+
+// configure applies opts to a Stack and takes over its settings.
+func (s *DualStack) configure(opts []Option) {
+	var settings Stack
+	for _, opt := range opts {
+		opt(&settings)
+	}
+	s.backoff = settings.backoff
+	s.tracer = settings.tracer
+}
+
+// Push gives value to the latest waiting PopWait, or pushes it if none is
+// waiting.
+func (s *DualStack) Push(value int) {
+	span := tracing.Start(s.tracer, "DualStack.Push")
+	defer tracing.End(span)
+
+	s.put(span, &dualItem{isData: true, value: value}, true)
+}
+
+// put hands the value of item to the top reservation, or pushes item if
+// the stack holds no reservations and push is set. It reports whether it
+// handed the value over.
+func (s *DualStack) put(span tracing.Span, item *dualItem, push bool) bool {
+	for attempt := 0; ; attempt++ {
+		head := sched.LoadPointer(&s.head)
+		if head == nil || (*dualItem)(head).isData {
+			if !push {
+				return false
+			}
+			if head != nil && sched.LoadPointer(&(*dualItem)(head).item) == cancelled {
+				// pop it, so values of Transfers that time out while no
+				// consumer comes do not pile up
+				sched.CompareAndSwapPointer(&s.head, head, sched.LoadPointer(&(*dualItem)(head).next))
+				s.stats.Helping()
+				continue
+			}
+			item.next = head
+			if sched.CompareAndSwapPointer(&s.head, head, unsafe.Pointer(item)) {
+				s.stats.Push()
+				return false
+			}
+			s.stats.CASFailure()
+			s.retry(span, attempt)
+			continue
+		}
+
+		// a reservation on top: fulfil it
+		r := (*dualItem)(head)
+		fulfilled := sched.CompareAndSwapPointer(&r.item, nil, unsafe.Pointer(&item.value))
+		// the reservation is done with, fulfilled by this push or another
+		// one, or cancelled. If a PopWait pushed over it meanwhile, it stays
+		// until a later Push finds it done.
+		sched.CompareAndSwapPointer(&s.head, head, sched.LoadPointer(&r.next))
+		if fulfilled {
+			s.stats.Push()
+			close(r.ready)
+			return true
+		}
+		s.stats.CASFailure()
+		s.retry(span, attempt)
+	}
+}
+
+// Pop removes the top value without waiting. ok is false if the stack
+// holds no values.
+func (s *DualStack) Pop() (value int, ok bool) {
+	span := tracing.Start(s.tracer, "DualStack.Pop")
+	defer tracing.End(span)
+
+	for attempt := 0; ; attempt++ {
+		head := sched.LoadPointer(&s.head)
+		if head == nil || !(*dualItem)(head).isData {
+			s.stats.EmptyPop()
+			return 0, false
+		}
+		if s.take(head) {
+			return (*dualItem)(head).value, true
+		}
+		s.retry(span, attempt)
+	}
+}
+
+// PopWait removes the top value, waiting for a Push if the stack holds no
+// values. It returns ctx.Err() if ctx is done first.
+func (s *DualStack) PopWait(ctx context.Context) (value int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	span := tracing.Start(s.tracer, "DualStack.PopWait")
+	defer tracing.End(span)
+
+	var r *dualItem
+	for attempt := 0; ; attempt++ {
+		head := sched.LoadPointer(&s.head)
+		if head == nil || !(*dualItem)(head).isData {
+			if head != nil && sched.LoadPointer(&(*dualItem)(head).item) == cancelled {
+				// pop it, so reservations of PopWaits that time out while no
+				// Push comes do not pile up
+				sched.CompareAndSwapPointer(&s.head, head, sched.LoadPointer(&(*dualItem)(head).next))
+				s.stats.Helping()
+				continue
+			}
+			// no values: push a reservation and wait
+			if r == nil {
+				r = &dualItem{ready: make(chan struct{})}
+			}
+			r.next = head
+			if sched.CompareAndSwapPointer(&s.head, head, unsafe.Pointer(r)) {
+				value, err := r.wait(ctx)
+				if err == nil {
+					s.stats.Pop()
+				}
+				return value, err
+			}
+			s.stats.CASFailure()
+			s.retry(span, attempt)
+			continue
+		}
+
+		if s.take(head) {
+			return (*dualItem)(head).value, nil
+		}
+		s.retry(span, attempt)
+	}
+}
+
+// take pops the value head and claims it.
+func (s *DualStack) take(head unsafe.Pointer) bool {
+	next := sched.LoadPointer(&(*dualItem)(head).next)
+	if !sched.CompareAndSwapPointer(&s.head, head, next) {
+		s.stats.CASFailure()
+		return false
+	}
+	if !(*dualItem)(head).claim() {
+		return false
+	}
+	s.stats.Pop()
+	return true
+}
+
+func (s *DualStack) retry(span tracing.Span, attempt int) {
+	tracing.Retry(span, attempt)
+	if s.backoff != nil {
+		s.backoff.Wait(attempt)
+	}
+}
+
+// claim takes the value of item for the consumer that popped it. It fails
+// for the value of a cancelled TransferStack.Transfer, and releases a
+// waiting one.
+func (item *dualItem) claim() bool {
+	if item.ready == nil {
+		// pushed, nobody waits
+		return true
+	}
+	if !sched.CompareAndSwapPointer(&item.item, nil, taken) {
+		return false
+	}
+	close(item.ready)
+	return true
+}
+
+// wait waits for a Push to fulfil the reservation r, or cancels it when
+// ctx is done. A cancelled reservation stays in the stack until a Push or
+// a PopWait skips it.
+func (r *dualItem) wait(ctx context.Context) (int, error) {
+	select {
+	case <-r.ready:
+	case <-ctx.Done():
+		if sched.CompareAndSwapPointer(&r.item, nil, cancelled) {
+			return 0, ctx.Err()
+		}
+		// fulfilled meanwhile, the value is ours
+	}
+	return *(*int)(sched.LoadPointer(&r.item)), nil
+}
+
+// Len counts the values by walking the stack. It is O(n), and under
+// concurrent pushes and pops the result is only an approximation.
+func (s *DualStack) Len() int {
+	return s.count(true)
+}
+
+// Waiting counts the PopWaits waiting for a value, with the same caveats
+// as Len.
+func (s *DualStack) Waiting() int {
+	return s.count(false)
+}
+
+func (s *DualStack) count(isData bool) int {
+	length := 0
+	for item := sched.LoadPointer(&s.head); item != nil; item = sched.LoadPointer(&(*dualItem)(item).next) {
+		i := (*dualItem)(item)
+		if i.isData == isData && sched.LoadPointer(&i.item) == nil {
+			length++
+		}
+	}
+	return length
+}
+
+// Stats returns a snapshot of the counters of the stack.
+// The counters are only maintained when built with the treiberstats tag.
+func (s *DualStack) Stats() Stats {
+	return s.stats.Snapshot()
+}
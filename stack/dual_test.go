@@ -0,0 +1,189 @@
+package stack
+
+import (
+	"context"
+	"github.com/peletor/treiber"
+	"github.com/peletor/treiber/backoff"
+	"github.com/peletor/treiber/containertest"
+	"github.com/peletor/treiber/internal/stats"
+	"github.com/peletor/treiber/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDualStackConformance(t *testing.T) {
+	t.Run("NewDualStack", func(t *testing.T) {
+		containertest.TestContainer(t, containertest.LIFO, func() treiber.Container[int] { return NewDualStack() }, containertest.Int)
+	})
+
+	t.Run("Zero value", func(t *testing.T) {
+		containertest.TestContainer(t, containertest.LIFO, func() treiber.Container[int] { return &DualStack{} }, containertest.Int)
+	})
+}
+
+type recordingTracer struct {
+	mu     sync.Mutex
+	starts []string
+}
+
+func (r *recordingTracer) Start(op string) tracing.Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.starts = append(r.starts, op)
+	return nil
+}
+
+func TestDualStackOptions(t *testing.T) {
+	t.Run("Backoff", func(t *testing.T) {
+		containertest.TestContainer(t, containertest.LIFO, func() treiber.Container[int] {
+			return NewDualStack(WithBackoff(backoff.Exponential{}))
+		}, containertest.Int)
+	})
+
+	t.Run("Tracer and stats", func(t *testing.T) {
+		tracer := &recordingTracer{}
+		s := NewDualStack(WithTracer(tracer))
+		s.Push(1)
+		s.Pop()
+		s.Pop()
+		popped := make(chan struct{})
+		go func() {
+			defer close(popped)
+			_, _ = s.PopWait(context.Background())
+		}()
+		require.Eventually(t, func() bool { return s.Waiting() == 1 }, time.Second, time.Millisecond)
+		s.Push(2)
+		<-popped
+
+		tracer.mu.Lock()
+		defer tracer.mu.Unlock()
+		assert.Equal(t, []string{"DualStack.Push", "DualStack.Pop", "DualStack.Pop", "DualStack.PopWait", "DualStack.Push"}, tracer.starts)
+
+		want := Stats{}
+		if stats.Enabled {
+			want = Stats{Pushes: 2, Pops: 2, EmptyPops: 1}
+		}
+		assert.Equal(t, want, s.Stats())
+	})
+}
+
+func TestDualStack(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Value waits for PopWait", func(t *testing.T) {
+		q := NewDualStack()
+		q.Push(1)
+		value, err := q.PopWait(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, value)
+	})
+
+	t.Run("Latest waiter is served first", func(t *testing.T) {
+		const count = 10
+		q := NewDualStack()
+		values := make([]int, count)
+		wg := sync.WaitGroup{}
+		wg.Add(count)
+		for i := 0; i < count; i++ {
+			go func() {
+				defer wg.Done()
+				values[i], _ = q.PopWait(ctx)
+			}()
+			require.Eventually(t, func() bool { return q.Waiting() == i+1 }, time.Second, time.Millisecond)
+		}
+
+		_, ok := q.Pop()
+		assert.False(t, ok, "Pop must not take from waiters")
+		for i := 0; i < count; i++ {
+			q.Push(i)
+		}
+		wg.Wait()
+		for i, value := range values {
+			assert.Equal(t, count-1-i, value)
+		}
+		// handed off, never queued
+		assert.Equal(t, 0, q.Len())
+		assert.Equal(t, 0, q.Waiting())
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		q := NewDualStack()
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err := q.PopWait(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 0, q.Waiting())
+
+		// the cancelled reservation is skipped
+		q.Push(1)
+		value, ok := q.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, 1, value)
+
+		_, err = q.PopWait(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("Cancelled reservations do not pile up", func(t *testing.T) {
+		q := &DualStack{}
+		for i := 0; i < 100; i++ {
+			ctx, cancel := context.WithTimeout(ctx, time.Microsecond)
+			_, err := q.PopWait(ctx)
+			cancel()
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		}
+		// only the last one is left
+		items := 0
+		for item := q.head; item != nil; item = (*dualItem)(item).next {
+			items++
+		}
+		assert.LessOrEqual(t, items, 1)
+	})
+}
+
+func TestDualStackConcurrency(t *testing.T) {
+	const (
+		producers = 4
+		consumers = 4
+		count     = 2000
+	)
+	q := NewDualStack()
+
+	var mu sync.Mutex
+	var received []int
+	consumed := sync.WaitGroup{}
+	consumed.Add(consumers)
+	for c := 0; c < consumers; c++ {
+		go func() {
+			defer consumed.Done()
+			for i := 0; i < producers*count/consumers; i++ {
+				value, err := q.PopWait(context.Background())
+				if !assert.NoError(t, err) {
+					return
+				}
+				mu.Lock()
+				received = append(received, value)
+				mu.Unlock()
+			}
+		}()
+	}
+	for p := 0; p < producers; p++ {
+		go func() {
+			for i := 0; i < count; i++ {
+				q.Push(p*count + i)
+			}
+		}()
+	}
+	consumed.Wait()
+
+	sort.Ints(received)
+	for i, value := range received {
+		require.Equal(t, i, value)
+	}
+	assert.Len(t, received, producers*count)
+	assert.Equal(t, 0, q.Len())
+}
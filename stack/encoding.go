@@ -0,0 +1,41 @@
+package stack
+
+import "github.com/peletor/treiber/internal/snapshot"
+
+// MarshalBinary encodes Snapshot, top first. gob uses it too, as it uses
+// UnmarshalBinary, which pushes the values so they pop in the same order
+// again. Decode into a new Stack: the values of a non-empty one are kept, and
+// mixed with the decoded ones.
+func (s *Stack) MarshalBinary() ([]byte, error) {
+	return snapshot.MarshalBinary(s.Snapshot()), nil
+}
+
+func (s *Stack) UnmarshalBinary(data []byte) error {
+	values, err := snapshot.UnmarshalBinary(data)
+	if err != nil {
+		return err
+	}
+	s.restore(values)
+	return nil
+}
+
+// MarshalJSON encodes Snapshot as a JSON array, top first.
+func (s *Stack) MarshalJSON() ([]byte, error) {
+	return snapshot.MarshalJSON(s.Snapshot())
+}
+
+func (s *Stack) UnmarshalJSON(data []byte) error {
+	values, err := snapshot.UnmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+	s.restore(values)
+	return nil
+}
+
+// restore pushes values, given top first.
+func (s *Stack) restore(values []int) {
+	for i := len(values) - 1; i >= 0; i-- {
+		s.Push(values[i])
+	}
+}
@@ -0,0 +1,111 @@
+package stack
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStackSnapshot(t *testing.T) {
+	stk := NewStack()
+	assert.Empty(t, stk.Snapshot())
+	assert.Empty(t, (&Stack{}).Snapshot())
+
+	stk.Push(1)
+	stk.Push(2)
+	stk.Push(3)
+	assert.Equal(t, []int{3, 2, 1}, stk.Snapshot())
+	assert.Equal(t, 3, stk.Len())
+	assert.Equal(t, []int{3, 2}, stk.Preview(2))
+	assert.Equal(t, []int{3, 2, 1}, stk.Preview(10))
+	assert.Empty(t, stk.Preview(0))
+}
+
+func TestStackEncoding(t *testing.T) {
+	newStack := func() *Stack {
+		stk := NewStack()
+		for i := 0; i < 10; i++ {
+			stk.Push(i)
+		}
+		return stk
+	}
+	popAll := func(stk *Stack) []int {
+		var values []int
+		for value, ok := stk.Pop(); ok; value, ok = stk.Pop() {
+			values = append(values, value)
+		}
+		return values
+	}
+	want := popAll(newStack())
+
+	t.Run("Binary", func(t *testing.T) {
+		data, err := newStack().MarshalBinary()
+		require.NoError(t, err)
+		restored := NewStack()
+		require.NoError(t, restored.UnmarshalBinary(data))
+		assert.Equal(t, want, popAll(restored))
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		data, err := json.Marshal(newStack())
+		require.NoError(t, err)
+		assert.JSONEq(t, "[9,8,7,6,5,4,3,2,1,0]", string(data))
+		restored := NewStack()
+		require.NoError(t, json.Unmarshal(data, restored))
+		assert.Equal(t, want, popAll(restored))
+	})
+
+	t.Run("gob", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		require.NoError(t, gob.NewEncoder(&buf).Encode(newStack()))
+		restored := NewStack()
+		require.NoError(t, gob.NewDecoder(&buf).Decode(restored))
+		assert.Equal(t, want, popAll(restored))
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		data, err := json.Marshal(NewStack())
+		require.NoError(t, err)
+		assert.Equal(t, "[]", string(data))
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		stk := NewStack()
+		assert.Error(t, stk.UnmarshalBinary([]byte{1, 5}))
+		assert.Error(t, stk.UnmarshalJSON([]byte(`"x"`)))
+		assert.Zero(t, stk.Len())
+	})
+}
+
+// a single producer pushes 0, 1, 2, ... so every state of the stack is a
+// descending run ending in 0
+func TestStackSnapshotConcurrency(t *testing.T) {
+	const count = 10000
+
+	stk := NewStack()
+	done := atomic.Bool{}
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < count; i++ {
+			stk.Push(i)
+		}
+		done.Store(true)
+	}()
+
+	for !done.Load() {
+		values := stk.Snapshot()
+		for i, value := range values {
+			if !assert.Equal(t, len(values)-1-i, value, "%v", values) {
+				return
+			}
+		}
+	}
+	wg.Wait()
+}
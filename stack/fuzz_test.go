@@ -0,0 +1,184 @@
+package stack
+
+import (
+	"fmt"
+	"github.com/peletor/treiber/internal/linearize"
+	"slices"
+	"sync"
+	"testing"
+)
+
+const (
+	opPush = iota
+	opPop
+	opTop
+	opCount
+)
+
+var opNames = [opCount]string{"Push", "Pop", "Top"}
+
+type fuzzOp struct {
+	kind  byte
+	value int
+}
+
+func (op fuzzOp) String() string {
+	if op.kind == opPush {
+		return fmt.Sprintf("Push(%d)", op.value)
+	}
+	return opNames[op.kind] + "()"
+}
+
+type result struct {
+	value int
+	ok    bool
+}
+
+// decodeOps reads one operation per byte; a Push takes its value from the
+// byte that follows.
+func decodeOps(data []byte) []fuzzOp {
+	var ops []fuzzOp
+	for i := 0; i < len(data); i++ {
+		op := fuzzOp{kind: data[i] % opCount}
+		if op.kind == opPush && i+1 < len(data) {
+			i++
+			op.value = int(data[i])
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+func encodeOps(ops ...fuzzOp) []byte {
+	var data []byte
+	for _, op := range ops {
+		data = append(data, op.kind)
+		if op.kind == opPush {
+			data = append(data, byte(op.value))
+		}
+	}
+	return data
+}
+
+func repeat(n int, ops ...fuzzOp) []fuzzOp {
+	var all []fuzzOp
+	for i := 0; i < n; i++ {
+		all = append(all, ops...)
+	}
+	return all
+}
+
+// addSeeds adds the scenarios of TestStack to the corpus.
+func addSeeds(f *testing.F) {
+	push, pop, top := fuzzOp{kind: opPush, value: 5}, fuzzOp{kind: opPop}, fuzzOp{kind: opTop}
+	pushes := make([]fuzzOp, 10)
+	for i := range pushes {
+		pushes[i] = fuzzOp{kind: opPush, value: i}
+	}
+
+	f.Add(encodeOps(push, top))
+	f.Add(encodeOps(push, pop))
+	f.Add(encodeOps(top))
+	f.Add(encodeOps(pop))
+	f.Add(encodeOps(slices.Concat(pushes, repeat(10, pop))...))
+	f.Add(encodeOps(slices.Concat(pushes, repeat(10, pop), []fuzzOp{top})...))
+	f.Add(encodeOps(slices.Concat(pushes, repeat(11, pop))...))
+	f.Add(encodeOps(append(pushes, pop)...))
+}
+
+// model is the sequential reference: a slice with its top at the end.
+type model []int
+
+func (m model) apply(op fuzzOp) (model, result) {
+	switch op.kind {
+	case opPush:
+		return append(slices.Clip(m), op.value), result{}
+	case opPop:
+		if len(m) == 0 {
+			return m, result{}
+		}
+		return m[:len(m)-1], result{m[len(m)-1], true}
+	default:
+		if len(m) == 0 {
+			return m, result{}
+		}
+		return m, result{m[len(m)-1], true}
+	}
+}
+
+func apply(st *Stack, op fuzzOp) result {
+	switch op.kind {
+	case opPush:
+		st.Push(op.value)
+		return result{}
+	case opPop:
+		value, ok := st.Pop()
+		return result{value, ok}
+	default:
+		value, ok := st.Top()
+		return result{value, ok}
+	}
+}
+
+func FuzzStack(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		st := NewStack()
+		var m model
+		for i, op := range decodeOps(data) {
+			var want result
+			m, want = m.apply(op)
+			if got := apply(st, op); got != want {
+				t.Fatalf("op %d %v: got %v, model %v", i, op, got, want)
+			}
+			if st.Len() != len(m) {
+				t.Fatalf("op %d %v: Len %d, model %d", i, op, st.Len(), len(m))
+			}
+		}
+	})
+}
+
+var stackModel = linearize.Model[model, fuzzOp, result]{
+	Init: func() model { return nil },
+	Step: func(m model, op fuzzOp, got result) (bool, model) {
+		next, want := m.apply(op)
+		return got == want, next
+	},
+	Key: func(m model) string { return fmt.Sprint([]int(m)) },
+}
+
+// maxConcurrentOps keeps the linearizability check, which is exponential in
+// the worst case, fast.
+const maxConcurrentOps = 64
+
+// FuzzStackConcurrent deals the operations round robin to 2 to 4 goroutines,
+// depending on the length of the input.
+func FuzzStackConcurrent(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		goroutines := 2 + len(data)%3
+		ops := decodeOps(data)
+		ops = ops[:min(len(ops), maxConcurrentOps)]
+
+		st := NewStack()
+		clock := linearize.Clock{}
+		history := make([]linearize.Operation[fuzzOp, result], len(ops))
+		wg := sync.WaitGroup{}
+		wg.Add(goroutines)
+		for g := 0; g < goroutines; g++ {
+			go func(g int) {
+				defer wg.Done()
+				for i := g; i < len(ops); i += goroutines {
+					call := clock.Now()
+					got := apply(st, ops[i])
+					history[i] = linearize.Operation[fuzzOp, result]{Input: ops[i], Output: got, Call: call, Return: clock.Now()}
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		if !linearize.Check(stackModel, history) {
+			t.Fatalf("history is not linearizable: %v", history)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+//go:build treibersched
+
+package stack
+
+import (
+	"fmt"
+	"github.com/peletor/treiber/internal/sched"
+	"github.com/stretchr/testify/assert"
+	"slices"
+	"testing"
+)
+
+// pushPushPop starts from a stack holding 1. Two goroutines push and one
+// pops, then whatever was popped and what is left must be {1, 2, 3}.
+func pushPushPop() ([]func(), func() error) {
+	st := NewStack()
+	st.Push(1)
+
+	var popped []int
+	pop := func() {
+		if value, ok := st.Pop(); ok {
+			popped = append(popped, value)
+		}
+	}
+	return []func(){func() { st.Push(2) }, func() { st.Push(3) }, pop}, func() error {
+		for value, ok := st.Pop(); ok; value, ok = st.Pop() {
+			popped = append(popped, value)
+		}
+		slices.Sort(popped)
+		if !slices.Equal(popped, []int{1, 2, 3}) {
+			return fmt.Errorf("pushed [1 2 3], popped %v", popped)
+		}
+		return nil
+	}
+}
+
+func TestStackSchedules(t *testing.T) {
+	t.Run("Enumerate", func(t *testing.T) {
+		failure, runs, _ := sched.Enumerate(20_000, pushPushPop)
+		assert.Nil(t, failure)
+		assert.Positive(t, runs)
+	})
+
+	t.Run("PCT", func(t *testing.T) {
+		failure := sched.Explore(2_000, func(seed uint64) sched.Strategy { return sched.PCT(seed, 3, 40) }, pushPushPop)
+		assert.Nil(t, failure)
+	})
+}
@@ -1,59 +1,169 @@
 package stack
 
 import (
-	"sync/atomic"
 	"unsafe"
+
+	"github.com/peletor/treiber/backoff"
+	"github.com/peletor/treiber/internal/kcas"
+	"github.com/peletor/treiber/internal/nocopy"
+	"github.com/peletor/treiber/internal/sched"
+	"github.com/peletor/treiber/internal/stats"
+	"github.com/peletor/treiber/tracing"
 )
 
 type stackItem struct {
 	value int
 	next  unsafe.Pointer
 }
+
+// Stack is a Treiber stack. The zero Stack is empty and ready to use; it
+// must not be copied after first use.
 type Stack struct {
+	_    nocopy.NoCopy
 	head unsafe.Pointer
+
+	stats   stats.Counters
+	backoff backoff.Backoff
+	tracer  tracing.Tracer
+}
+
+// Stats is a snapshot of the counters of a container.
+// The counters are only maintained when built with the treiberstats tag.
+type Stats = stats.Snapshot
+
+// Option configures a Stack created by NewStack.
+type Option func(*Stack)
+
+// WithBackoff makes every CAS loop of the stack wait with b before retrying.
+func WithBackoff(b backoff.Backoff) Option {
+	return func(s *Stack) {
+		s.backoff = b
+	}
 }
 
-func NewStack() Stack {
-	return Stack{}
+// WithTracer reports the start, retries and end of every operation to t.
+func WithTracer(t tracing.Tracer) Option {
+	return func(s *Stack) {
+		s.tracer = t
+	}
+}
+
+func NewStack(opts ...Option) *Stack {
+	s := &Stack{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *Stack) Push(value int) {
+	span := tracing.Start(s.tracer, "Stack.Push")
+	defer tracing.End(span)
+
 	newNode := &stackItem{value: value}
 
-	for {
-		head := atomic.LoadPointer(&s.head)
+	for attempt := 0; ; attempt++ {
+		head := kcas.Load(&s.head)
 		newNode.next = head
 
-		if atomic.CompareAndSwapPointer(&s.head, head, unsafe.Pointer(newNode)) {
+		if sched.CompareAndSwapPointer(&s.head, head, unsafe.Pointer(newNode)) {
+			s.stats.Push()
 			return
 		}
+		s.stats.CASFailure()
+		s.retry(span, attempt)
 	}
 }
 
-func (s *Stack) Pop() (value int, Ok bool) {
-	for {
-		head := atomic.LoadPointer(&s.head)
+func (s *Stack) Pop() (value int, ok bool) {
+	span := tracing.Start(s.tracer, "Stack.Pop")
+	defer tracing.End(span)
+
+	for attempt := 0; ; attempt++ {
+		head := kcas.Load(&s.head)
 		if head == nil {
+			s.stats.EmptyPop()
 			return 0, false
 		}
 
-		next := atomic.LoadPointer(&(*stackItem)(head).next)
-		if atomic.CompareAndSwapPointer(&s.head, head, next) {
+		next := sched.LoadPointer(&(*stackItem)(head).next)
+		if sched.CompareAndSwapPointer(&s.head, head, next) {
+			s.stats.Pop()
 			return (*stackItem)(head).value, true
 		}
+		s.stats.CASFailure()
+		s.retry(span, attempt)
 	}
 }
 
-func (s *Stack) Top() (value int, Ok bool) {
-	for {
-		head := atomic.LoadPointer(&s.head)
+func (s *Stack) Top() (value int, ok bool) {
+	span := tracing.Start(s.tracer, "Stack.Top")
+	defer tracing.End(span)
+
+	for attempt := 0; ; attempt++ {
+		head := kcas.Load(&s.head)
 		if head == nil {
 			return 0, false
 		}
 
 		// Try to swap head with itself
-		if atomic.CompareAndSwapPointer(&s.head, head, head) {
+		if sched.CompareAndSwapPointer(&s.head, head, head) {
 			return (*stackItem)(head).value, true
 		}
+		s.stats.CASFailure()
+		s.retry(span, attempt)
+	}
+}
+
+// Len counts the items by walking the stack. It is O(n), and under
+// concurrent pushes and pops the result is only an approximation.
+func (s *Stack) Len() int {
+	length := 0
+	for item := kcas.Load(&s.head); item != nil; item = sched.LoadPointer(&(*stackItem)(item).next) {
+		length++
+	}
+	return length
+}
+
+// Snapshot returns the values of the stack, top first. Items are never
+// modified once pushed, so the walk from the head loaded at the start sees
+// the stack exactly as it was at that load, whatever happens meanwhile.
+func (s *Stack) Snapshot() []int {
+	span := tracing.Start(s.tracer, "Stack.Snapshot")
+	defer tracing.End(span)
+
+	var values []int
+	for item := kcas.Load(&s.head); item != nil; item = sched.LoadPointer(&(*stackItem)(item).next) {
+		values = append(values, (*stackItem)(item).value)
+	}
+	return values
+}
+
+// Preview returns up to n values from the top of the stack, top first,
+// without the cost of a full Snapshot.
+func (s *Stack) Preview(n int) []int {
+	var values []int
+	for item := kcas.Load(&s.head); item != nil && len(values) < n; item = sched.LoadPointer(&(*stackItem)(item).next) {
+		values = append(values, (*stackItem)(item).value)
+	}
+	return values
+}
+
+// Stats returns the counters of the stack.
+func (s *Stack) Stats() Stats {
+	return s.stats.Snapshot()
+}
+
+// PublishExpvar exports Stats as the expvar variable name.
+// It does nothing unless built with the treiberstats tag.
+func (s *Stack) PublishExpvar(name string) {
+	stats.Publish(name, s.Stats)
+}
+
+// retry is called at the end of every failed iteration of a CAS loop.
+func (s *Stack) retry(span tracing.Span, attempt int) {
+	tracing.Retry(span, attempt)
+	if s.backoff != nil {
+		s.backoff.Wait(attempt)
 	}
 }
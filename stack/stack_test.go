@@ -1,6 +1,11 @@
 package stack
 
 import (
+	"fmt"
+	"github.com/peletor/treiber"
+	"github.com/peletor/treiber/backoff"
+	"github.com/peletor/treiber/containertest"
+	"github.com/peletor/treiber/internal/stats"
 	"github.com/stretchr/testify/assert"
 	"sync"
 	"testing"
@@ -66,6 +71,17 @@ func TestStack(t *testing.T) {
 		assert.Equal(t, null, result)
 	})
 
+	t.Run("Len", func(t *testing.T) {
+		st := NewStack()
+		assert.Equal(t, 0, st.Len())
+		for i := 0; i < count; i++ {
+			st.Push(i)
+		}
+		assert.Equal(t, count, st.Len())
+		st.Pop()
+		assert.Equal(t, count-1, st.Len())
+	})
+
 	t.Run("Pop after Push Pop several times", func(t *testing.T) {
 		st := NewStack()
 		for i := 0; i < count; i++ {
@@ -80,6 +96,31 @@ func TestStack(t *testing.T) {
 	})
 }
 
+func TestStackConformance(t *testing.T) {
+	t.Run("NewStack", func(t *testing.T) {
+		containertest.TestContainer(t, containertest.LIFO, func() treiber.Container[int] { return NewStack() }, containertest.Int)
+	})
+
+	t.Run("Zero value", func(t *testing.T) {
+		containertest.TestContainer(t, containertest.LIFO, func() treiber.Container[int] { return &Stack{} }, containertest.Int)
+	})
+}
+
+func TestStackStats(t *testing.T) {
+	st := NewStack()
+	st.Push(1)
+	st.Push(2)
+	st.Pop()
+	st.Pop()
+	st.Pop()
+
+	want := Stats{}
+	if stats.Enabled {
+		want = Stats{Pushes: 2, Pops: 2, EmptyPops: 1}
+	}
+	assert.Equal(t, want, st.Stats())
+}
+
 func TestStackConcurrency(t *testing.T) {
 	const count = 50
 
@@ -106,6 +147,29 @@ func TestStackConcurrency(t *testing.T) {
 		assert.Equal(t, cnt, count*3)
 	})
 
+	t.Run("Push with backoff", func(t *testing.T) {
+		st := NewStack(WithBackoff(backoff.Exponential{}))
+
+		wg := sync.WaitGroup{}
+		wg.Add(count)
+
+		for i := 0; i < count; i++ {
+			go func(value int) {
+				defer wg.Done()
+				st.Push(value)
+				st.Push(value)
+				st.Push(value)
+			}(i)
+		}
+		wg.Wait()
+
+		cnt := 0
+		for _, ok := st.Pop(); ok; _, ok = st.Pop() {
+			cnt++
+		}
+		assert.Equal(t, cnt, count*3)
+	})
+
 	t.Run("Pop", func(t *testing.T) {
 		st := NewStack()
 
@@ -128,3 +192,87 @@ func TestStackConcurrency(t *testing.T) {
 		assert.False(t, ok) // Stack must be empty
 	})
 }
+
+func BenchmarkStack(b *testing.B) {
+	b.Run("Push", func(b *testing.B) {
+		st := NewStack()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			st.Push(i)
+		}
+	})
+
+	b.Run("Pop", func(b *testing.B) {
+		st := NewStack()
+		for i := 0; i < b.N; i++ {
+			st.Push(i)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			st.Pop()
+		}
+	})
+
+	b.Run("Top", func(b *testing.B) {
+		st := NewStack()
+		st.Push(1)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			st.Top()
+		}
+	})
+
+	b.Run("PushPop parallel", func(b *testing.B) {
+		st := NewStack()
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				st.Push(i)
+				st.Pop()
+			}
+		})
+	})
+}
+
+func BenchmarkStackBackoff(b *testing.B) {
+	strategies := []struct {
+		name    string
+		backoff backoff.Backoff
+	}{
+		{"none", nil},
+		{"exponential", backoff.Exponential{}},
+		{"yield", backoff.Yield{}},
+		{"proportional", backoff.Proportional{}},
+	}
+
+	for _, strategy := range strategies {
+		for _, goroutines := range []int{1, 4, 16, 64} {
+			b.Run(fmt.Sprintf("%s/goroutines=%d", strategy.name, goroutines), func(b *testing.B) {
+				st := NewStack(WithBackoff(strategy.backoff))
+				b.ReportAllocs()
+				b.ResetTimer()
+				benchmarkContention(b.N, goroutines, func(value int) {
+					st.Push(value)
+					st.Pop()
+				})
+			})
+		}
+	}
+}
+
+// benchmarkContention runs op n times spread over the given number of goroutines.
+func benchmarkContention(n, goroutines int, op func(int)) {
+	wg := sync.WaitGroup{}
+	wg.Add(goroutines)
+
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < n; i += goroutines {
+				op(i)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
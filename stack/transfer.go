@@ -0,0 +1,30 @@
+package stack
+
+import (
+	"unsafe"
+
+	"github.com/peletor/treiber/internal/kcas"
+	"github.com/peletor/treiber/internal/sched"
+)
+
+// TakeEntry and PutEntry implement transfer.Source and transfer.Sink; use
+// transfer.Transfer rather than calling them.
+//
+// TakeEntry returns the KCAS entry that pops the top value, and done to
+// call once the KCAS succeeded. ok is false if the stack is empty.
+func (s *Stack) TakeEntry() (e kcas.Entry, value int, ok bool, done func()) {
+	head := kcas.Load(&s.head)
+	if head == nil {
+		s.stats.EmptyPop()
+		return kcas.Entry{}, 0, false, nil
+	}
+	next := sched.LoadPointer(&(*stackItem)(head).next)
+	return kcas.Entry{Addr: &s.head, Old: head, New: next}, (*stackItem)(head).value, true, s.stats.Pop
+}
+
+// PutEntry returns the KCAS entry that pushes value.
+func (s *Stack) PutEntry(value int) (e kcas.Entry, done func()) {
+	head := kcas.Load(&s.head)
+	newNode := &stackItem{value: value, next: head}
+	return kcas.Entry{Addr: &s.head, Old: head, New: unsafe.Pointer(newNode)}, s.stats.Push
+}
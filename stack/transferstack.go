@@ -0,0 +1,63 @@
+package stack
+
+import (
+	"context"
+
+	"github.com/peletor/treiber/internal/sched"
+	"github.com/peletor/treiber/tracing"
+)
+
+// TransferStack is a DualStack whose producers can also wait for a
+// consumer. Push pushes and returns at once; Transfer returns only once a
+// consumer received the value, so the producer knows the work was picked
+// up; TryTransfer hands the value over only if a consumer waits already.
+//
+// The latest value of Push and Transfer is received first, by the latest
+// waiting PopWait. The zero TransferStack is empty and ready to use; it
+// must not be copied after first use.
+type TransferStack struct {
+	DualStack
+}
+
+// NewTransferStack takes the options of NewStack, as NewDualStack.
+func NewTransferStack(opts ...Option) *TransferStack {
+	s := &TransferStack{}
+	s.configure(opts)
+	return s
+}
+
+// Transfer hands value to a consumer and waits until one received it. It
+// returns ctx.Err() if ctx is done first, and then no consumer receives
+// value.
+func (s *TransferStack) Transfer(ctx context.Context, value int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	span := tracing.Start(s.tracer, "TransferStack.Transfer")
+	defer tracing.End(span)
+
+	item := &dualItem{isData: true, value: value, ready: make(chan struct{})}
+	if s.put(span, item, true) {
+		return nil
+	}
+
+	select {
+	case <-item.ready:
+		return nil
+	case <-ctx.Done():
+		if sched.CompareAndSwapPointer(&item.item, nil, cancelled) {
+			return ctx.Err()
+		}
+		// taken meanwhile
+		return nil
+	}
+}
+
+// TryTransfer hands value to a waiting PopWait. It reports false, and
+// drops value, if no consumer is waiting.
+func (s *TransferStack) TryTransfer(value int) bool {
+	span := tracing.Start(s.tracer, "TransferStack.TryTransfer")
+	defer tracing.End(span)
+
+	return s.put(span, &dualItem{isData: true, value: value}, false)
+}
@@ -0,0 +1,144 @@
+package stack
+
+import (
+	"context"
+	"github.com/peletor/treiber"
+	"github.com/peletor/treiber/containertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransferStackConformance(t *testing.T) {
+	containertest.TestContainer(t, containertest.LIFO, func() treiber.Container[int] { return NewTransferStack() }, containertest.Int)
+}
+
+func TestTransferStack(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Transfer waits for a consumer", func(t *testing.T) {
+		s := NewTransferStack()
+		transferred := make(chan error)
+		go func() { transferred <- s.Transfer(ctx, 1) }()
+
+		require.Eventually(t, func() bool { return s.Len() == 1 }, time.Second, time.Millisecond)
+		select {
+		case <-transferred:
+			t.Fatal("Transfer returned before a consumer received the value")
+		case <-time.After(10 * time.Millisecond):
+		}
+		value, ok := s.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, 1, value)
+		assert.NoError(t, <-transferred)
+	})
+
+	t.Run("Transfer to a waiting consumer", func(t *testing.T) {
+		s := &TransferStack{}
+		popped := make(chan int)
+		go func() {
+			value, _ := s.PopWait(ctx)
+			popped <- value
+		}()
+		require.Eventually(t, func() bool { return s.Waiting() == 1 }, time.Second, time.Millisecond)
+		assert.NoError(t, s.Transfer(ctx, 2))
+		assert.Equal(t, 2, <-popped)
+	})
+
+	t.Run("TryTransfer", func(t *testing.T) {
+		s := NewTransferStack()
+		assert.False(t, s.TryTransfer(1))
+		assert.Equal(t, 0, s.Len())
+
+		popped := make(chan int)
+		go func() {
+			value, _ := s.PopWait(ctx)
+			popped <- value
+		}()
+		require.Eventually(t, func() bool { return s.Waiting() == 1 }, time.Second, time.Millisecond)
+		assert.True(t, s.TryTransfer(2))
+		assert.Equal(t, 2, <-popped)
+	})
+
+	t.Run("Cancelled Transfer", func(t *testing.T) {
+		s := NewTransferStack()
+		for i := 0; i < 10; i++ {
+			timeout, cancel := context.WithTimeout(ctx, time.Millisecond)
+			assert.ErrorIs(t, s.Transfer(timeout, i), context.DeadlineExceeded)
+			cancel()
+		}
+		assert.Equal(t, 0, s.Len())
+
+		// the cancelled values do not pile up
+		items := 0
+		for item := s.head; item != nil; item = (*dualItem)(item).next {
+			items++
+		}
+		assert.LessOrEqual(t, items, 1)
+
+		_, ok := s.Pop()
+		assert.False(t, ok, "a cancelled Transfer must not be received")
+	})
+
+	t.Run("Pushes and Transfers keep their order", func(t *testing.T) {
+		s := NewTransferStack()
+		s.Push(1)
+		go s.Transfer(ctx, 2)
+		require.Eventually(t, func() bool { return s.Len() == 2 }, time.Second, time.Millisecond)
+		s.Push(3)
+		for _, want := range []int{3, 2, 1} {
+			value, err := s.PopWait(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, want, value)
+		}
+	})
+}
+
+func TestTransferStackConcurrency(t *testing.T) {
+	const (
+		producers = 4
+		consumers = 4
+		count     = 1000
+	)
+	ctx := context.Background()
+	s := NewTransferStack()
+
+	var mu sync.Mutex
+	var received []int
+	wg := sync.WaitGroup{}
+	wg.Add(producers + consumers)
+	for p := 0; p < producers; p++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				if i%2 == 0 {
+					s.Push(p*count + i)
+				} else {
+					assert.NoError(t, s.Transfer(ctx, p*count+i))
+				}
+			}
+		}()
+	}
+	for c := 0; c < consumers; c++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < producers*count/consumers; i++ {
+				value, err := s.PopWait(ctx)
+				assert.NoError(t, err)
+				mu.Lock()
+				received = append(received, value)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Ints(received)
+	require.Len(t, received, producers*count)
+	for i, value := range received {
+		require.Equal(t, i, value)
+	}
+}
@@ -0,0 +1,48 @@
+package tracing
+
+import (
+	"context"
+	"runtime/trace"
+	"strconv"
+)
+
+// Runtime emits runtime/trace regions: one region per operation inside a
+// task per tracer, and a log entry per retry. It only records anything while
+// a trace is being collected, e.g. with go test -trace.
+type Runtime struct {
+	ctx  context.Context
+	task *trace.Task
+}
+
+// NewRuntime starts the task name; every operation traced by the result
+// becomes a region of that task. Call End when the container is retired.
+func NewRuntime(ctx context.Context, name string) *Runtime {
+	ctx, task := trace.NewTask(ctx, name)
+	return &Runtime{ctx: ctx, task: task}
+}
+
+func (r *Runtime) Start(op string) Span {
+	if !trace.IsEnabled() {
+		return nil
+	}
+	return &runtimeSpan{ctx: r.ctx, op: op, region: trace.StartRegion(r.ctx, op)}
+}
+
+// End ends the task.
+func (r *Runtime) End() {
+	r.task.End()
+}
+
+type runtimeSpan struct {
+	ctx    context.Context
+	op     string
+	region *trace.Region
+}
+
+func (s *runtimeSpan) Retry(attempt int) {
+	trace.Log(s.ctx, s.op, "retry "+strconv.Itoa(attempt))
+}
+
+func (s *runtimeSpan) End() {
+	s.region.End()
+}
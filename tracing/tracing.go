@@ -0,0 +1,40 @@
+// Package tracing lets a container report when its operations start, retry
+// and end, so time spent spinning in CAS loops can be seen in a trace viewer.
+package tracing
+
+// Tracer is installed into a container with its WithTracer option.
+// Start is called at the beginning of every operation, op names it,
+// for example "Queue.Push"; it may return nil to leave the operation
+// untraced. A Tracer must be safe for concurrent use.
+type Tracer interface {
+	Start(op string) Span
+}
+
+// Span belongs to one operation and is only used by the goroutine running it.
+// Retry is called after every failed iteration of the operation's CAS loop,
+// attempt counts them starting at 0.
+type Span interface {
+	Retry(attempt int)
+	End()
+}
+
+// Start returns nil when t is nil, which Retry and End accept.
+// Containers use these helpers, so operations without a tracer stay cheap.
+func Start(t Tracer, op string) Span {
+	if t == nil {
+		return nil
+	}
+	return t.Start(op)
+}
+
+func Retry(s Span, attempt int) {
+	if s != nil {
+		s.Retry(attempt)
+	}
+}
+
+func End(s Span) {
+	if s != nil {
+		s.End()
+	}
+}
@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"runtime/trace"
+	"sync"
+	"testing"
+)
+
+func TestHelpers(t *testing.T) {
+	t.Run("Nil tracer", func(t *testing.T) {
+		assert.NotPanics(t, func() {
+			span := Start(nil, "op")
+			Retry(span, 0)
+			End(span)
+		})
+	})
+}
+
+func TestRuntime(t *testing.T) {
+	t.Run("Not collecting", func(t *testing.T) {
+		tracer := NewRuntime(context.Background(), "test")
+		defer tracer.End()
+		assert.Nil(t, tracer.Start("op"))
+	})
+
+	t.Run("Collecting", func(t *testing.T) {
+		if trace.IsEnabled() {
+			t.Skip("trace is already being collected")
+		}
+		buf := &bytes.Buffer{}
+		assert.NoError(t, trace.Start(buf))
+
+		tracer := NewRuntime(context.Background(), "test")
+		span := tracer.Start("Queue.Push")
+		assert.NotNil(t, span)
+		span.Retry(0)
+		span.End()
+		tracer.End()
+
+		trace.Stop()
+		assert.NotZero(t, buf.Len())
+	})
+}
+
+func TestChrome(t *testing.T) {
+	const goroutines = 10
+
+	buf := &bytes.Buffer{}
+	tracer := NewChrome(buf)
+
+	wg := sync.WaitGroup{}
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			span := tracer.Start("Queue.Pop")
+			span.Retry(0)
+			span.Retry(1)
+			span.End()
+		}()
+	}
+	wg.Wait()
+	assert.NoError(t, tracer.Close())
+
+	var events []chromeEvent
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &events))
+	assert.Len(t, events, 3*goroutines)
+
+	tids := map[uint64]bool{}
+	for _, ev := range events {
+		assert.NotZero(t, ev.Tid)
+		tids[ev.Tid] = true
+		if ev.Ph == "X" {
+			assert.Equal(t, "Queue.Pop", ev.Name)
+			assert.Equal(t, 2, ev.Args["retries"])
+		} else {
+			assert.Equal(t, "i", ev.Ph)
+		}
+	}
+	assert.Len(t, tids, goroutines)
+
+	t.Run("Close twice", func(t *testing.T) {
+		assert.NoError(t, tracer.Close())
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		assert.NoError(t, NewChrome(buf).Close())
+		var events []chromeEvent
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &events))
+		assert.Empty(t, events)
+	})
+}
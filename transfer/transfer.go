@@ -0,0 +1,72 @@
+// Package transfer moves values between containers atomically. Popping
+// from one container and pushing to another leaves a moment in which the
+// value is in neither, and concurrent observers can see it missing;
+// Transfer pops and pushes with a single KCAS, so the value is always in
+// exactly one of them:
+//
+//	value, ok := transfer.Transfer(pending, inFlight)
+//
+// Stacks and queues are Sources and Sinks; a deque is through Back and
+// Front. Transfer works on the containers of this module only, as their
+// operations are the ones that know about the KCAS.
+package transfer
+
+import (
+	"github.com/peletor/treiber/deque"
+	"github.com/peletor/treiber/internal/kcas"
+)
+
+// Source is a container Transfer pops from. Its method takes an internal
+// type, so only the containers of this module implement it.
+type Source interface {
+	TakeEntry() (e kcas.Entry, value int, ok bool, done func())
+}
+
+// Sink is a container Transfer pushes to.
+type Sink interface {
+	PutEntry(value int) (e kcas.Entry, done func())
+}
+
+// Transfer pops a value from src and pushes it to dst as one atomic step.
+// ok is false if src was empty. A stack cannot transfer to itself: Transfer
+// panics. A queue can, which moves its head to its tail, and so can a deque
+// between its ends.
+func Transfer(src Source, dst Sink) (value int, ok bool) {
+	for {
+		take, value, ok, took := src.TakeEntry()
+		if !ok {
+			return 0, false
+		}
+		give, gave := dst.PutEntry(value)
+		if take.Addr == give.Addr {
+			panic("transfer: src and dst are the same container")
+		}
+		if kcas.CompareAndSwap(take, give) {
+			took()
+			gave()
+			return value, true
+		}
+	}
+}
+
+// End is one end of a deque, a Source and a Sink.
+type End struct {
+	take func() (kcas.Entry, int, bool, func())
+	put  func(int) (kcas.Entry, func())
+}
+
+func Back(d *deque.Deque) End {
+	return End{take: d.TakeBackEntry, put: d.PutBackEntry}
+}
+
+func Front(d *deque.Deque) End {
+	return End{take: d.TakeFrontEntry, put: d.PutFrontEntry}
+}
+
+func (e End) TakeEntry() (kcas.Entry, int, bool, func()) {
+	return e.take()
+}
+
+func (e End) PutEntry(value int) (kcas.Entry, func()) {
+	return e.put(value)
+}
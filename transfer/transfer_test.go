@@ -0,0 +1,195 @@
+package transfer
+
+import (
+	"github.com/peletor/treiber/deque"
+	"github.com/peletor/treiber/queue"
+	"github.com/peletor/treiber/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestTransfer(t *testing.T) {
+	s := stack.NewStack()
+	q := queue.NewQueue()
+	d := deque.NewDeque()
+	s.Push(1)
+	s.Push(2)
+
+	value, ok := Transfer(s, q)
+	assert.True(t, ok)
+	assert.Equal(t, 2, value)
+	Transfer(s, q)
+	assert.Equal(t, 0, s.Len())
+	assert.Equal(t, []int{2, 1}, q.Snapshot())
+
+	Transfer(q, Back(d))
+	Transfer(q, Front(d))
+	assert.Equal(t, []int{1, 2}, d.Snapshot())
+
+	Transfer(Back(d), s)
+	Transfer(Front(d), s)
+	assert.Equal(t, 0, d.Len())
+	assert.Equal(t, []int{1, 2}, s.Snapshot())
+
+	t.Run("Empty source", func(t *testing.T) {
+		_, ok := Transfer(queue.NewQueue(), s)
+		assert.False(t, ok)
+		_, ok = Transfer(Back(deque.NewDeque()), s)
+		assert.False(t, ok)
+		assert.Equal(t, 2, s.Len())
+	})
+
+	t.Run("Zero values", func(t *testing.T) {
+		var zs stack.Stack
+		var zq queue.Queue
+		var zd deque.Deque
+		zs.Push(5)
+		Transfer(&zs, &zq)
+		Transfer(&zq, Front(&zd))
+		value, ok := zd.PopBack()
+		assert.True(t, ok)
+		assert.Equal(t, 5, value)
+	})
+
+	t.Run("Queue to itself", func(t *testing.T) {
+		q := queue.NewQueue()
+		q.Push(1)
+		q.Push(2)
+		Transfer(q, q)
+		assert.Equal(t, []int{2, 1}, q.Snapshot())
+	})
+
+	t.Run("Stack to itself", func(t *testing.T) {
+		assert.Panics(t, func() { Transfer(s, s) })
+	})
+
+	t.Run("Deque to itself", func(t *testing.T) {
+		d.PushBack(3)
+		d.PushBack(4)
+		Transfer(Back(d), Front(d))
+		assert.Equal(t, []int{4, 3}, d.Snapshot())
+		Transfer(Front(d), Front(d))
+		assert.Equal(t, []int{4, 3}, d.Snapshot())
+	})
+}
+
+// link is a container of a chain, see TestAlwaysVisible.
+type link struct {
+	src  Source
+	dst  Sink
+	push func(int)
+	len  func() int
+}
+
+func chain(n int) []link {
+	links := make([]link, n)
+	for i := range links {
+		switch i % 4 {
+		case 0:
+			s := stack.NewStack()
+			links[i] = link{s, s, s.Push, s.Len}
+		case 1:
+			q := queue.NewQueue()
+			links[i] = link{q, q, q.Push, q.Len}
+		case 2:
+			d := deque.NewDeque()
+			links[i] = link{Back(d), Back(d), d.PushBack, d.Len}
+		case 3:
+			d := deque.NewDeque()
+			links[i] = link{Front(d), Front(d), d.PushFront, d.Len}
+		}
+	}
+	return links
+}
+
+// a value moves down a chain of containers while an observer looks for it
+// in the same order. The value only moves forward, so a look at every
+// container that misses it means it was in none of them at some moment,
+// which a pop followed by a push would allow.
+func TestAlwaysVisible(t *testing.T) {
+	const (
+		rounds = 20
+		length = 64
+	)
+
+	for round := 0; round < rounds; round++ {
+		links := chain(length)
+		links[0].push(round)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i+1 < len(links); i++ {
+				value, ok := Transfer(links[i].src, links[i+1].dst)
+				assert.True(t, ok)
+				assert.Equal(t, round, value)
+			}
+		}()
+
+		for observing := true; observing; {
+			select {
+			case <-done:
+				observing = false
+			default:
+			}
+			found := 0
+			for _, l := range links {
+				found += l.len()
+			}
+			require.GreaterOrEqual(t, found, 1, "round %d", round)
+		}
+		assert.Equal(t, 1, links[length-1].len())
+	}
+}
+
+// goroutines move values between all kinds of containers at random; none
+// is lost or duplicated
+func TestConcurrent(t *testing.T) {
+	const (
+		values     = 100
+		goroutines = 8
+		moves      = 2000
+	)
+
+	links := chain(8)
+	for i := 0; i < values; i++ {
+		links[i%len(links)].push(i)
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < moves; i++ {
+				src, dst := r.Intn(len(links)), r.Intn(len(links))
+				if src != dst {
+					Transfer(links[src].src, links[dst].dst)
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+
+	var all []int
+	for _, l := range links {
+		for {
+			value, ok := Transfer(l.src, stack.NewStack())
+			if !ok {
+				break
+			}
+			all = append(all, value)
+		}
+	}
+	sort.Ints(all)
+	want := make([]int, values)
+	for i := range want {
+		want[i] = i
+	}
+	assert.Equal(t, want, all)
+}
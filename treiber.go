@@ -0,0 +1,31 @@
+// Package treiber defines the interfaces shared by the containers of this
+// module: stack.Stack and queue.Queue are Containers, deque.Deque is
+// DoubleEnded. Package containertest checks implementations against them.
+package treiber
+
+// Pusher adds values to a container.
+type Pusher[T any] interface {
+	Push(value T)
+}
+
+// Popper removes values from a container. ok is false if it was empty.
+type Popper[T any] interface {
+	Pop() (value T, ok bool)
+}
+
+// Container is a stack or a queue. Len may be an approximation while other
+// goroutines push and pop.
+type Container[T any] interface {
+	Pusher[T]
+	Popper[T]
+	Len() int
+}
+
+// DoubleEnded is a deque, pushed and popped at both ends.
+type DoubleEnded[T any] interface {
+	PushBack(value T)
+	PushFront(value T)
+	PopBack() (value T, ok bool)
+	PopFront() (value T, ok bool)
+	Len() int
+}